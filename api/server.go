@@ -2,15 +2,27 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/IGLOU-EU/go-wildcard/v2"
 	"github.com/voc/srtrelay/config"
+	"github.com/voc/srtrelay/dash"
+	"github.com/voc/srtrelay/hls"
+	"github.com/voc/srtrelay/record"
+	"github.com/voc/srtrelay/relay"
+	"github.com/voc/srtrelay/source"
 	"github.com/voc/srtrelay/srt"
+	"github.com/voc/srtrelay/stream"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -20,6 +32,9 @@ import (
 type Server struct {
 	conf      config.APIConfig
 	srtServer srt.Server
+	recorder  *record.Recorder // nil if recording is disabled
+	sources   *source.Manager  // nil if disabled
+	pusher    *srt.Pusher      // nil if disabled
 	done      sync.WaitGroup
 }
 
@@ -32,14 +47,28 @@ func NewServer(conf config.APIConfig, srtServer srt.Server) *Server {
 	}
 }
 
+// WithRecorder enables the recording endpoints
+func (s *Server) WithRecorder(recorder *record.Recorder) *Server {
+	s.recorder = recorder
+	return s
+}
+
+// WithSources enables the source endpoints
+func (s *Server) WithSources(sources *source.Manager) *Server {
+	s.sources = sources
+	return s
+}
+
+// WithPusher enables the push target endpoints
+func (s *Server) WithPusher(pusher *srt.Pusher) *Server {
+	s.pusher = pusher
+	return s
+}
+
 func (s *Server) Listen(ctx context.Context) error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/streams", s.HandleStreams)
-	mux.HandleFunc("/sockets", s.HandleSockets)
-	mux.Handle("/metrics", promhttp.Handler())
 	serv := &http.Server{
 		Addr:           s.conf.Address,
-		Handler:        mux,
+		Handler:        s.routes(),
 		ReadTimeout:    5 * time.Second,
 		WriteTimeout:   5 * time.Second,
 		MaxHeaderBytes: 1 << 14,
@@ -69,11 +98,62 @@ func (s *Server) Listen(ctx context.Context) error {
 	return nil
 }
 
+// routes registers the endpoints, control endpoints only if a token is set
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/streams", s.HandleStreams)
+	mux.HandleFunc("GET /streams/{file}", s.HandleStreamTS)
+	mux.HandleFunc("GET /streams/{name}/health", s.HandleHealth)
+	mux.HandleFunc("GET /hls/{name}/index.m3u8", s.HandlePlaylist)
+	mux.HandleFunc("GET /hls/{name}/{segment}", s.HandleSegment)
+	mux.HandleFunc("GET /dash/{name}/manifest.mpd", s.HandleManifest)
+	mux.HandleFunc("GET /dash/{name}/{file}", s.HandleFragment)
+	mux.HandleFunc("/sockets", s.HandleSockets)
+	if s.recorder != nil {
+		mux.HandleFunc("GET /recordings", s.HandleRecordings)
+	}
+	if s.sources != nil {
+		mux.HandleFunc("GET /sources", s.HandleSources)
+	}
+	// endpoints controlling the relay require the API token
+	if s.conf.Token == "" {
+		log.Println("API token not set, disabled control endpoints")
+	}
+	if s.recorder != nil && s.conf.Token != "" {
+		mux.HandleFunc("POST /streams/{name}/recording", s.authorized(s.HandleStartRecording))
+		mux.HandleFunc("DELETE /streams/{name}/recording", s.authorized(s.HandleStopRecording))
+	}
+	if s.sources != nil && s.conf.Token != "" {
+		mux.HandleFunc("POST /sources/{name}/start", s.authorized(s.HandleStartSource))
+		mux.HandleFunc("POST /sources/{name}/stop", s.authorized(s.HandleStopSource))
+	}
+	if s.pusher != nil && s.conf.Token != "" {
+		mux.HandleFunc("GET /push", s.authorized(s.HandlePushTargets))
+		mux.HandleFunc("POST /push", s.authorized(s.HandleAddPushTarget))
+		mux.HandleFunc("DELETE /push/{name}", s.authorized(s.HandleRemovePushTarget))
+	}
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
 // Wait blocks until listening sockets have been closed
 func (s *Server) Wait() {
 	s.done.Wait()
 }
 
+// authorized only passes requests bearing the API token to next
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.conf.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid API token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
 func (s *Server) HandleStreams(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	stats := s.srtServer.GetStatistics()
@@ -82,6 +162,249 @@ func (s *Server) HandleStreams(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandleStreamTS serves a stream as continuous MPEG-TS over HTTP
+// The stream password can be passed in the password query parameter,
+// t=-(seconds) starts playback in the past.
+func (s *Server) HandleStreamTS(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(r.PathValue("file"), ".ts")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	streamid, err := stream.NewStreamID(name, query.Get("password"), stream.ModePlay)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Has("t") {
+		seconds, err := strconv.Atoi(query.Get("t"))
+		if err != nil || seconds > 0 {
+			http.Error(w, "invalid timeshift", http.StatusBadRequest)
+			return
+		}
+		streamid.WithTimeshift(time.Duration(-seconds) * time.Second)
+	}
+
+	// streams are unbounded, disable the server write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Println(err)
+	}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "no-cache")
+	err = s.srtServer.Play(r.Context(), r.RemoteAddr, streamid, &flushWriter{w: w, rc: rc})
+	switch {
+	case errors.Is(err, srt.ErrAccessDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, relay.ErrStreamNotExisting), errors.Is(err, relay.ErrTimeshiftUnavailable):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		log.Printf("%s - %s - %v", r.RemoteAddr, name, err)
+	}
+}
+
+// flushWriter sends every write to the client immediately
+type flushWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (f *flushWriter) Write(b []byte) (int, error) {
+	n, err := f.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, f.rc.Flush()
+}
+
+func (s *Server) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	health, err := s.srtServer.GetHealth(r.PathValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		log.Println(err)
+	}
+}
+
+// HandlePlaylist serves the live HLS playlist of a stream
+// The password query parameter is passed on to the segment URIs.
+// Blocking playlist reloads are requested using the _HLS_msn and _HLS_part
+// query parameters.
+func (s *Server) HandlePlaylist(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	password := query.Get("password")
+	streamid, err := stream.NewStreamID(r.PathValue("name"), password, stream.ModePlay)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := blockingRequest(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if until != nil {
+		extendWriteDeadline(w)
+	}
+
+	playlist, err := s.srtServer.GetPlaylist(r.Context(), streamid, until)
+	if err != nil {
+		segmentError(w, err)
+		return
+	}
+
+	var segmentQuery string
+	if password != "" {
+		segmentQuery = "password=" + url.QueryEscape(password)
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := playlist.Encode(w, segmentQuery); err != nil {
+		log.Println(err)
+	}
+}
+
+// blockingRequest parses the position of a blocking playlist reload
+// Returns nil if the request does not block.
+func blockingRequest(query url.Values) (*hls.Position, error) {
+	if !query.Has("_HLS_msn") {
+		if query.Has("_HLS_part") {
+			return nil, errors.New("_HLS_part requires _HLS_msn")
+		}
+		return nil, nil
+	}
+	msn, err := strconv.ParseUint(query.Get("_HLS_msn"), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid _HLS_msn")
+	}
+	pos := &hls.Position{Sequence: msn, Part: -1}
+	if query.Has("_HLS_part") {
+		pos.Part, err = strconv.Atoi(query.Get("_HLS_part"))
+		if err != nil || pos.Part < 0 {
+			return nil, errors.New("invalid _HLS_part")
+		}
+	}
+	return pos, nil
+}
+
+// HandleSegment serves a HLS segment or part of a stream
+// Requests for the next part block until it is available.
+func (s *Server) HandleSegment(w http.ResponseWriter, r *http.Request) {
+	pos, ok := hls.ParseName(r.PathValue("segment"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	streamid, err := stream.NewStreamID(r.PathValue("name"), r.URL.Query().Get("password"), stream.ModePlay)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	extendWriteDeadline(w)
+
+	data, err := s.srtServer.GetSegment(r.Context(), streamid, pos)
+	if err != nil {
+		segmentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	// sequence numbers restart with the stream
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if _, err := w.Write(data); err != nil {
+		log.Println(err)
+	}
+}
+
+// HandleManifest serves the dynamic MPEG-DASH manifest of a stream
+// The password query parameter is passed on to the segment URIs.
+func (s *Server) HandleManifest(w http.ResponseWriter, r *http.Request) {
+	password := r.URL.Query().Get("password")
+	streamid, err := stream.NewStreamID(r.PathValue("name"), password, stream.ModePlay)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	manifest, err := s.srtServer.GetManifest(streamid)
+	if err != nil {
+		segmentError(w, err)
+		return
+	}
+
+	var segmentQuery string
+	if password != "" {
+		segmentQuery = "password=" + url.QueryEscape(password)
+	}
+	w.Header().Set("Content-Type", "application/dash+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := manifest.Encode(w, segmentQuery); err != nil {
+		log.Println(err)
+	}
+}
+
+// HandleFragment serves a MPEG-DASH init or media segment of a stream
+func (s *Server) HandleFragment(w http.ResponseWriter, r *http.Request) {
+	file, ok := dash.ParseName(r.PathValue("file"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	streamid, err := stream.NewStreamID(r.PathValue("name"), r.URL.Query().Get("password"), stream.ModePlay)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := s.srtServer.GetFragment(streamid, file)
+	if err != nil {
+		segmentError(w, err)
+		return
+	}
+
+	if file.Representation == dash.RepresentationVideo {
+		w.Header().Set("Content-Type", "video/mp4")
+	} else {
+		w.Header().Set("Content-Type", "audio/mp4")
+	}
+	// init segments change with the stream
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if _, err := w.Write(data); err != nil {
+		log.Println(err)
+	}
+}
+
+// extendWriteDeadline disables the server write timeout for blocking requests
+// Blocking is limited by the relay.
+func extendWriteDeadline(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Println(err)
+	}
+}
+
+// segmentError responds with the status matching a HLS or MPEG-DASH request error
+func segmentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, srt.ErrAccessDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, hls.ErrInvalidPosition):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, relay.ErrHLSTimeout):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	}
+}
+
 func (s *Server) HandleSockets(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	stats := s.srtServer.GetSocketStatistics()
@@ -89,3 +412,121 @@ func (s *Server) HandleSockets(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 	}
 }
+
+// HandleRecordings lists the files in the recording directory
+func (s *Server) HandleRecordings(w http.ResponseWriter, r *http.Request) {
+	recordings, err := s.recorder.Recordings()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(recordings); err != nil {
+		log.Println(err)
+	}
+}
+
+// HandleStartRecording starts recording a published stream
+func (s *Server) HandleStartRecording(w http.ResponseWriter, r *http.Request) {
+	err := s.recorder.Start(r.PathValue("name"))
+	switch {
+	case errors.Is(err, record.ErrRecording):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, relay.ErrStreamNotExisting):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleStopRecording stops recording a stream
+func (s *Server) HandleStopRecording(w http.ResponseWriter, r *http.Request) {
+	if err := s.recorder.Stop(r.PathValue("name")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleSources lists the configured sources
+func (s *Server) HandleSources(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.sources.Sources()); err != nil {
+		log.Println(err)
+	}
+}
+
+// HandleStartSource starts publishing a source
+func (s *Server) HandleStartSource(w http.ResponseWriter, r *http.Request) {
+	sourceError(w, s.sources.Start(r.PathValue("name")))
+}
+
+// HandleStopSource stops publishing a source
+func (s *Server) HandleStopSource(w http.ResponseWriter, r *http.Request) {
+	sourceError(w, s.sources.Stop(r.PathValue("name")))
+}
+
+// sourceError maps source errors to HTTP status codes
+func sourceError(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, source.ErrSourceNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, source.ErrRunning), errors.Is(err, source.ErrNotRunning),
+		errors.Is(err, relay.ErrStreamAlreadyExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// HandlePushTargets lists the push targets and their pushed streams
+func (s *Server) HandlePushTargets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.pusher.Targets()); err != nil {
+		log.Println(err)
+	}
+}
+
+// HandleAddPushTarget adds a push target described by the JSON request body
+func (s *Server) HandleAddPushTarget(w http.ResponseWriter, r *http.Request) {
+	var target srt.Target
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<14)).Decode(&target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !slices.ContainsFunc(s.conf.PushHosts, func(pattern string) bool {
+		return wildcard.Match(pattern, u.Hostname())
+	}) {
+		http.Error(w, "push host not allowed", http.StatusForbidden)
+		return
+	}
+	err = s.pusher.Add(target)
+	switch {
+	case errors.Is(err, srt.ErrTargetExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, srt.ErrInvalidTarget), errors.Is(err, srt.ErrInvalidURL):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// HandleRemovePushTarget stops pushing to a target and removes it
+func (s *Server) HandleRemovePushTarget(w http.ResponseWriter, r *http.Request) {
+	if err := s.pusher.Remove(r.PathValue("name")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/voc/srtrelay/config"
+	"github.com/voc/srtrelay/dash"
+	"github.com/voc/srtrelay/hls"
+	"github.com/voc/srtrelay/relay"
+	"github.com/voc/srtrelay/srt"
+	"github.com/voc/srtrelay/stream"
+)
+
+// newTestServer creates an API server with push targets enabled
+// NewServer is not used, it registers the metrics exporter globally.
+func newTestServer(t *testing.T, conf config.APIConfig) *Server {
+	srtServer := srt.NewServer(&srt.Config{
+		Relay: relay.RelayConfig{BufferSize: 50, PacketSize: 1},
+	})
+	pusher := srtServer.NewPusher()
+	t.Cleanup(pusher.Close)
+	s := &Server{conf: conf, srtServer: srtServer}
+	return s.WithPusher(pusher)
+}
+
+func TestServer_Push(t *testing.T) {
+	conf := config.APIConfig{Token: "secret", PushHosts: []string{"*.example.org"}}
+	target := `{"name":"cdn","streams":["live-*"],"url":"srt://ingest.example.org:9000"}`
+	tests := []struct {
+		name   string
+		auth   string
+		body   string
+		status int
+	}{
+		{"NoToken", "", target, http.StatusUnauthorized},
+		{"WrongToken", "Bearer wrong", target, http.StatusUnauthorized},
+		{"NoBearer", "secret", target, http.StatusUnauthorized},
+		{"HostNotAllowed", "Bearer secret", `{"name":"cdn","streams":["live-*"],"url":"srt://attacker.org:9000"}`, http.StatusForbidden},
+		{"Valid", "Bearer secret", target, http.StatusCreated},
+		{"Exists", "Bearer secret", target, http.StatusConflict},
+	}
+	routes := newTestServer(t, conf).routes()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/push", strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("Got status %d, expected %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestServer_NoToken(t *testing.T) {
+	routes := newTestServer(t, config.APIConfig{PushHosts: []string{"*"}}).routes()
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req := httptest.NewRequest(method, "/push", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer ")
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s /push got status %d, expected control endpoints to be disabled", method, rec.Code)
+		}
+	}
+}
+
+// nameServer records the stream requested from the SRT server
+type nameServer struct {
+	srt.Server
+	name string
+}
+
+func (s *nameServer) Play(ctx context.Context, address string, streamid *stream.StreamID, w io.Writer) error {
+	s.name = streamid.Name()
+	return relay.ErrStreamNotExisting
+}
+
+func (s *nameServer) GetPlaylist(ctx context.Context, streamid *stream.StreamID, until *hls.Position) (*hls.Playlist, error) {
+	s.name = streamid.Name()
+	return nil, relay.ErrStreamNotExisting
+}
+
+func (s *nameServer) GetSegment(ctx context.Context, streamid *stream.StreamID, pos hls.Position) ([]byte, error) {
+	s.name = streamid.Name()
+	return nil, relay.ErrStreamNotExisting
+}
+
+func (s *nameServer) GetManifest(streamid *stream.StreamID) (*dash.Manifest, error) {
+	s.name = streamid.Name()
+	return nil, relay.ErrStreamNotExisting
+}
+
+func (s *nameServer) GetFragment(streamid *stream.StreamID, file dash.File) ([]byte, error) {
+	s.name = streamid.Name()
+	return nil, relay.ErrStreamNotExisting
+}
+
+func TestServer_StreamNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{"TS", "/streams/abc.ts", "abc"},
+		{"TSSlash", "/streams/live%2Fabc.ts", "live/abc"},
+		{"HLSPlaylist", "/hls/live%2Fabc/index.m3u8", "live/abc"},
+		{"HLSSegment", "/hls/live%2Fabc/41.ts", "live/abc"},
+		{"DASHManifest", "/dash/live%2Fabc/manifest.mpd", "live/abc"},
+		{"DASHSegment", "/dash/live%2Fabc/video-41.m4s", "live/abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srtServer := &nameServer{}
+			s := &Server{srtServer: srtServer}
+			rec := httptest.NewRecorder()
+			s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != http.StatusNotFound || srtServer.name != tt.expected {
+				t.Errorf("Got status %d for stream %q, expected 404 for %q", rec.Code, srtServer.name, tt.expected)
+			}
+		})
+	}
+}
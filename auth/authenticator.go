@@ -3,5 +3,17 @@ package auth
 import "github.com/voc/srtrelay/stream"
 
 type Authenticator interface {
-	Authenticate(stream.StreamID) bool
+	Authenticate(stream.StreamID) (Options, bool)
+}
+
+// Options are per-stream settings an authenticator may override
+type Options struct {
+	// Policy for publishing to an already published stream, empty uses the configured policy
+	PublisherPolicy string
+
+	// SRT passphrase the connection has to use, empty uses the configured encryption
+	Passphrase string
+
+	// SRT key length in bytes for Passphrase, 0 uses the default
+	PBKeyLen int
 }
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/voc/srtrelay/internal/metrics"
@@ -67,7 +68,11 @@ func NewHTTPAuth(authConfig HTTPAuthConfig) Authenticator {
 // If the response code is 2xx the publish/play is allowed, otherwise it is denied.
 // This should be compatible with nginx-rtmps on_play/on_publish directives.
 // https://github.com/arut/nginx-rtmp-module/wiki/Directives#on_play
-func (h *httpAuth) Authenticate(streamid stream.StreamID) bool {
+// Stream options can be overridden using response headers:
+//   - X-Publisher-Policy: reject, replace or backup
+//   - X-Srt-Passphrase: passphrase the connection has to be encrypted with
+//   - X-Srt-Pbkeylen: key length for the passphrase, 16, 24 or 32
+func (h *httpAuth) Authenticate(streamid stream.StreamID) (Options, bool) {
 	response, err := h.client.PostForm(h.config.URL, url.Values{
 		"call":                 {streamid.Mode().String()},
 		"app":                  {h.config.Application},
@@ -77,13 +82,24 @@ func (h *httpAuth) Authenticate(streamid stream.StreamID) bool {
 	})
 	if err != nil {
 		log.Println("http-auth:", err)
-		return false
+		return Options{}, false
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return false
+		return Options{}, false
 	}
 
-	return true
+	options := Options{
+		PublisherPolicy: response.Header.Get("X-Publisher-Policy"),
+		Passphrase:      response.Header.Get("X-Srt-Passphrase"),
+	}
+	if keylen := response.Header.Get("X-Srt-Pbkeylen"); keylen != "" {
+		options.PBKeyLen, err = strconv.Atoi(keylen)
+		if err != nil {
+			log.Println("http-auth: invalid pbkeylen:", keylen)
+			return Options{}, false
+		}
+	}
+	return options, true
 }
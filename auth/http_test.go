@@ -13,6 +13,20 @@ func serverMock() *httptest.Server {
 	handler.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Ok"))
 	})
+	handler.HandleFunc("/replace", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Publisher-Policy", "replace")
+		w.Write([]byte("Ok"))
+	})
+	handler.HandleFunc("/encrypted", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Srt-Passphrase", "0123456789secret")
+		w.Header().Set("X-Srt-Pbkeylen", "32")
+		w.Write([]byte("Ok"))
+	})
+	handler.HandleFunc("/badkeylen", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Srt-Passphrase", "0123456789secret")
+		w.Header().Set("X-Srt-Pbkeylen", "long")
+		w.Write([]byte("Ok"))
+	})
 	handler.HandleFunc("/unauthorized", func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Not authorized", http.StatusUnauthorized)
 	})
@@ -27,12 +41,16 @@ func Test_httpAuth_Authenticate(t *testing.T) {
 	defer srv.Close()
 
 	tests := []struct {
-		name string
-		url  string
-		want bool
+		name        string
+		url         string
+		want        bool
+		wantOptions Options
 	}{
-		{"AuthOk", "/ok", true},
-		{"AuthFail", "/unauthorized", false},
+		{"AuthOk", "/ok", true, Options{}},
+		{"AuthOptions", "/replace", true, Options{PublisherPolicy: "replace"}},
+		{"AuthEncrypted", "/encrypted", true, Options{Passphrase: "0123456789secret", PBKeyLen: 32}},
+		{"AuthBadKeyLen", "/badkeylen", false, Options{}},
+		{"AuthFail", "/unauthorized", false, Options{}},
 	}
 
 	for _, tt := range tests {
@@ -43,9 +61,13 @@ func Test_httpAuth_Authenticate(t *testing.T) {
 
 			streamid := stream.StreamID{}
 
-			if got := auth.Authenticate(streamid); got != tt.want {
+			options, got := auth.Authenticate(streamid)
+			if got != tt.want {
 				t.Errorf("httpAuth.Authenticate() = %v, want %v", got, tt.want)
 			}
+			if options != tt.wantOptions {
+				t.Errorf("httpAuth.Authenticate() options = %v, want %v", options, tt.wantOptions)
+			}
 		})
 	}
 }
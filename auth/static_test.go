@@ -26,7 +26,7 @@ func TestStaticAuth_Authenticate(t *testing.T) {
 			if err := streamid.FromString(tt.streamid); err != nil {
 				t.Error(err)
 			}
-			if got := auth.Authenticate(streamid); got != tt.want {
+			if _, got := auth.Authenticate(streamid); got != tt.want {
 				t.Errorf("StaticAuth.Authenticate() = %v, want %v", got, tt.want)
 			}
 		})
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -15,9 +16,17 @@ import (
 const MetricsNamespace = "srtrelay"
 
 type Config struct {
-	App  AppConfig
-	Auth AuthConfig
-	API  APIConfig
+	App        AppConfig
+	Auth       AuthConfig
+	API        APIConfig
+	HLS        HLSConfig
+	DASH       DASHConfig
+	Record     RecordConfig
+	Sources    SourcesConfig
+	Fallbacks  []FallbackConfig
+	Push       []PushConfig
+	Encryption []EncryptionConfig
+	Outputs    OutputsConfig
 }
 
 type AppConfig struct {
@@ -32,6 +41,9 @@ type AppConfig struct {
 	// Whether to sync clients to GOP start
 	SyncClients bool
 
+	// time in ms after which unsynced clients fall back to passthrough, 0 disables
+	SyncTimeout uint
+
 	// The value up to which the Reorder Tolerance may grow, 0 by default
 	LossMaxTTL uint
 
@@ -40,6 +52,37 @@ type AppConfig struct {
 
 	// max number of pending connections, default is 10
 	ListenBacklog int
+
+	// time to keep subscribers attached after the publisher left, 0 disables
+	PublisherGracePeriod auth.Duration
+
+	// What happens when publishing to an already published stream: reject,
+	// replace or backup, default is reject
+	PublisherPolicy string
+
+	// time in ms after which a silent publisher is replaced by a backup, default is 1000, has to be above 0
+	FailoverTimeout uint
+
+	// Whether to replay the most recent GOP to new clients
+	GOPCache bool
+
+	// max size of the cached GOP in bytes
+	GOPCacheSize uint
+
+	// Whether to check published MPEG-TS streams for TR 101 290 errors
+	AnalyzeStreams bool
+
+	// time window clients can start playback in the past, 0 disables
+	TimeshiftWindow auth.Duration
+
+	// max size of the timeshift buffer per stream in bytes
+	TimeshiftSize uint
+
+	// SRT passphrase required from all clients, empty disables encryption
+	Passphrase string
+
+	// SRT key length in bytes (16, 24 or 32), 0 uses the default
+	PBKeyLen int
 }
 
 type AuthConfig struct {
@@ -52,6 +95,166 @@ type APIConfig struct {
 	Enabled bool
 	Address string
 	Port    uint
+
+	// bearer token required by the endpoints controlling the relay, empty
+	// disables these endpoints
+	Token string
+
+	// hosts push targets added via the API may point to, supports wildcards
+	PushHosts []string
+}
+
+type HLSConfig struct {
+	Enabled bool
+
+	// minimum segment duration, segments are cut at the next keyframe
+	SegmentDuration auth.Duration
+
+	// maximum duration of LL-HLS parts, 0 disables parts
+	PartDuration auth.Duration
+
+	// number of segments in the playlist
+	WindowSize uint
+}
+
+type DASHConfig struct {
+	Enabled bool
+
+	// minimum segment duration, segments are cut at the next video keyframe
+	SegmentDuration auth.Duration
+
+	// number of segments in the manifest
+	WindowSize uint
+}
+
+type RecordConfig struct {
+	Enabled bool
+
+	// directory recordings are stored in
+	Directory string
+
+	// path of recorded files relative to the directory
+	// {name}, {date} and {time} are replaced when a file is started
+	Path string
+
+	// streams recorded automatically, supports the same wildcards as the
+	// static authenticator
+	Streams []string
+
+	// start a new file at the next keyframe after this duration, 0 disables
+	RotateDuration auth.Duration
+
+	// start a new file at the next keyframe after this size in bytes, 0 disables
+	RotateSize uint64
+}
+
+// SourcesConfig lists the streams published by the relay itself
+type SourcesConfig struct {
+	File []FileSourceConfig
+	SRT  []SRTSourceConfig
+	UDP  []UDPSourceConfig
+	RIST []RISTSourceConfig
+}
+
+type FileSourceConfig struct {
+	// name of the published stream
+	Name string
+
+	// path of the MPEG-TS file, played in a loop
+	Path string
+
+	// start publishing on startup, otherwise the source is started via the API
+	Autostart bool
+}
+
+type SRTSourceConfig struct {
+	// name of the published stream
+	Name string
+
+	// srt:// URL of the remote listener, SRT socket options can be set as
+	// query parameters
+	URL string
+
+	// start publishing on startup, otherwise the source is started via the API
+	Autostart bool
+}
+
+type UDPSourceConfig struct {
+	// name of the published stream
+	Name string
+
+	// udp:// URL to listen on, either a local address or a multicast group
+	URL string
+
+	// start publishing on startup, otherwise the source is started via the API
+	Autostart bool
+}
+
+type RISTSourceConfig struct {
+	// name of the published stream, rist-<port> if empty
+	Name string
+
+	// rist:// URL to listen on, RTP uses the even port, RTCP the port above
+	URL string
+
+	// start publishing on startup, otherwise the source is started via the API
+	Autostart bool
+}
+
+// FallbackConfig describes the stream published while the publishers of
+// matching streams are absent, either another stream or a looped file
+type FallbackConfig struct {
+	// streams the fallback applies to, supports the same wildcards as the
+	// static authenticator
+	Streams []string
+
+	// name of the stream to publish instead
+	Stream string
+
+	// path of a MPEG-TS file to publish instead, played in a loop
+	File string
+
+	// time after which a stream showing the fallback is unpublished, 0 never
+	Timeout auth.Duration
+}
+
+// PushConfig describes a remote SRT listener matching streams are forwarded to
+type PushConfig struct {
+	Name string
+
+	// streams pushed to the target, supports the same wildcards as the
+	// static authenticator
+	Streams []string
+
+	// srt:// URL of the remote listener, SRT socket options can be set as
+	// query parameters
+	URL string
+}
+
+// EncryptionConfig overrides the SRT encryption for matching streams
+type EncryptionConfig struct {
+	// streams the passphrase applies to, supports the same wildcards as the
+	// static authenticator
+	Streams []string
+
+	// SRT passphrase, empty disables encryption for the streams
+	Passphrase string
+
+	// SRT key length in bytes (16, 24 or 32), 0 uses the default
+	PBKeyLen int
+}
+
+// OutputsConfig lists the streams sent elsewhere by the relay
+type OutputsConfig struct {
+	UDP []UDPOutputConfig
+}
+
+type UDPOutputConfig struct {
+	// name of the sent stream
+	Stream string
+
+	// udp:// URL to send to, either a unicast address or a multicast group
+	URL string
 }
 
 // GetAuthenticator creates a new authenticator according to AuthConfig
@@ -87,13 +290,18 @@ func Parse(paths []string) (*Config, error) {
 	// set defaults
 	config := Config{
 		App: AppConfig{
-			Addresses:     []string{"localhost:1337"},
-			Latency:       200,
-			LossMaxTTL:    0,
-			Buffersize:    384000, // 1s @ 3Mbits/s
-			SyncClients:   false,
-			PacketSize:    1316, // max is 1456
-			ListenBacklog: 10,
+			Addresses:       []string{"localhost:1337"},
+			Latency:         200,
+			LossMaxTTL:      0,
+			Buffersize:      384000, // 1s @ 3Mbits/s
+			SyncClients:     false,
+			SyncTimeout:     0,
+			PacketSize:      1316, // max is 1456
+			ListenBacklog:   10,
+			PublisherPolicy: "reject",
+			FailoverTimeout: 1000,
+			GOPCacheSize:    4000000,   // ~10s @ 3Mbits/s
+			TimeshiftSize:   120000000, // ~5min @ 3Mbits/s
 		},
 		Auth: AuthConfig{
 			Type: "static",
@@ -112,6 +320,23 @@ func Parse(paths []string) (*Config, error) {
 			Enabled: true,
 			Address: ":8080",
 		},
+		HLS: HLSConfig{
+			Enabled:         false,
+			SegmentDuration: auth.Duration(2 * time.Second),
+			WindowSize:      6,
+		},
+		DASH: DASHConfig{
+			Enabled:         false,
+			SegmentDuration: auth.Duration(2 * time.Second),
+			WindowSize:      6,
+		},
+		Record: RecordConfig{
+			Enabled:        false,
+			Directory:      "recordings",
+			Path:           "{name}/{date}/{time}.ts",
+			Streams:        []string{},
+			RotateDuration: auth.Duration(time.Hour),
+		},
 	}
 
 	var data []byte
@@ -147,6 +372,10 @@ func Parse(paths []string) (*Config, error) {
 		config.App.Addresses = []string{config.App.Address}
 	}
 
+	if config.App.FailoverTimeout == 0 {
+		return nil, errors.New("failoverTimeout has to be above 0")
+	}
+
 	// guess public address if not set
 	if config.App.PublicAddress == "" {
 		split := strings.Split(config.App.Addresses[0], ":")
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -17,13 +19,42 @@ func TestConfig(t *testing.T) {
 	assert.Equal(t, conf.App.Latency, uint(1337))
 	assert.Equal(t, conf.App.Buffersize, uint(123000))
 	assert.Equal(t, conf.App.SyncClients, true)
+	assert.Equal(t, conf.App.SyncTimeout, uint(2000))
 	assert.Equal(t, conf.App.PacketSize, uint(1456))
 	assert.Equal(t, conf.App.LossMaxTTL, uint(50))
 	assert.Equal(t, conf.App.PublicAddress, "dontlookmeup:5432")
 	assert.Equal(t, conf.App.ListenBacklog, 30)
+	assert.Equal(t, conf.App.PublisherGracePeriod, auth.Duration(time.Second*3))
+	assert.Equal(t, conf.App.PublisherPolicy, "backup")
+	assert.Equal(t, conf.App.FailoverTimeout, uint(500))
+	assert.Equal(t, conf.App.GOPCache, true)
+	assert.Equal(t, conf.App.GOPCacheSize, uint(1000000))
+	assert.Equal(t, conf.App.AnalyzeStreams, true)
+	assert.Equal(t, conf.App.TimeshiftWindow, auth.Duration(time.Minute*5))
+	assert.Equal(t, conf.App.TimeshiftSize, uint(50000000))
+	assert.Equal(t, conf.App.Passphrase, "globalsecret")
+	assert.Equal(t, conf.App.PBKeyLen, 16)
 
 	assert.Equal(t, conf.API.Enabled, false)
 	assert.Equal(t, conf.API.Address, ":1234")
+	assert.Equal(t, conf.API.Token, "apisecret")
+	assert.Equal(t, conf.API.PushHosts[0], "*.example.org")
+
+	assert.Equal(t, conf.HLS.Enabled, true)
+	assert.Equal(t, conf.HLS.SegmentDuration, auth.Duration(time.Second*4))
+	assert.Equal(t, conf.HLS.PartDuration, auth.Duration(time.Millisecond*500))
+	assert.Equal(t, conf.HLS.WindowSize, uint(3))
+
+	assert.Equal(t, conf.DASH.Enabled, true)
+	assert.Equal(t, conf.DASH.SegmentDuration, auth.Duration(time.Second*3))
+	assert.Equal(t, conf.DASH.WindowSize, uint(5))
+
+	assert.Equal(t, conf.Record.Enabled, true)
+	assert.Equal(t, conf.Record.Directory, "/var/lib/srtrelay")
+	assert.Equal(t, conf.Record.Path, "{date}/{name}-{time}.ts")
+	assert.Equal(t, conf.Record.Streams[0], "live/*")
+	assert.Equal(t, conf.Record.RotateDuration, auth.Duration(time.Minute*30))
+	assert.Equal(t, conf.Record.RotateSize, uint64(1000000000))
 
 	assert.Equal(t, conf.Auth.Type, "http")
 	assert.Equal(t, conf.Auth.Static.Allow[0], "play/*")
@@ -31,4 +62,56 @@ func TestConfig(t *testing.T) {
 	assert.Equal(t, conf.Auth.HTTP.Timeout, auth.Duration(time.Second*5))
 	assert.Equal(t, conf.Auth.HTTP.Application, "foo")
 	assert.Equal(t, conf.Auth.HTTP.PasswordParam, "pass")
+
+	assert.Equal(t, len(conf.Sources.File), 2)
+	assert.Equal(t, conf.Sources.File[0].Name, "testcard")
+	assert.Equal(t, conf.Sources.File[0].Path, "/srv/testcard.ts")
+	assert.Equal(t, conf.Sources.File[0].Autostart, true)
+	assert.Equal(t, conf.Sources.File[1].Name, "slate")
+	assert.Equal(t, conf.Sources.File[1].Autostart, false)
+	assert.Equal(t, len(conf.Sources.SRT), 1)
+	assert.Equal(t, conf.Sources.SRT[0].Name, "venue")
+	assert.Equal(t, conf.Sources.SRT[0].URL, "srt://encoder.example.org:9000?streamid=live&latency=500")
+	assert.Equal(t, conf.Sources.SRT[0].Autostart, true)
+	assert.Equal(t, len(conf.Sources.UDP), 1)
+	assert.Equal(t, conf.Sources.UDP[0].Name, "lan")
+	assert.Equal(t, conf.Sources.UDP[0].URL, "udp://239.0.0.1:1234?interface=eth0")
+	assert.Equal(t, conf.Sources.UDP[0].Autostart, false)
+	assert.Equal(t, len(conf.Sources.RIST), 1)
+	assert.Equal(t, conf.Sources.RIST[0].Name, "")
+	assert.Equal(t, conf.Sources.RIST[0].URL, "rist://@:5000?buffer=500")
+	assert.Equal(t, conf.Sources.RIST[0].Autostart, true)
+
+	assert.Equal(t, len(conf.Fallbacks), 2)
+	assert.Equal(t, conf.Fallbacks[0].Streams[1], "event")
+	assert.Equal(t, conf.Fallbacks[0].Stream, "slate")
+	assert.Equal(t, conf.Fallbacks[0].Timeout, auth.Duration(0))
+	assert.Equal(t, conf.Fallbacks[1].File, "/srv/slate.ts")
+	assert.Equal(t, conf.Fallbacks[1].Timeout, auth.Duration(time.Minute*10))
+
+	assert.Equal(t, len(conf.Push), 1)
+	assert.Equal(t, conf.Push[0].Name, "cdn")
+	assert.Equal(t, conf.Push[0].Streams[0], "live/*")
+	assert.Equal(t, conf.Push[0].URL, "srt://ingest.example.org:9000?streamid=foo")
+
+	assert.Equal(t, len(conf.Encryption), 2)
+	assert.Equal(t, conf.Encryption[0].Streams[0], "live/*")
+	assert.Equal(t, conf.Encryption[0].Passphrase, "livesecret")
+	assert.Equal(t, conf.Encryption[0].PBKeyLen, 32)
+	assert.Equal(t, conf.Encryption[1].Streams[0], "public/*")
+	assert.Equal(t, conf.Encryption[1].Passphrase, "")
+
+	assert.Equal(t, len(conf.Outputs.UDP), 1)
+	assert.Equal(t, conf.Outputs.UDP[0].Stream, "live/foo")
+	assert.Equal(t, conf.Outputs.UDP[0].URL, "udp://239.0.0.2:1234?ttl=4")
+}
+
+func TestConfig_FailoverTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("[app]\nfailoverTimeout = 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse([]string{path}); err == nil {
+		t.Error("Expected error for failoverTimeout 0")
+	}
 }
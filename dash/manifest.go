@@ -0,0 +1,220 @@
+package dash
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// presentation delay as a factor of the segment duration
+const delayFactor = 3
+
+// Manifest is a snapshot of the live presentation of a stream
+type Manifest struct {
+	Period            uint64    // incremented on every timeline restart
+	AvailabilityStart time.Time // wall clock time of presentation time zero
+	Published         time.Time
+	SegmentDuration   time.Duration // minimum segment duration
+	BufferDepth       time.Duration // duration of the segment window
+	Representations   []Representation
+}
+
+// Representation describes a track of the stream
+type Representation struct {
+	ID         string
+	Video      bool
+	Codec      string // RFC 6381 codecs parameter
+	Bandwidth  uint64 // peak bits per second in the window
+	Width      int
+	Height     int
+	SampleRate int
+	Channels   int
+	Timescale  uint32
+	Offset     uint64 // media time of presentation time zero
+	Segments   []SegmentInfo
+}
+
+// SegmentInfo describes a segment of a representation
+type SegmentInfo struct {
+	Number   uint64
+	Time     uint64 // decode time in timescale units
+	Duration uint64
+}
+
+// File addresses the init segment or a media segment of a representation
+type File struct {
+	Representation string
+	Init           bool
+	Number         uint64
+}
+
+// InitName returns the manifest relative URI of an init segment
+func InitName(representation string) string {
+	return "init-" + representation + ".mp4"
+}
+
+// SegmentName returns the manifest relative URI of a media segment
+func SegmentName(representation string, number uint64) string {
+	return fmt.Sprintf("%s-%d.m4s", representation, number)
+}
+
+// ParseName parses an init or media segment URI
+func ParseName(name string) (File, bool) {
+	if rep, ok := strings.CutPrefix(name, "init-"); ok {
+		rep, ok = strings.CutSuffix(rep, ".mp4")
+		return File{Representation: rep, Init: true}, ok && rep != ""
+	}
+	name, ok := strings.CutSuffix(name, ".m4s")
+	if !ok {
+		return File{}, false
+	}
+	rep, num, ok := strings.Cut(name, "-")
+	if !ok || rep == "" {
+		return File{}, false
+	}
+	number, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return File{}, false
+	}
+	return File{Representation: rep, Number: number}, true
+}
+
+// MPD elements
+type mpd struct {
+	XMLName                    xml.Name `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Profiles                   string   `xml:"profiles,attr"`
+	Type                       string   `xml:"type,attr"`
+	AvailabilityStartTime      string   `xml:"availabilityStartTime,attr"`
+	PublishTime                string   `xml:"publishTime,attr"`
+	MinimumUpdatePeriod        string   `xml:"minimumUpdatePeriod,attr"`
+	MinBufferTime              string   `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth       string   `xml:"timeShiftBufferDepth,attr"`
+	SuggestedPresentationDelay string   `xml:"suggestedPresentationDelay,attr"`
+	Period                     period
+}
+
+type period struct {
+	ID             string          `xml:"id,attr"`
+	Start          string          `xml:"start,attr"`
+	AdaptationSets []adaptationSet `xml:"AdaptationSet"`
+}
+
+type adaptationSet struct {
+	ID               int    `xml:"id,attr"`
+	ContentType      string `xml:"contentType,attr"`
+	MimeType         string `xml:"mimeType,attr"`
+	SegmentAlignment bool   `xml:"segmentAlignment,attr"`
+	StartWithSAP     int    `xml:"startWithSAP,attr"`
+	SegmentTemplate  segmentTemplate
+	Representation   representation
+}
+
+type segmentTemplate struct {
+	Timescale              uint32          `xml:"timescale,attr"`
+	PresentationTimeOffset uint64          `xml:"presentationTimeOffset,attr"`
+	StartNumber            uint64          `xml:"startNumber,attr"`
+	Initialization         string          `xml:"initialization,attr"`
+	Media                  string          `xml:"media,attr"`
+	Timeline               []timelineEntry `xml:"SegmentTimeline>S"`
+}
+
+type timelineEntry struct {
+	Time     uint64 `xml:"t,attr"`
+	Duration uint64 `xml:"d,attr"`
+}
+
+type representation struct {
+	ID                string         `xml:"id,attr"`
+	Codecs            string         `xml:"codecs,attr"`
+	Bandwidth         uint64         `xml:"bandwidth,attr"`
+	Width             int            `xml:"width,attr,omitempty"`
+	Height            int            `xml:"height,attr,omitempty"`
+	AudioSamplingRate int            `xml:"audioSamplingRate,attr,omitempty"`
+	ChannelConfig     *channelConfig `xml:"AudioChannelConfiguration,omitempty"`
+}
+
+type channelConfig struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       int    `xml:"value,attr"`
+}
+
+// Encode writes the manifest as dynamic MPD
+// query is appended to all segment URIs, e.g. to pass on credentials.
+func (m *Manifest) Encode(w io.Writer, query string) error {
+	if query != "" {
+		query = "?" + strings.ReplaceAll(query, "$", "$$")
+	}
+	doc := mpd{
+		Profiles:                   "urn:mpeg:dash:profile:isoff-live:2011,urn:mpeg:dash:profile:cmaf:2019",
+		Type:                       "dynamic",
+		AvailabilityStartTime:      m.AvailabilityStart.UTC().Format(time.RFC3339Nano),
+		PublishTime:                m.Published.UTC().Format(time.RFC3339Nano),
+		MinimumUpdatePeriod:        formatDuration(m.SegmentDuration),
+		MinBufferTime:              formatDuration(m.SegmentDuration),
+		TimeShiftBufferDepth:       formatDuration(m.BufferDepth),
+		SuggestedPresentationDelay: formatDuration(delayFactor * m.SegmentDuration),
+		Period: period{
+			ID:    strconv.FormatUint(m.Period, 10),
+			Start: "PT0S",
+		},
+	}
+	for i, rep := range m.Representations {
+		set := adaptationSet{
+			ID:               i,
+			SegmentAlignment: true,
+			StartWithSAP:     1,
+			SegmentTemplate: segmentTemplate{
+				Timescale:              rep.Timescale,
+				PresentationTimeOffset: rep.Offset,
+				Initialization:         "init-$RepresentationID$.mp4" + query,
+				Media:                  "$RepresentationID$-$Number$.m4s" + query,
+			},
+			Representation: representation{
+				ID:        rep.ID,
+				Codecs:    rep.Codec,
+				Bandwidth: rep.Bandwidth,
+			},
+		}
+		if rep.Video {
+			set.ContentType, set.MimeType = "video", "video/mp4"
+			set.Representation.Width = rep.Width
+			set.Representation.Height = rep.Height
+		} else {
+			set.ContentType, set.MimeType = "audio", "audio/mp4"
+			set.Representation.AudioSamplingRate = rep.SampleRate
+			set.Representation.ChannelConfig = &channelConfig{
+				SchemeIDURI: "urn:mpeg:dash:23003:3:audio_channel_configuration:2011",
+				Value:       rep.Channels,
+			}
+		}
+		if len(rep.Segments) > 0 {
+			set.SegmentTemplate.StartNumber = rep.Segments[0].Number
+		}
+		for _, seg := range rep.Segments {
+			set.SegmentTemplate.Timeline = append(set.SegmentTemplate.Timeline, timelineEntry{
+				Time:     seg.Time,
+				Duration: seg.Duration,
+			})
+		}
+		doc.Period.AdaptationSets = append(doc.Period.AdaptationSets, set)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// formatDuration formats an ISO 8601 duration in seconds
+func formatDuration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S"
+}
@@ -0,0 +1,266 @@
+package dash
+
+import "encoding/binary"
+
+// sample flags of the track run
+const (
+	flagsSync    = 0x02000000 // depends on no other sample
+	flagsNonSync = 0x01010000 // depends on others, not a sync sample
+)
+
+// unity transformation matrix
+var matrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// boxWriter appends ISO BMFF boxes to a buffer
+// Box sizes are filled in when a box is closed.
+type boxWriter struct {
+	buf  []byte
+	open []int // start offsets of the open boxes
+}
+
+func (w *boxWriter) start(typ string) {
+	w.open = append(w.open, len(w.buf))
+	w.buf = append(w.buf, 0, 0, 0, 0)
+	w.buf = append(w.buf, typ...)
+}
+
+// startFull opens a full box with version and flags
+func (w *boxWriter) startFull(typ string, version byte, flags uint32) {
+	w.start(typ)
+	w.u32(uint32(version)<<24 | flags)
+}
+
+func (w *boxWriter) end() {
+	start := w.open[len(w.open)-1]
+	w.open = w.open[:len(w.open)-1]
+	binary.BigEndian.PutUint32(w.buf[start:], uint32(len(w.buf)-start))
+}
+
+func (w *boxWriter) u16(v uint16)   { w.buf = binary.BigEndian.AppendUint16(w.buf, v) }
+func (w *boxWriter) u32(v uint32)   { w.buf = binary.BigEndian.AppendUint32(w.buf, v) }
+func (w *boxWriter) u64(v uint64)   { w.buf = binary.BigEndian.AppendUint64(w.buf, v) }
+func (w *boxWriter) bytes(b []byte) { w.buf = append(w.buf, b...) }
+func (w *boxWriter) str(s string)   { w.buf = append(w.buf, s...) }
+func (w *boxWriter) zeros(n int)    { w.buf = append(w.buf, make([]byte, n)...) }
+
+// initSegment creates the CMAF header of a track
+func initSegment(t *track) []byte {
+	w := &boxWriter{}
+	w.start("ftyp")
+	w.str("cmfc")
+	w.u32(0)
+	w.str("iso6cmfcdash")
+	w.end()
+
+	w.start("moov")
+	w.startFull("mvhd", 0, 0)
+	w.u32(0) // creation_time
+	w.u32(0) // modification_time
+	w.u32(1000)
+	w.u32(0) // duration
+	w.u32(0x00010000)
+	w.u16(0x0100)
+	w.zeros(10)
+	for _, v := range matrix {
+		w.u32(v)
+	}
+	w.zeros(24)
+	w.u32(t.id + 1) // next_track_ID
+	w.end()
+
+	w.start("trak")
+	w.startFull("tkhd", 0, 0x3) // enabled, in movie
+	w.u32(0)
+	w.u32(0)
+	w.u32(t.id)
+	w.u32(0)
+	w.u32(0) // duration
+	w.zeros(8)
+	w.u16(0) // layer
+	w.u16(0) // alternate_group
+	if t.video {
+		w.u16(0)
+	} else {
+		w.u16(0x0100)
+	}
+	w.u16(0)
+	for _, v := range matrix {
+		w.u32(v)
+	}
+	w.u32(uint32(t.width) << 16)
+	w.u32(uint32(t.height) << 16)
+	w.end()
+
+	w.start("mdia")
+	w.startFull("mdhd", 0, 0)
+	w.u32(0)
+	w.u32(0)
+	w.u32(t.timescale)
+	w.u32(0)
+	w.u16(0x55c4) // "und"
+	w.u16(0)
+	w.end()
+	w.startFull("hdlr", 0, 0)
+	w.u32(0)
+	if t.video {
+		w.str("vide")
+	} else {
+		w.str("soun")
+	}
+	w.zeros(12)
+	w.str("srtrelay\x00")
+	w.end()
+
+	w.start("minf")
+	if t.video {
+		w.startFull("vmhd", 0, 0x1)
+		w.zeros(8)
+	} else {
+		w.startFull("smhd", 0, 0)
+		w.zeros(4)
+	}
+	w.end()
+	w.start("dinf")
+	w.startFull("dref", 0, 0)
+	w.u32(1)
+	w.startFull("url ", 0, 0x1) // media in the same file
+	w.end()
+	w.end()
+	w.end()
+
+	w.start("stbl")
+	w.startFull("stsd", 0, 0)
+	w.u32(1)
+	sampleEntry(w, t)
+	w.end()
+	for _, typ := range []string{"stts", "stsc", "stco"} {
+		w.startFull(typ, 0, 0)
+		w.u32(0)
+		w.end()
+	}
+	w.startFull("stsz", 0, 0)
+	w.u32(0)
+	w.u32(0)
+	w.end()
+	w.end() // stbl
+	w.end() // minf
+	w.end() // mdia
+	w.end() // trak
+
+	w.start("mvex")
+	w.startFull("trex", 0, 0)
+	w.u32(t.id)
+	w.u32(1) // default_sample_description_index
+	w.u32(0)
+	w.u32(0)
+	w.u32(0)
+	w.end()
+	w.end()
+	w.end() // moov
+	return w.buf
+}
+
+// sampleEntry writes the sample description of a track
+func sampleEntry(w *boxWriter, t *track) {
+	w.start(t.format)
+	w.zeros(6)
+	w.u16(1) // data_reference_index
+	if t.video {
+		w.zeros(16)
+		w.u16(uint16(t.width))
+		w.u16(uint16(t.height))
+		w.u32(0x00480000) // 72 dpi
+		w.u32(0x00480000)
+		w.u32(0)
+		w.u16(1) // frame_count
+		w.zeros(32)
+		w.u16(0x0018) // depth
+		w.u16(0xffff)
+		w.start(t.configBox)
+		w.bytes(t.config)
+		w.end()
+		w.end()
+		return
+	}
+
+	w.zeros(8)
+	w.u16(uint16(t.channels))
+	w.u16(16) // samplesize
+	w.zeros(4)
+	w.u32(uint32(t.sampleRate) << 16)
+	w.startFull("esds", 0, 0)
+	decoderConfig := []byte{0x40, 0x15, 0, 0, 0} // AAC, audio stream, buffer size
+	decoderConfig = binary.BigEndian.AppendUint32(decoderConfig, 0)
+	decoderConfig = binary.BigEndian.AppendUint32(decoderConfig, 0)
+	decoderConfig = append(decoderConfig, descriptor(0x05, t.config)...)
+	es := []byte{0, 0, 0} // ES_ID, flags
+	es = append(es, descriptor(0x04, decoderConfig)...)
+	es = append(es, descriptor(0x06, []byte{0x02})...)
+	w.bytes(descriptor(0x03, es))
+	w.end()
+	w.end()
+}
+
+// descriptor encodes an MPEG-4 systems descriptor
+func descriptor(tag byte, body []byte) []byte {
+	b := []byte{tag}
+	// size in 7 bit groups
+	for shift := 21; shift > 0; shift -= 7 {
+		b = append(b, byte(len(body)>>shift)|0x80)
+	}
+	b = append(b, byte(len(body))&0x7f)
+	return append(b, body...)
+}
+
+// fragment creates a CMAF fragment from the samples of a segment
+func fragment(t *track, number uint64, samples []sample) []byte {
+	size := 0
+	for _, s := range samples {
+		size += len(s.data)
+	}
+	w := &boxWriter{buf: make([]byte, 0, size+len(samples)*16+128)}
+	w.start("styp")
+	w.str("msdh")
+	w.u32(0)
+	w.str("msdhmsixcmfs")
+	w.end()
+
+	moof := len(w.buf)
+	w.start("moof")
+	w.startFull("mfhd", 0, 0)
+	w.u32(uint32(number))
+	w.end()
+	w.start("traf")
+	w.startFull("tfhd", 0, 0x020000) // default-base-is-moof
+	w.u32(t.id)
+	w.end()
+	w.startFull("tfdt", 1, 0)
+	w.u64(samples[0].dts)
+	w.end()
+	// data offset, duration, size, flags and composition time offset per sample
+	w.startFull("trun", 1, 0xf01)
+	w.u32(uint32(len(samples)))
+	offset := len(w.buf)
+	w.u32(0)
+	for _, s := range samples {
+		w.u32(s.duration)
+		w.u32(uint32(len(s.data)))
+		if s.keyframe {
+			w.u32(flagsSync)
+		} else {
+			w.u32(flagsNonSync)
+		}
+		w.u32(uint32(s.cto))
+	}
+	w.end()
+	w.end() // traf
+	w.end() // moof
+	binary.BigEndian.PutUint32(w.buf[offset:], uint32(len(w.buf)-moof+8))
+
+	w.start("mdat")
+	for _, s := range samples {
+		w.bytes(s.data)
+	}
+	w.end()
+	return w.buf
+}
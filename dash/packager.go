@@ -0,0 +1,267 @@
+package dash
+
+import (
+	"slices"
+	"time"
+
+	"github.com/voc/srtrelay/mpegts"
+)
+
+const (
+	clockRate     = 90000     // timestamp ticks per second
+	timestampWrap = (1 << 33) // timestamp wrap around
+
+	// timestamp jumps beyond this factor of the segment duration start a new
+	// period
+	maxDurationFactor = 10
+)
+
+// unwrapper extends 33 bit timestamps of all tracks to a shared 64 bit timeline
+// Timestamps slightly behind the last one, e.g. of another track, stay in
+// the previous wrap around.
+type unwrapper struct {
+	last    uint64
+	offset  uint64
+	started bool
+}
+
+func (u *unwrapper) unwrap(ts uint64) uint64 {
+	if !u.started {
+		u.started = true
+		u.offset = timestampWrap // keeps earlier timestamps positive
+		u.last = ts
+	}
+	switch {
+	case ts < u.last && u.last-ts > timestampWrap/2:
+		u.offset += timestampWrap
+	case ts > u.last && ts-u.last > timestampWrap/2:
+		return ts + u.offset - timestampWrap
+	}
+	u.last = ts
+	return ts + u.offset
+}
+
+// Packager remuxes a MPEG-TS stream into CMAF tracks for MPEG-DASH
+// The first H.264 or H.265 stream and the first AAC stream are remuxed into
+// separate representations. Segments are cut at the first video keyframe
+// after the configured duration, audio segments end at the same time.
+// Timestamp jumps, e.g. after a publisher switch, and codec changes start a
+// new period.
+type Packager struct {
+	reader   *mpegts.PESReader
+	duration time.Duration // minimum segment duration
+	window   int           // number of segments to keep per track
+
+	timeline unwrapper
+	tracks   map[uint16]*track // by PID
+	ready    bool              // tracks are set up
+	lead     *track            // track the segments are cut on
+	number   uint64            // number of the first segment of the next period
+	period   uint64            // number of timeline restarts
+	start    time.Time         // wall clock time of the period start
+	origin   uint64            // period start in 90 kHz, 0 until the first keyframe
+	cutTime  uint64            // start of the lead segment in progress
+}
+
+// NewPackager creates a packager for segments of at least duration
+// window is the number of segments kept in the manifest.
+func NewPackager(duration time.Duration, window uint) *Packager {
+	if window == 0 {
+		window = 1
+	}
+	return &Packager{
+		reader:   mpegts.NewPESReader(),
+		duration: duration,
+		window:   int(window),
+		tracks:   make(map[uint16]*track),
+	}
+}
+
+// Push processes a buffer of the stream
+func (p *Packager) Push(data []byte) error {
+	return p.push(data, time.Now())
+}
+
+func (p *Packager) push(data []byte, now time.Time) error {
+	err := p.reader.Read(data, func(pes *mpegts.PES) {
+		if err := p.handle(pes, now); err != nil {
+			// retry once in the new period
+			p.restart()
+			_ = p.handle(pes, now)
+		}
+	})
+	if err != nil {
+		p.reader = mpegts.NewPESReader()
+		p.restart()
+	}
+	return err
+}
+
+// handle remuxes a PES into the samples of its track
+func (p *Packager) handle(pes *mpegts.PES, now time.Time) error {
+	t := p.track(pes.PID)
+	if t == nil || !pes.HasPTS {
+		return nil
+	}
+	dts := p.timeline.unwrap(pes.DTS)
+	// composition offset modulo the timestamp wrap around
+	cto := int32(int64((pes.PTS+timestampWrap-pes.DTS)%timestampWrap<<31) >> 31)
+	samples, err := t.parse(pes, dts, cto)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range samples {
+		if t == p.lead && s.keyframe {
+			p.keyframe(dts, now)
+		}
+		if err := t.push(s, p.window); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// track returns the track of a PID, tracks are set up on the first PES
+func (p *Packager) track(pid uint16) *track {
+	if !p.ready {
+		p.setup()
+	}
+	return p.tracks[pid]
+}
+
+// setup creates the tracks for the first supported video and audio stream
+func (p *Packager) setup() {
+	var video, audio *track
+	for _, info := range p.reader.Streams() {
+		t := newTrack(info)
+		switch {
+		case t == nil:
+		case t.video && (video == nil || t.pid < video.pid):
+			video = t
+		case !t.video && (audio == nil || t.pid < audio.pid):
+			audio = t
+		}
+	}
+	for _, t := range []*track{video, audio} {
+		if t == nil {
+			continue
+		}
+		t.number = p.number
+		t.maxGap = uint64(maxDurationFactor * p.duration.Seconds() * clockRate)
+		p.tracks[t.pid] = t
+	}
+	p.lead = video
+	if p.lead == nil {
+		p.lead = audio
+	}
+	p.ready = true
+}
+
+// keyframe cuts all tracks at a keyframe of the lead track if the segment in
+// progress is long enough
+func (p *Packager) keyframe(dts uint64, now time.Time) {
+	if p.origin == 0 {
+		p.origin = dts
+		p.start = now
+	} else if time.Duration(dts-p.cutTime)*time.Second/clockRate < p.duration {
+		return
+	}
+	p.cutTime = dts
+	for _, t := range p.tracks {
+		t.cuts = append(t.cuts, dts)
+	}
+}
+
+// restart drops all tracks and starts a new period at the next keyframe
+// Segment numbers continue, so URIs of the previous period are not reused.
+func (p *Packager) restart() {
+	for _, t := range p.tracks {
+		p.number = max(p.number, t.number+1)
+	}
+	if p.origin != 0 {
+		p.period++
+	}
+	p.tracks = make(map[uint16]*track)
+	p.ready = false
+	p.lead = nil
+	p.origin = 0
+	p.cutTime = 0
+}
+
+// Manifest returns a snapshot of the presentation or nil if there are no
+// segments yet
+func (p *Packager) Manifest() *Manifest {
+	return p.manifest(time.Now())
+}
+
+func (p *Packager) manifest(now time.Time) *Manifest {
+	m := &Manifest{
+		Period:            p.period,
+		AvailabilityStart: p.start,
+		Published:         now,
+		SegmentDuration:   p.duration,
+		BufferDepth:       time.Duration(p.window) * p.duration,
+	}
+	for _, t := range p.sortedTracks() {
+		if len(t.segments) == 0 {
+			continue
+		}
+		rep := Representation{
+			ID:         t.name,
+			Video:      t.video,
+			Codec:      t.codec,
+			Width:      t.width,
+			Height:     t.height,
+			SampleRate: t.sampleRate,
+			Channels:   t.channels,
+			Timescale:  t.timescale,
+			Offset:     t.scale(p.origin),
+			Segments:   make([]SegmentInfo, 0, len(t.segments)),
+		}
+		for _, seg := range t.segments {
+			rep.Segments = append(rep.Segments, SegmentInfo{
+				Number:   seg.number,
+				Time:     seg.time,
+				Duration: seg.duration,
+			})
+			if seg.duration > 0 {
+				bandwidth := uint64(len(seg.data)) * 8 * uint64(t.timescale) / seg.duration
+				rep.Bandwidth = max(rep.Bandwidth, bandwidth)
+			}
+		}
+		m.Representations = append(m.Representations, rep)
+	}
+	if len(m.Representations) == 0 {
+		return nil
+	}
+	return m
+}
+
+// sortedTracks returns the tracks ordered by track ID
+func (p *Packager) sortedTracks() []*track {
+	tracks := make([]*track, 0, len(p.tracks))
+	for _, t := range p.tracks {
+		tracks = append(tracks, t)
+	}
+	slices.SortFunc(tracks, func(a, b *track) int {
+		return int(a.id) - int(b.id)
+	})
+	return tracks
+}
+
+// File returns the init segment or a media segment in the window
+func (p *Packager) File(f File) ([]byte, bool) {
+	for _, t := range p.tracks {
+		if t.name != f.Representation || t.init == nil {
+			continue
+		}
+		if f.Init {
+			return t.init, true
+		}
+		if seg, ok := t.segment(f.Number); ok {
+			return seg.data, true
+		}
+	}
+	return nil, false
+}
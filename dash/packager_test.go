@@ -0,0 +1,221 @@
+package dash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/voc/srtrelay/mpegts"
+)
+
+func TestPackager_Segments(t *testing.T) {
+	tests := []struct {
+		file     string
+		duration time.Duration
+		window   uint
+		codec    string
+		expected []uint64 // segment numbers in the manifest
+	}{
+		// the test files contain three keyframes one second apart
+		{"h264_long.ts", time.Second, 6, "avc1.64080c", []uint64{0, 1}},
+		{"h264_long.ts", time.Second, 1, "avc1.64080c", []uint64{1}},
+		{"h264_long.ts", 3 * time.Second, 6, "", []uint64{}},
+		{"h265_long.ts", time.Second, 6, "hvc1.1.6.L60.90", []uint64{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile("../mpegts/" + tt.file)
+			if err != nil {
+				t.Fatalf("failed to open test file")
+			}
+			data = data[:len(data)/mpegts.PacketLen*mpegts.PacketLen]
+
+			p := NewPackager(tt.duration, tt.window)
+			for len(data) > 0 {
+				n := min(len(data), 7*mpegts.PacketLen)
+				if err := p.push(data[:n], time.Now()); err != nil {
+					t.Fatal(err)
+				}
+				data = data[n:]
+			}
+
+			manifest := p.Manifest()
+			if len(tt.expected) == 0 {
+				if manifest != nil {
+					t.Fatalf("Expected no manifest, got %+v", manifest)
+				}
+				return
+			}
+			if len(manifest.Representations) != 1 {
+				t.Fatalf("Expected a single representation, got %+v", manifest.Representations)
+			}
+			rep := manifest.Representations[0]
+			if rep.ID != RepresentationVideo || rep.Codec != tt.codec || rep.Width != 320 || rep.Height != 180 {
+				t.Errorf("Got representation %+v", rep)
+			}
+			if len(rep.Segments) != len(tt.expected) {
+				t.Fatalf("Expected %d segments, got %+v", len(tt.expected), rep.Segments)
+			}
+
+			init, ok := p.File(File{Representation: rep.ID, Init: true})
+			if !ok {
+				t.Fatal("Init segment not found")
+			}
+			if types := boxTypes(t, init); types != "ftyp moov" {
+				t.Errorf("Got init segment boxes %s", types)
+			}
+			for i, info := range rep.Segments {
+				if info.Number != tt.expected[i] {
+					t.Errorf("Got number %d, expected %d", info.Number, tt.expected[i])
+				}
+				if info.Duration != uint64(tt.duration.Seconds()*clockRate) {
+					t.Errorf("Got duration %d", info.Duration)
+				}
+				if i > 0 && info.Time != rep.Segments[i-1].Time+rep.Segments[i-1].Duration {
+					t.Errorf("Segment %d does not continue the timeline", info.Number)
+				}
+				data, ok := p.File(File{Representation: rep.ID, Number: info.Number})
+				if !ok {
+					t.Fatalf("Segment %d not found", info.Number)
+				}
+				checkFragment(t, data)
+			}
+			if _, ok := p.File(File{Representation: rep.ID, Number: tt.expected[len(tt.expected)-1] + 1}); ok {
+				t.Error("Unfinished segment should not be available")
+			}
+		})
+	}
+}
+
+// boxTypes returns the space separated top level box types
+func boxTypes(t *testing.T, data []byte) string {
+	t.Helper()
+	var types []string
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("Truncated box header")
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			t.Fatalf("Invalid box size %d", size)
+		}
+		types = append(types, string(data[4:8]))
+		data = data[size:]
+	}
+	return strings.Join(types, " ")
+}
+
+// checkFragment verifies the track run of a fragment covers the media data
+// and starts with a sync sample
+func checkFragment(t *testing.T, data []byte) {
+	t.Helper()
+	if types := boxTypes(t, data); types != "styp moof mdat" {
+		t.Fatalf("Got fragment boxes %s", types)
+	}
+	moof := data[binary.BigEndian.Uint32(data):]
+	trun := bytes.Index(moof, []byte("trun"))
+	if trun < 0 {
+		t.Fatal("Missing track run")
+	}
+	run := moof[trun+8:]
+	count := int(binary.BigEndian.Uint32(run))
+	offset := int(binary.BigEndian.Uint32(run[4:]))
+	size := 0
+	for i := range count {
+		entry := run[8+16*i:]
+		size += int(binary.BigEndian.Uint32(entry[4:]))
+		if flags := binary.BigEndian.Uint32(entry[8:]); (i == 0) != (flags == flagsSync) {
+			t.Errorf("Sample %d has flags %x", i, flags)
+		}
+	}
+	mdat := moof[binary.BigEndian.Uint32(moof):]
+	if offset != int(binary.BigEndian.Uint32(moof))+8 || size != len(mdat)-8 {
+		t.Errorf("Track run with offset %d and size %d does not match media data of %d bytes", offset, size, len(mdat))
+	}
+}
+
+func TestTrack_Audio(t *testing.T) {
+	tr := newTrack(mpegts.StreamInfo{PID: 257, StreamType: mpegts.StreamTypeAAC})
+
+	// two AAC LC frames at 48 kHz stereo
+	var data []byte
+	for range 2 {
+		frame := []byte{0xff, 0xf1, 0x4c, 0x80, 0, 0, 0xfc, 0x21, 0x00}
+		frame[4] = byte(len(frame) >> 3)
+		frame[5] = byte(len(frame)<<5) | 0x1f
+		data = append(data, frame...)
+	}
+	samples, err := tr.parse(&mpegts.PES{Data: data}, 90000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.codec != "mp4a.40.2" || tr.timescale != 48000 || tr.channels != 2 {
+		t.Errorf("Got codec %s, timescale %d, channels %d", tr.codec, tr.timescale, tr.channels)
+	}
+	if !bytes.Equal(tr.config, []byte{0x11, 0x90}) {
+		t.Errorf("Got audio specific config %x", tr.config)
+	}
+	if len(samples) != 2 || samples[0].dts != 48000 || samples[1].dts != 48000+aacFrameSamples {
+		t.Fatalf("Got samples %+v", samples)
+	}
+	if types := boxTypes(t, tr.init); types != "ftyp moov" {
+		t.Errorf("Got init segment boxes %s", types)
+	}
+}
+
+func TestManifest_Encode(t *testing.T) {
+	m := &Manifest{
+		AvailabilityStart: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		SegmentDuration:   2 * time.Second,
+		BufferDepth:       12 * time.Second,
+		Representations: []Representation{{
+			ID:        RepresentationVideo,
+			Video:     true,
+			Codec:     "avc1.64001f",
+			Timescale: 90000,
+			Segments:  []SegmentInfo{{Number: 4, Time: 180000, Duration: 180000}},
+		}},
+	}
+	var b strings.Builder
+	if err := m.Encode(&b, "password=a$b"); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc mpd
+	if err := xml.Unmarshal([]byte(b.String()), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Type != "dynamic" || doc.AvailabilityStartTime != "2024-01-01T00:00:00Z" || doc.TimeShiftBufferDepth != "PT12S" {
+		t.Errorf("Got MPD %+v", doc)
+	}
+	tmpl := doc.Period.AdaptationSets[0].SegmentTemplate
+	if tmpl.StartNumber != 4 || tmpl.Media != "$RepresentationID$-$Number$.m4s?password=a$$b" {
+		t.Errorf("Got segment template %+v", tmpl)
+	}
+}
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected File
+		ok       bool
+	}{
+		{"init-video.mp4", File{Representation: "video", Init: true}, true},
+		{"audio-12.m4s", File{Representation: "audio", Number: 12}, true},
+		{"init-.mp4", File{}, false},
+		{"video-x.m4s", File{}, false},
+		{"video-1.ts", File{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, ok := ParseName(tt.name)
+			if ok != tt.ok || (ok && f != tt.expected) {
+				t.Errorf("Got %+v, %v", f, ok)
+			}
+		})
+	}
+}
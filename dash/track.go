@@ -0,0 +1,374 @@
+package dash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/voc/srtrelay/mpegts"
+)
+
+// Representation IDs of the tracks
+const (
+	RepresentationVideo = "video"
+	RepresentationAudio = "audio"
+)
+
+// samples per AAC frame
+const aacFrameSamples = 1024
+
+var (
+	errDiscontinuity = errors.New("timestamp discontinuity")
+	errConfigChanged = errors.New("codec configuration changed")
+)
+
+// NAL unit types of interest
+const (
+	h264NALIDR    = 5
+	h264NALSPS    = 7
+	h264NALPPS    = 8
+	h264NALAUD    = 9
+	h264NALFiller = 12
+
+	h265NALAUD    = 35
+	h265NALFiller = 38
+)
+
+// sample is a single frame of a track
+type sample struct {
+	dts      uint64 // in track timescale
+	cto      int32  // composition time offset
+	duration uint32
+	keyframe bool
+	data     []byte
+}
+
+// segment is a finished CMAF fragment of a track
+type segment struct {
+	number   uint64
+	time     uint64 // decode time of the first sample in track timescale
+	duration uint64
+	data     []byte
+}
+
+// track remuxes an elementary stream into CMAF fragments
+type track struct {
+	id         uint32
+	pid        uint16
+	name       string // representation ID
+	video      bool
+	streamType byte
+	timescale  uint32
+
+	// codec configuration, the init segment is created once it is complete
+	format     string // sample entry type
+	configBox  string // decoder configuration box type of video sample entries
+	config     []byte
+	codec      string // RFC 6381 codecs parameter
+	width      int
+	height     int
+	sampleRate int
+	channels   int
+	params     map[byte][]byte // video parameter sets by NAL unit type
+	init       []byte
+
+	maxGap   uint64   // maximum gap between samples in 90 kHz
+	cuts     []uint64 // segment boundaries in 90 kHz not reached yet
+	active   bool     // first cut reached
+	number   uint64   // number of the segment in progress
+	samples  []sample // samples of the segment in progress
+	segments []*segment
+}
+
+func newTrack(info mpegts.StreamInfo) *track {
+	t := &track{pid: info.PID, streamType: info.StreamType}
+	switch info.StreamType {
+	case mpegts.StreamTypeH264:
+		t.id, t.name, t.video, t.timescale = 1, RepresentationVideo, true, 90000
+		t.format, t.configBox = "avc1", "avcC"
+	case mpegts.StreamTypeH265:
+		t.id, t.name, t.video, t.timescale = 1, RepresentationVideo, true, 90000
+		t.format, t.configBox = "hvc1", "hvcC"
+	case mpegts.StreamTypeAAC:
+		t.id, t.name = 2, RepresentationAudio
+		t.format = "mp4a"
+	default:
+		return nil
+	}
+	t.params = make(map[byte][]byte)
+	return t
+}
+
+// scale converts a 90 kHz time to the track timescale
+func (t *track) scale(ts uint64) uint64 {
+	if t.timescale == 90000 {
+		return ts
+	}
+	return ts * uint64(t.timescale) / 90000
+}
+
+// parse converts a PES into samples
+// dts is the unwrapped decode time in 90 kHz. No samples are returned until
+// the codec configuration is known.
+func (t *track) parse(pes *mpegts.PES, dts uint64, cto int32) ([]sample, error) {
+	if t.video {
+		return t.parseVideo(pes, dts, cto)
+	}
+	return t.parseAudio(pes, dts)
+}
+
+func (t *track) parseVideo(pes *mpegts.PES, dts uint64, cto int32) ([]sample, error) {
+	s := sample{dts: dts, cto: cto}
+	for _, unit := range mpegts.FindNALUnits(pes.Data) {
+		nal := unit.Data
+		if len(nal) == 0 {
+			continue
+		}
+		typ := nal[0] & 0x1f
+		if t.streamType == mpegts.StreamTypeH265 {
+			typ = (nal[0] >> 1) & 0x3f
+		}
+		switch {
+		case t.isParameterSet(typ):
+			// parameter sets are stored in the sample entry
+			if prev, ok := t.params[typ]; ok && t.init != nil && !bytes.Equal(prev, nal) {
+				return nil, errConfigChanged
+			}
+			t.params[typ] = append([]byte{}, nal...)
+			continue
+		case t.streamType == mpegts.StreamTypeH264 && (typ == h264NALAUD || typ == h264NALFiller),
+			t.streamType == mpegts.StreamTypeH265 && (typ == h265NALAUD || typ == h265NALFiller):
+			continue
+		case t.streamType == mpegts.StreamTypeH264 && typ == h264NALIDR,
+			t.streamType == mpegts.StreamTypeH265 && typ >= mpegts.HEVCNALUnitTypeBLAWLP && typ <= mpegts.HEVCNALUnitTypeRSVIRAP23:
+			s.keyframe = true
+		}
+		s.data = binary.BigEndian.AppendUint32(s.data, uint32(len(nal)))
+		s.data = append(s.data, nal...)
+	}
+
+	if t.init == nil {
+		if err := t.configureVideo(); err != nil || t.init == nil {
+			return nil, err
+		}
+	}
+	if len(s.data) == 0 {
+		return nil, nil
+	}
+	return []sample{s}, nil
+}
+
+func (t *track) isParameterSet(typ byte) bool {
+	if t.streamType == mpegts.StreamTypeH264 {
+		return typ == h264NALSPS || typ == h264NALPPS
+	}
+	return typ >= mpegts.HEVCNALUnitTypeVPS && typ <= mpegts.HEVCNALUnitTypePPS
+}
+
+// configureVideo creates the init segment once all parameter sets are known
+func (t *track) configureVideo() error {
+	var sps []byte
+	if t.streamType == mpegts.StreamTypeH264 {
+		pps := t.params[h264NALPPS]
+		sps = t.params[h264NALSPS]
+		if len(sps) < 4 || pps == nil {
+			return nil
+		}
+		t.config = avcC(sps, pps)
+		t.codec = fmt.Sprintf("avc1.%02x%02x%02x", sps[1], sps[2], sps[3])
+	} else {
+		vps := t.params[mpegts.HEVCNALUnitTypeVPS]
+		sps = t.params[mpegts.HEVCNALUnitTypeSPS]
+		pps := t.params[mpegts.HEVCNALUnitTypePPS]
+		if vps == nil || sps == nil || pps == nil {
+			return nil
+		}
+		conf, err := mpegts.ParseHEVCConfig(sps)
+		if err != nil {
+			return err
+		}
+		t.config = hvcC(vps, sps, pps, conf)
+		t.codec = hevcCodec(conf.ProfileTierLevel)
+	}
+
+	info, err := mpegts.ParseSPS(t.streamType, sps)
+	if err != nil {
+		return err
+	}
+	t.width, t.height = info.Width, info.Height
+	t.init = initSegment(t)
+	return nil
+}
+
+// avcC creates an AVC decoder configuration record
+func avcC(sps, pps []byte) []byte {
+	b := []byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1} // 4 byte lengths, one SPS
+	b = binary.BigEndian.AppendUint16(b, uint16(len(sps)))
+	b = append(b, sps...)
+	b = append(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(pps)))
+	return append(b, pps...)
+}
+
+// hvcC creates a HEVC decoder configuration record
+func hvcC(vps, sps, pps []byte, conf mpegts.HEVCConfig) []byte {
+	b := []byte{1}
+	b = append(b, conf.ProfileTierLevel...)
+	b = append(b,
+		0xf0, 0x00, // min_spatial_segmentation_idc
+		0xfc, // parallelismType
+		0xfc|byte(conf.ChromaFormat),
+		0xf8|byte(conf.BitDepthLuma-8),
+		0xf8|byte(conf.BitDepthChroma-8),
+		0, 0, // avgFrameRate
+	)
+	nested := byte(0)
+	if conf.TemporalIDNested {
+		nested = 1
+	}
+	b = append(b, byte(conf.NumTemporalLayers&0x7)<<3|nested<<2|0x3) // 4 byte lengths
+	b = append(b, 3)
+	for _, nal := range [][]byte{vps, sps, pps} {
+		b = append(b, 0x80|(nal[0]>>1)&0x3f, 0, 1) // complete array of one unit
+		b = binary.BigEndian.AppendUint16(b, uint16(len(nal)))
+		b = append(b, nal...)
+	}
+	return b
+}
+
+// hevcCodec formats the codecs parameter from the general profile_tier_level
+func hevcCodec(ptl []byte) string {
+	var b strings.Builder
+	b.WriteString("hvc1.")
+	if space := ptl[0] >> 6; space > 0 {
+		b.WriteByte('A' + space - 1)
+	}
+	fmt.Fprintf(&b, "%d.", ptl[0]&0x1f)
+
+	// compatibility flags in reverse bit order
+	var compat uint32
+	for i, flags := 0, binary.BigEndian.Uint32(ptl[1:5]); i < 32; i++ {
+		compat = compat<<1 | (flags>>i)&0x1
+	}
+	fmt.Fprintf(&b, "%X.", compat)
+
+	if ptl[0]&0x20 == 0 {
+		b.WriteByte('L')
+	} else {
+		b.WriteByte('H')
+	}
+	fmt.Fprintf(&b, "%d", ptl[11])
+
+	// constraint flags without trailing zero bytes
+	constraints := bytes.TrimRight(ptl[5:11], "\x00")
+	for _, c := range constraints {
+		fmt.Fprintf(&b, ".%X", c)
+	}
+	return b.String()
+}
+
+func (t *track) parseAudio(pes *mpegts.PES, dts uint64) ([]sample, error) {
+	frames := mpegts.ParseADTS(pes.Data)
+	if len(frames) == 0 {
+		return nil, nil
+	}
+	first := frames[0]
+	if first.SampleRate == 0 {
+		return nil, nil
+	}
+	config := []byte{
+		byte(first.ObjectType<<3 | first.SampleRateIndex>>1),
+		byte(first.SampleRateIndex<<7 | first.Channels<<3),
+	}
+	if t.init == nil {
+		t.config = config
+		t.codec = fmt.Sprintf("mp4a.40.%d", first.ObjectType)
+		t.timescale = uint32(first.SampleRate)
+		t.sampleRate = first.SampleRate
+		t.channels = first.Channels
+		t.init = initSegment(t)
+	} else if !bytes.Equal(config, t.config) {
+		return nil, errConfigChanged
+	}
+
+	samples := make([]sample, 0, len(frames))
+	start := t.scale(dts)
+	for i, frame := range frames {
+		samples = append(samples, sample{
+			dts:      start + uint64(i*aacFrameSamples),
+			duration: aacFrameSamples,
+			keyframe: true,
+			data:     append([]byte{}, frame.Data...),
+		})
+	}
+	return samples, nil
+}
+
+// push adds a sample to the segment in progress
+// Reaching a cut finishes the segment in progress, samples before the first
+// cut are dropped.
+func (t *track) push(s sample, window int) error {
+	if n := len(t.samples); n > 0 {
+		last := &t.samples[n-1]
+		if s.dts < last.dts || s.dts-last.dts > t.scale(t.maxGap) {
+			return errDiscontinuity
+		}
+		if t.video {
+			last.duration = uint32(s.dts - last.dts)
+		}
+	}
+
+	for len(t.cuts) > 0 && s.dts >= t.scale(t.cuts[0]) {
+		t.finish(t.scale(t.cuts[0]), window)
+		t.cuts = t.cuts[1:]
+		t.active = true
+	}
+	if !t.active {
+		return nil
+	}
+	t.samples = append(t.samples, s)
+	return nil
+}
+
+// finish ends the segment in progress at a time in track timescale and drops
+// segments outside of the window
+func (t *track) finish(end uint64, window int) {
+	if len(t.samples) == 0 {
+		return
+	}
+	last := &t.samples[len(t.samples)-1]
+	if t.video && end > last.dts {
+		last.duration = uint32(end - last.dts)
+	}
+	var duration uint64
+	for _, s := range t.samples {
+		duration += uint64(s.duration)
+	}
+
+	t.segments = append(t.segments, &segment{
+		number:   t.number,
+		time:     t.samples[0].dts,
+		duration: duration,
+		data:     fragment(t, t.number, t.samples),
+	})
+	t.number++
+	t.samples = nil
+	if len(t.segments) > window {
+		t.segments[0] = nil
+		t.segments = t.segments[1:]
+	}
+}
+
+// segment returns a segment in the window by number
+func (t *track) segment(number uint64) (*segment, bool) {
+	if len(t.segments) == 0 {
+		return nil, false
+	}
+	first := t.segments[0].number
+	if number < first || number-first >= uint64(len(t.segments)) {
+		return nil, false
+	}
+	return t.segments[number-first], true
+}
@@ -9,8 +9,9 @@ type TransportType uint
 
 // TransportType constants
 const (
-	Unknown = 0
-	MpegTs  = 1
+	Unknown   = 0
+	MpegTs    = 1
+	RtpMpegTs = 2 // MPEG-TS wrapped in RTP (RFC 2250), see Unwrapper
 )
 
 // Demuxer used for finding synchronization point for onboarding a client
@@ -31,6 +32,9 @@ func DetermineTransport(data []byte) TransportType {
 	if len(data) >= mpegts.HeaderLen && data[0] == mpegts.SyncByte {
 		return MpegTs
 	}
+	if isRTPMpegTs(data) {
+		return RtpMpegTs
+	}
 
 	return Unknown
 }
@@ -57,3 +61,25 @@ func (d *Demuxer) FindInit(data []byte) ([][]byte, error) {
 		return make([][]byte, 0), nil
 	}
 }
+
+// Reset restarts the search for the next synchronization point
+func (d *Demuxer) Reset() {
+	d.parser.Reset()
+}
+
+// Filter drops packets which must not reach a client after the synchronization
+// point, e.g. the remainder of a PES which started before it.
+func (d *Demuxer) Filter(data []byte) ([]byte, error) {
+	if d.transport != MpegTs {
+		return data, nil
+	}
+	return d.parser.Filter(data)
+}
+
+// Streams returns the elementary streams found so far
+func (d *Demuxer) Streams() []mpegts.StreamInfo {
+	if d.transport != MpegTs {
+		return nil
+	}
+	return d.parser.Streams()
+}
@@ -0,0 +1,45 @@
+package format
+
+import (
+	"github.com/voc/srtrelay/mpegts"
+)
+
+// Prober passively analyzes a published stream to report its media info
+type Prober struct {
+	transport TransportType
+	probe     *mpegts.Probe
+}
+
+func NewProber() *Prober {
+	return &Prober{
+		probe: mpegts.NewProbe(),
+	}
+}
+
+// Parse processes a buffer of the stream
+func (p *Prober) Parse(data []byte) error {
+	if p.transport == Unknown {
+		p.transport = DetermineTransport(data)
+	}
+
+	switch p.transport {
+	case MpegTs:
+		return p.probe.Parse(data)
+	case RtpMpegTs:
+		_, payload, err := ParseRTP(data)
+		if err != nil {
+			return err
+		}
+		return p.probe.Parse(payload)
+	default:
+		return nil
+	}
+}
+
+// MediaInfo returns the media info or nil if the transport is not supported
+func (p *Prober) MediaInfo() *mpegts.MediaInfo {
+	if p.transport != MpegTs && p.transport != RtpMpegTs {
+		return nil
+	}
+	return p.probe.MediaInfo()
+}
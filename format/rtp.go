@@ -0,0 +1,185 @@
+package format
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/voc/srtrelay/mpegts"
+)
+
+const (
+	rtpHeaderLen   = 12
+	rtpVersion     = 2
+	rtpPayloadMP2T = 33 // static payload type of MPEG-TS (RFC 3551)
+	rtpClockRate   = 90000
+
+	// packets held back waiting for a missing one before it is skipped
+	rtpReorderWindow = 32
+
+	// sequence number jumps treated as a restarted sender instead of loss
+	rtpMaxJump = 1000
+)
+
+var ErrInvalidRTP = errors.New("invalid rtp packet")
+
+// RTPHeader contains the fields of a RTP packet header used for reordering
+type RTPHeader struct {
+	PayloadType uint8
+	Sequence    uint16
+	Timestamp   uint32
+	SSRC        uint32
+}
+
+// ParseRTP splits a RTP packet into header and payload (RFC 3550)
+func ParseRTP(data []byte) (RTPHeader, []byte, error) {
+	var hdr RTPHeader
+	if len(data) < rtpHeaderLen || data[0]>>6 != rtpVersion {
+		return hdr, nil, ErrInvalidRTP
+	}
+	hdr.PayloadType = data[1] & 0x7f
+	hdr.Sequence = binary.BigEndian.Uint16(data[2:4])
+	hdr.Timestamp = binary.BigEndian.Uint32(data[4:8])
+	hdr.SSRC = binary.BigEndian.Uint32(data[8:12])
+
+	// skip contributing sources and header extension
+	offset := rtpHeaderLen + 4*int(data[0]&0x0f)
+	if data[0]&0x10 != 0 {
+		if len(data) < offset+4 {
+			return hdr, nil, ErrInvalidRTP
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(data[offset+2:offset+4]))
+	}
+	end := len(data)
+	if data[0]&0x20 != 0 && end > 0 {
+		end -= int(data[end-1])
+	}
+	if offset > end {
+		return hdr, nil, ErrInvalidRTP
+	}
+	return hdr, data[offset:end], nil
+}
+
+// isRTPMpegTs reports whether data is a RTP packet carrying MPEG-TS
+func isRTPMpegTs(data []byte) bool {
+	_, payload, err := ParseRTP(data)
+	if err != nil || len(payload) == 0 {
+		return false
+	}
+	return payload[0] == mpegts.SyncByte && len(payload)%mpegts.PacketLen == 0
+}
+
+// Unwrapper normalizes published data to MPEG-TS
+// RTP-wrapped MPEG-TS (RFC 2250) is detected from the first buffer, its
+// packets are put back in order by sequence number and the headers stripped.
+// Other data is passed through unchanged.
+type Unwrapper struct {
+	transport TransportType
+	started   bool
+	ssrc      uint32
+	next      uint16            // next expected sequence number
+	pending   map[uint16][]byte // payloads received out of order
+}
+
+func NewUnwrapper() *Unwrapper {
+	return &Unwrapper{
+		pending: make(map[uint16][]byte),
+	}
+}
+
+// Unwrap returns the buffers which are ready to be published
+func (u *Unwrapper) Unwrap(data []byte) [][]byte {
+	if u.transport == Unknown {
+		u.transport = DetermineTransport(data)
+	}
+	if u.transport != RtpMpegTs {
+		return [][]byte{data}
+	}
+
+	hdr, payload, err := ParseRTP(data)
+	if err != nil {
+		return nil
+	}
+
+	// new sender or restarted sequence
+	diff := int16(hdr.Sequence - u.next)
+	if !u.started || hdr.SSRC != u.ssrc || diff > rtpMaxJump || diff < -rtpMaxJump {
+		u.started = true
+		u.ssrc = hdr.SSRC
+		u.next = hdr.Sequence
+		clear(u.pending)
+	} else if diff < 0 {
+		// late or duplicate
+		return nil
+	}
+	u.pending[hdr.Sequence] = payload
+
+	var res [][]byte
+	for {
+		res = u.flush(res)
+		if len(u.pending) <= rtpReorderWindow {
+			return res
+		}
+		// give up waiting for the missing packet
+		u.next = u.oldestPending()
+	}
+}
+
+// flush appends the pending payloads which are in order
+func (u *Unwrapper) flush(res [][]byte) [][]byte {
+	for {
+		payload, ok := u.pending[u.next]
+		if !ok {
+			return res
+		}
+		delete(u.pending, u.next)
+		u.next++
+		if len(payload) > 0 {
+			res = append(res, payload)
+		}
+	}
+}
+
+// oldestPending returns the pending sequence number closest to next
+func (u *Unwrapper) oldestPending() uint16 {
+	oldest := u.next
+	first := true
+	for seq := range u.pending {
+		if first || seq-u.next < oldest-u.next {
+			oldest = seq
+			first = false
+		}
+	}
+	return oldest
+}
+
+// RTPWrapper wraps MPEG-TS in RTP packets (RFC 2250)
+// The timestamp is taken from the time the packet is wrapped.
+type RTPWrapper struct {
+	start    time.Time
+	sequence uint16
+	ssrc     uint32
+}
+
+func NewRTPWrapper() *RTPWrapper {
+	return &RTPWrapper{
+		start:    time.Now(),
+		sequence: uint16(rand.Uint32()),
+		ssrc:     rand.Uint32(),
+	}
+}
+
+// Wrap returns a RTP packet with the payload
+func (w *RTPWrapper) Wrap(payload []byte) []byte {
+	pkt := make([]byte, rtpHeaderLen+len(payload))
+	pkt[0] = rtpVersion << 6
+	pkt[1] = rtpPayloadMP2T
+	binary.BigEndian.PutUint16(pkt[2:4], w.sequence)
+	ts := uint64(time.Since(w.start).Microseconds()) * rtpClockRate / 1000000
+	binary.BigEndian.PutUint32(pkt[4:8], uint32(ts))
+	binary.BigEndian.PutUint32(pkt[8:12], w.ssrc)
+	copy(pkt[rtpHeaderLen:], payload)
+	w.sequence++
+	return pkt
+}
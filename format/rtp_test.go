@@ -0,0 +1,134 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/voc/srtrelay/mpegts"
+)
+
+// rtpPacket creates a RTP packet whose MPEG-TS payload is marked with seq
+func rtpPacket(w *RTPWrapper, seq uint16) []byte {
+	payload := make([]byte, mpegts.PacketLen)
+	payload[0] = mpegts.SyncByte
+	payload[4] = byte(seq)
+	w.sequence = seq
+	return w.Wrap(payload)
+}
+
+func TestDetermineTransport(t *testing.T) {
+	ts := make([]byte, mpegts.PacketLen)
+	ts[0] = mpegts.SyncByte
+	tests := []struct {
+		name     string
+		data     []byte
+		expected TransportType
+	}{
+		{"MpegTs", ts, MpegTs},
+		{"RtpMpegTs", NewRTPWrapper().Wrap(ts), RtpMpegTs},
+		{"RtpOther", NewRTPWrapper().Wrap([]byte{1, 2, 3}), Unknown},
+		{"Unknown", []byte{1, 2, 3}, Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetermineTransport(tt.data); got != tt.expected {
+				t.Errorf("Got %d, expected %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseRTP(t *testing.T) {
+	payload := []byte{mpegts.SyncByte, 1, 2}
+	pkt := NewRTPWrapper().Wrap(payload)
+
+	// add a contributing source, a header extension and padding
+	ext := append([]byte{}, pkt[:12]...)
+	ext[0] |= 0x20 | 0x10 | 1
+	ext = append(ext, 0, 0, 0, 1)             // CSRC
+	ext = append(ext, 0, 0, 0, 1, 0, 0, 0, 0) // extension of one word
+	ext = append(ext, payload...)
+	ext = append(ext, 0, 2) // padding
+
+	for _, data := range [][]byte{pkt, ext} {
+		hdr, got, err := ParseRTP(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.PayloadType != rtpPayloadMP2T || !bytes.Equal(got, payload) {
+			t.Errorf("Got %+v %x, expected payload %x", hdr, got, payload)
+		}
+	}
+
+	if _, _, err := ParseRTP(pkt[:8]); err != ErrInvalidRTP {
+		t.Errorf("Got %v, expected %v", err, ErrInvalidRTP)
+	}
+}
+
+func TestUnwrapper(t *testing.T) {
+	tests := []struct {
+		name     string
+		seqs     []uint16
+		expected []uint16
+	}{
+		{"InOrder", []uint16{1, 2, 3}, []uint16{1, 2, 3}},
+		{"Reordered", []uint16{1, 3, 2, 4}, []uint16{1, 2, 3, 4}},
+		{"Duplicate", []uint16{1, 2, 2, 1, 3}, []uint16{1, 2, 3}},
+		{"Wraparound", []uint16{65534, 0, 65535, 1}, []uint16{65534, 65535, 0, 1}},
+		{"Restart", []uint16{100, 101, 5000, 5001}, []uint16{100, 101, 5000, 5001}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewRTPWrapper()
+			u := NewUnwrapper()
+			var got []uint16
+			for _, seq := range tt.seqs {
+				for _, buf := range u.Unwrap(rtpPacket(w, seq)) {
+					if len(buf) != mpegts.PacketLen || buf[0] != mpegts.SyncByte {
+						t.Fatalf("Got invalid payload %x", buf)
+					}
+					got = append(got, uint16(buf[4]))
+				}
+			}
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Got %v, expected %v", got, tt.expected)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i]&0xff {
+					t.Errorf("Got %v, expected %v", got, tt.expected)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestUnwrapper_Loss(t *testing.T) {
+	w := NewRTPWrapper()
+	u := NewUnwrapper()
+	var got int
+	got += len(u.Unwrap(rtpPacket(w, 1)))
+
+	// packet 2 is lost, the rest is held back until the window is full
+	for seq := uint16(3); seq < 3+rtpReorderWindow; seq++ {
+		got += len(u.Unwrap(rtpPacket(w, seq)))
+	}
+	if got != 1 {
+		t.Errorf("Got %d payloads while waiting for the lost packet, expected 1", got)
+	}
+	got += len(u.Unwrap(rtpPacket(w, 3+rtpReorderWindow)))
+	if got != 2+rtpReorderWindow {
+		t.Errorf("Got %d payloads after skipping the lost packet, expected %d", got, 2+rtpReorderWindow)
+	}
+}
+
+func TestUnwrapper_Passthrough(t *testing.T) {
+	ts := make([]byte, mpegts.PacketLen)
+	ts[0] = mpegts.SyncByte
+	u := NewUnwrapper()
+	for _, data := range [][]byte{ts, {1, 2, 3}} {
+		if got := u.Unwrap(data); len(got) != 1 || !bytes.Equal(got[0], data) {
+			t.Errorf("Got %x, expected %x", got, data)
+		}
+	}
+}
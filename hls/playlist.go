@@ -0,0 +1,122 @@
+package hls
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Playlist is a snapshot of the live media playlist of a stream
+type Playlist struct {
+	TargetDuration        time.Duration
+	PartTarget            time.Duration // 0 if parts are disabled
+	DiscontinuitySequence uint64        // number of discontinuities before the first segment
+	Segments              []SegmentInfo
+	Next                  *SegmentInfo // segment in progress, only set if parts are enabled
+}
+
+// SegmentInfo describes a segment in the playlist
+type SegmentInfo struct {
+	Sequence      uint64
+	Duration      time.Duration
+	Discontinuity bool
+	Parts         []PartInfo // only listed close to the live edge
+}
+
+// PartInfo describes a part of a segment in the playlist
+type PartInfo struct {
+	Duration    time.Duration
+	Independent bool
+}
+
+// SegmentName returns the playlist relative URI of a segment
+func SegmentName(sequence uint64) string {
+	return fmt.Sprintf("%d.ts", sequence)
+}
+
+// PartName returns the playlist relative URI of a part
+func PartName(sequence uint64, part int) string {
+	return fmt.Sprintf("%d.%d.ts", sequence, part)
+}
+
+// ParseName parses a segment or part URI
+func ParseName(name string) (Position, bool) {
+	name, ok := strings.CutSuffix(name, ".ts")
+	if !ok {
+		return Position{}, false
+	}
+	seq, part, hasPart := strings.Cut(name, ".")
+	sequence, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return Position{}, false
+	}
+	pos := Position{Sequence: sequence, Part: -1}
+	if hasPart {
+		pos.Part, err = strconv.Atoi(part)
+		if err != nil || pos.Part < 0 {
+			return Position{}, false
+		}
+	}
+	return pos, true
+}
+
+// Encode writes the playlist in M3U8 format
+// query is appended to all segment URIs, e.g. to pass on credentials.
+func (p *Playlist) Encode(w io.Writer, query string) error {
+	if query != "" {
+		query = "?" + query
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if p.PartTarget > 0 {
+		b.WriteString("#EXT-X-VERSION:6\n")
+	} else {
+		b.WriteString("#EXT-X-VERSION:3\n")
+	}
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(p.TargetDuration.Seconds())))
+	if p.PartTarget > 0 {
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*p.PartTarget.Seconds())
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", p.PartTarget.Seconds())
+	}
+	switch {
+	case len(p.Segments) > 0:
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", p.Segments[0].Sequence)
+	case p.Next != nil:
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", p.Next.Sequence)
+	}
+	if p.DiscontinuitySequence > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.DiscontinuitySequence)
+	}
+
+	for _, seg := range p.Segments {
+		if seg.Discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		writeParts(&b, seg, query)
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", seg.Duration.Seconds())
+		b.WriteString(SegmentName(seg.Sequence) + query + "\n")
+	}
+	if p.Next != nil {
+		if p.Next.Discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		writeParts(&b, *p.Next, query)
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s%s\"\n", PartName(p.Next.Sequence, len(p.Next.Parts)), query)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeParts(b *strings.Builder, seg SegmentInfo, query string) {
+	for i, part := range seg.Parts {
+		fmt.Fprintf(b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s%s\"", part.Duration.Seconds(), PartName(seg.Sequence, i), query)
+		if part.Independent {
+			b.WriteString(",INDEPENDENT=YES")
+		}
+		b.WriteString("\n")
+	}
+}
@@ -0,0 +1,434 @@
+package hls
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/voc/srtrelay/format"
+	"github.com/voc/srtrelay/mpegts"
+)
+
+const (
+	clockRate     = 90000     // timestamp ticks per second
+	timestampWrap = (1 << 33) // timestamp wrap around
+
+	// timestamp jumps beyond this factor of the segment duration start a new
+	// timeline
+	maxDurationFactor = 10
+
+	// blocking requests wait at most this factor of the target duration
+	blockFactor = 3
+)
+
+var ErrInvalidPosition = errors.New("position too far ahead of the live edge")
+
+// Segment is a finished part of the stream starting at a keyframe
+type Segment struct {
+	Sequence      uint64
+	Duration      time.Duration
+	Discontinuity bool // timeline differs from the previous segment
+	Data          []byte
+	Parts         []*Part // partial segments, empty if disabled
+}
+
+// Part is a partial segment for low latency playback
+type Part struct {
+	Duration    time.Duration
+	Independent bool // starts with a keyframe
+	Data        []byte
+}
+
+// Position addresses a segment or one of its parts
+type Position struct {
+	Sequence uint64
+	Part     int // -1 addresses the whole segment
+}
+
+// timestamp of a frame, the DTS is preferred over the arrival time
+type timestamp struct {
+	dts     uint64
+	hasDTS  bool
+	arrival time.Time
+}
+
+// sub returns the duration between two timestamps
+func (t timestamp) sub(u timestamp) time.Duration {
+	if !t.hasDTS || !u.hasDTS {
+		return t.arrival.Sub(u.arrival)
+	}
+	ticks := (t.dts + timestampWrap - u.dts) % timestampWrap
+	return time.Duration(ticks) * time.Second / clockRate
+}
+
+// Segmenter splits a MPEG-TS stream at keyframes into segments of at least
+// the configured duration and keeps a rolling window of the most recent segments
+// Keyframes are found by the same demuxer used for synchronizing clients, every
+// segment starts with PAT, PMT and the parameter sets. Segment durations are
+// taken from the DTS of the keyframes, falling back to the arrival time.
+// Optionally segments are split further into parts at frame starts for LL-HLS.
+type Segmenter struct {
+	demux    *format.Demuxer
+	duration time.Duration // minimum segment duration
+	part     time.Duration // part target duration, 0 disables parts
+	window   int           // number of segments to keep
+	segments []*Segment    // finished segments, oldest first
+	sequence uint64        // sequence number of the segment in progress
+	target   time.Duration // longest segment duration so far
+	dropped  uint64        // number of discontinuities dropped from the window
+	updated  chan struct{} // closed when a segment or part is finished
+
+	// segment in progress, nil until the first keyframe
+	current       [][]byte
+	starts        map[uint16]int // index of the last PES start per PID
+	discontinuity bool
+	pid           uint16 // PID of the stream the segments are cut on
+	start         timestamp
+
+	// parts of the segment in progress
+	parts     []*Part
+	partStart int // index of the first packet of the part in progress
+	partTime  timestamp
+	lastFrame timestamp
+	frame     time.Duration // duration of the last frame
+}
+
+// NewSegmenter creates a segmenter for segments of at least duration
+// window is the number of segments kept in the playlist.
+func NewSegmenter(duration time.Duration, window uint) *Segmenter {
+	if window == 0 {
+		window = 1
+	}
+	return &Segmenter{
+		demux:    format.NewDemuxer(),
+		duration: duration,
+		window:   int(window),
+		target:   duration,
+		updated:  make(chan struct{}),
+		starts:   make(map[uint16]int),
+	}
+}
+
+// WithParts enables splitting segments into parts of at most duration
+// Parts end at frame starts, so the duration is only met for constant frame rates.
+func (s *Segmenter) WithParts(duration time.Duration) *Segmenter {
+	s.part = duration
+	return s
+}
+
+// Push processes a buffer of the stream
+func (s *Segmenter) Push(data []byte) error {
+	return s.push(data, time.Now())
+}
+
+func (s *Segmenter) push(data []byte, now time.Time) error {
+	pkt := mpegts.Packet{}
+	for ; len(data) > 0; data = data[mpegts.PacketLen:] {
+		if err := pkt.FromBytes(data); err != nil {
+			s.restart()
+			return err
+		}
+		raw := data[:mpegts.PacketLen]
+
+		// feed single packets, so a keyframe can be cut at its PES start
+		init, err := s.demux.FindInit(raw)
+		if err != nil {
+			s.restart()
+			return err
+		}
+		if init == nil {
+			s.append(&pkt, raw, now)
+			continue
+		}
+		s.demux.Reset()
+
+		// unsupported transport
+		if len(init) == 0 {
+			return nil
+		}
+		s.keyframe(&pkt, raw, init, now)
+	}
+	return nil
+}
+
+// keyframe starts a new segment if the current one is long enough
+func (s *Segmenter) keyframe(pkt *mpegts.Packet, raw []byte, init [][]byte, now time.Time) {
+	// init consists of PAT, PMT, the injected parameter sets and the
+	// packets since the start of the keyframe PES up to the current packet
+	pid := pkt.PID()
+	ts := timestamp{arrival: now}
+	for i, b := range init[min(2, len(init)):] {
+		p := mpegts.Packet{}
+		if err := p.FromBytes(b); err != nil {
+			continue
+		}
+		if i == 0 {
+			pid = p.PID()
+		}
+		// the injected parameter sets carry no timestamp
+		if p.PID() == pid {
+			if ts.dts, ts.hasDTS = mpegts.DTS(&p); ts.hasDTS {
+				break
+			}
+		}
+	}
+
+	if s.current != nil {
+		duration := ts.sub(s.start)
+		jumped := duration > maxDurationFactor*s.duration
+		if !jumped && duration < s.duration {
+			s.append(pkt, raw, now)
+			return
+		}
+
+		// the packets since the keyframe PES start belong to the new segment
+		cut := len(s.current)
+		if start, ok := s.starts[pid]; ok && !pkt.PUSI() {
+			cut = start
+		}
+		if jumped {
+			duration = now.Sub(s.start.arrival)
+		}
+		s.finish(cut, duration)
+		s.discontinuity = jumped
+	}
+
+	s.current = make([][]byte, 0, len(init))
+	clear(s.starts)
+	s.pid = pid
+	s.start = ts
+	s.parts = nil
+	s.partStart = 0
+	s.partTime = ts
+	for _, b := range init {
+		p := mpegts.Packet{}
+		if err := p.FromBytes(b); err != nil {
+			continue
+		}
+		s.append(&p, b, now)
+	}
+}
+
+// append adds a packet to the segment in progress
+func (s *Segmenter) append(pkt *mpegts.Packet, raw []byte, now time.Time) {
+	if s.current == nil {
+		return
+	}
+	if pkt.PUSI() {
+		s.starts[pkt.PID()] = len(s.current)
+		if s.part > 0 && pkt.PID() == s.pid {
+			s.frameStart(pkt, now)
+		}
+	}
+	s.current = append(s.current, raw)
+}
+
+// frameStart finishes the part in progress if the next frame would exceed
+// the part target duration
+func (s *Segmenter) frameStart(pkt *mpegts.Packet, now time.Time) {
+	ts := timestamp{arrival: now}
+	ts.dts, ts.hasDTS = mpegts.DTS(pkt)
+	if !s.lastFrame.arrival.IsZero() {
+		s.frame = ts.sub(s.lastFrame)
+	}
+	s.lastFrame = ts
+
+	elapsed := ts.sub(s.partTime)
+	if elapsed > 0 && elapsed+s.frame > s.part {
+		s.finishPart(len(s.current), elapsed)
+		s.partTime = ts
+	}
+}
+
+// finishPart adds the packets up to end as part of the segment in progress
+func (s *Segmenter) finishPart(end int, duration time.Duration) {
+	if end <= s.partStart {
+		return
+	}
+	s.parts = append(s.parts, &Part{
+		Duration:    duration,
+		Independent: s.partStart == 0,
+		Data:        join(s.current[s.partStart:end]),
+	})
+	s.partStart = end
+	s.notify()
+}
+
+// finish ends the segment in progress at the packet index cut and drops
+// segments outside of the window
+func (s *Segmenter) finish(cut int, duration time.Duration) {
+	segment := &Segment{
+		Sequence:      s.sequence,
+		Duration:      duration,
+		Discontinuity: s.discontinuity,
+		Data:          join(s.current[:cut]),
+	}
+	if s.part > 0 {
+		remaining := duration
+		for _, part := range s.parts {
+			remaining -= part.Duration
+		}
+		s.finishPart(cut, max(remaining, 0))
+
+		// share the memory of the segment
+		offset := 0
+		for _, part := range s.parts {
+			n := len(part.Data)
+			part.Data = segment.Data[offset : offset+n : offset+n]
+			offset += n
+		}
+		segment.Parts = s.parts
+	}
+
+	s.segments = append(s.segments, segment)
+	s.sequence++
+	if len(s.segments) > s.window {
+		if s.segments[0].Discontinuity {
+			s.dropped++
+		}
+		s.segments[0] = nil
+		s.segments = s.segments[1:]
+	}
+	// the target duration must not decrease
+	if rounded := time.Duration(math.Round(duration.Seconds())) * time.Second; rounded > s.target {
+		s.target = rounded
+	}
+	s.notify()
+}
+
+func join(packets [][]byte) []byte {
+	data := make([]byte, 0, len(packets)*mpegts.PacketLen)
+	for _, b := range packets {
+		data = append(data, b...)
+	}
+	return data
+}
+
+// notify wakes up blocked requests
+func (s *Segmenter) notify() {
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// restart drops the segment in progress after a stream error
+// The next segment starts a new timeline.
+func (s *Segmenter) restart() {
+	s.demux = format.NewDemuxer()
+	s.current = nil
+	clear(s.starts)
+	s.parts = nil
+	s.lastFrame = timestamp{}
+	s.discontinuity = len(s.segments) > 0
+}
+
+// Updated returns a channel which is closed when the next segment or part
+// is finished
+func (s *Segmenter) Updated() <-chan struct{} {
+	return s.updated
+}
+
+// Close wakes up all blocked requests after the stream ended
+func (s *Segmenter) Close() {
+	s.notify()
+}
+
+// BlockTimeout returns the maximum time to block a request for an upcoming
+// segment or part
+func (s *Segmenter) BlockTimeout() time.Duration {
+	return blockFactor * s.target
+}
+
+// Ready reports whether the playlist contains a position
+// Positions more than two segments ahead of the live edge are invalid.
+func (s *Segmenter) Ready(pos Position) (bool, error) {
+	if pos.Sequence > s.sequence+2 {
+		return false, ErrInvalidPosition
+	}
+	if pos.Sequence < s.sequence {
+		return true, nil
+	}
+	return pos.Sequence == s.sequence && pos.Part >= 0 && pos.Part < len(s.parts), nil
+}
+
+// Pending reports whether a position is the next part to be finished
+func (s *Segmenter) Pending(pos Position) bool {
+	return s.part > 0 && s.current != nil && pos.Sequence == s.sequence && pos.Part == len(s.parts)
+}
+
+// Playlist returns a snapshot of the live playlist or nil if there are no
+// segments yet
+func (s *Segmenter) Playlist() *Playlist {
+	if len(s.segments) == 0 && len(s.parts) == 0 {
+		return nil
+	}
+	p := &Playlist{
+		TargetDuration:        s.target,
+		PartTarget:            s.part,
+		DiscontinuitySequence: s.dropped,
+		Segments:              make([]SegmentInfo, len(s.segments)),
+	}
+	// parts are listed for the segments close to the live edge
+	var age time.Duration
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		seg := s.segments[i]
+		p.Segments[i] = SegmentInfo{
+			Sequence:      seg.Sequence,
+			Duration:      seg.Duration,
+			Discontinuity: seg.Discontinuity,
+		}
+		if age < blockFactor*s.target {
+			p.Segments[i].Parts = partInfos(seg.Parts)
+		}
+		age += seg.Duration
+	}
+	if s.part > 0 && s.current != nil {
+		p.Next = &SegmentInfo{
+			Sequence:      s.sequence,
+			Discontinuity: s.discontinuity,
+			Parts:         partInfos(s.parts),
+		}
+	}
+	return p
+}
+
+func partInfos(parts []*Part) []PartInfo {
+	infos := make([]PartInfo, 0, len(parts))
+	for _, part := range parts {
+		infos = append(infos, PartInfo{Duration: part.Duration, Independent: part.Independent})
+	}
+	return infos
+}
+
+// Data returns a segment in the window or a part by position
+func (s *Segmenter) Data(pos Position) ([]byte, bool) {
+	if pos.Sequence == s.sequence && pos.Part >= 0 {
+		if pos.Part >= len(s.parts) {
+			return nil, false
+		}
+		return s.parts[pos.Part].Data, true
+	}
+
+	seg, ok := s.Segment(pos.Sequence)
+	if !ok {
+		return nil, false
+	}
+	if pos.Part < 0 {
+		return seg.Data, true
+	}
+	if pos.Part >= len(seg.Parts) {
+		return nil, false
+	}
+	return seg.Parts[pos.Part].Data, true
+}
+
+// Segment returns a segment in the window by sequence number
+func (s *Segmenter) Segment(sequence uint64) (*Segment, bool) {
+	if len(s.segments) == 0 {
+		return nil, false
+	}
+	first := s.segments[0].Sequence
+	if sequence < first || sequence-first >= uint64(len(s.segments)) {
+		return nil, false
+	}
+	return s.segments[sequence-first], true
+}
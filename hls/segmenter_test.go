@@ -0,0 +1,278 @@
+package hls
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/voc/srtrelay/mpegts"
+)
+
+func TestSegmenter_Segments(t *testing.T) {
+	tests := []struct {
+		file     string
+		duration time.Duration
+		window   uint
+		expected []uint64 // sequence numbers in the playlist
+	}{
+		// the test files contain three keyframes one second apart
+		{"h264_long.ts", time.Second, 6, []uint64{0, 1}},
+		{"h264_long.ts", time.Second, 1, []uint64{1}},
+		{"h264_long.ts", 1500 * time.Millisecond, 6, []uint64{0}},
+		{"h264_long.ts", 3 * time.Second, 6, []uint64{}},
+		{"h265_long.ts", time.Second, 6, []uint64{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile("../mpegts/" + tt.file)
+			if err != nil {
+				t.Fatalf("failed to open test file")
+			}
+			data = data[:len(data)/mpegts.PacketLen*mpegts.PacketLen]
+
+			s := NewSegmenter(tt.duration, tt.window)
+			for chunk := range slicesOf(data, 7*mpegts.PacketLen) {
+				if err := s.push(chunk, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			playlist := s.Playlist()
+			if len(tt.expected) == 0 {
+				if playlist != nil {
+					t.Fatalf("Expected no playlist, got %+v", playlist)
+				}
+				return
+			}
+			if len(playlist.Segments) != len(tt.expected) {
+				t.Fatalf("Expected %d segments, got %+v", len(tt.expected), playlist.Segments)
+			}
+			for i, info := range playlist.Segments {
+				if info.Sequence != tt.expected[i] {
+					t.Errorf("Got sequence %d, expected %d", info.Sequence, tt.expected[i])
+				}
+				// segments end at the first keyframe after the duration
+				expected := time.Duration(math.Ceil(tt.duration.Seconds())) * time.Second
+				if info.Duration != expected {
+					t.Errorf("Got duration %s, expected %s", info.Duration, expected)
+				}
+				seg, ok := s.Segment(info.Sequence)
+				if !ok {
+					t.Fatalf("Segment %d not found", info.Sequence)
+				}
+				checkSegment(t, seg.Data)
+			}
+			if _, ok := s.Segment(tt.expected[len(tt.expected)-1] + 1); ok {
+				t.Error("Unfinished segment should not be available")
+			}
+		})
+	}
+}
+
+// checkSegment verifies a segment starts with PAT and PMT and contains a
+// single keyframe PES start
+func checkSegment(t *testing.T, data []byte) {
+	t.Helper()
+	if len(data)%mpegts.PacketLen != 0 {
+		t.Fatalf("Segment size %d is not a multiple of the packet size", len(data))
+	}
+	parser := mpegts.NewParser()
+	if err := parser.Parse(data[:2*mpegts.PacketLen]); err != nil {
+		t.Fatal(err)
+	}
+	if len(parser.Streams()) == 0 {
+		t.Fatal("Segment does not start with PAT and PMT")
+	}
+	if err := parser.Parse(data[2*mpegts.PacketLen:]); err != nil {
+		t.Fatal(err)
+	}
+	init, err := parser.InitData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if init == nil {
+		t.Fatal("Segment contains no keyframe")
+	}
+	if n := len(init) * mpegts.PacketLen; n != len(data) {
+		t.Errorf("Segment should start at the keyframe, skipped %d bytes", len(data)-n)
+	}
+}
+
+func slicesOf(data []byte, size int) func(func([]byte) bool) {
+	return func(yield func([]byte) bool) {
+		for len(data) > 0 {
+			n := min(size, len(data))
+			if !yield(data[:n]) {
+				return
+			}
+			data = data[n:]
+		}
+	}
+}
+
+func TestSegmenter_Discontinuity(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatalf("failed to open test file")
+	}
+	data = data[:len(data)/mpegts.PacketLen*mpegts.PacketLen]
+
+	// a stream error drops the segment in progress
+	s := NewSegmenter(time.Second, 6)
+	now := time.Now()
+	if err := s.push(data, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.push([]byte{0}, now); err == nil {
+		t.Fatal("Expected an error for an invalid packet")
+	}
+	if err := s.push(data, now.Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	playlist := s.Playlist()
+	if len(playlist.Segments) != 4 {
+		t.Fatalf("Expected 4 segments, got %+v", playlist.Segments)
+	}
+	for i, seg := range playlist.Segments {
+		if seg.Discontinuity != (i == 2) {
+			t.Errorf("Segment %d has discontinuity %v", i, seg.Discontinuity)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := playlist.Encode(&b, "password=foo"); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:3",
+		"#EXT-X-TARGETDURATION:1",
+		"#EXT-X-MEDIA-SEQUENCE:0",
+		"#EXTINF:1.000,",
+		"0.ts?password=foo",
+		"#EXTINF:1.000,",
+		"1.ts?password=foo",
+		"#EXT-X-DISCONTINUITY",
+		"#EXTINF:1.000,",
+		"2.ts?password=foo",
+		"#EXTINF:1.000,",
+		"3.ts?password=foo",
+		"",
+	}, "\n")
+	if b.String() != expected {
+		t.Errorf("Got playlist\n%s\nexpected\n%s", b.String(), expected)
+	}
+}
+
+func TestSegmenter_Parts(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatalf("failed to open test file")
+	}
+	data = data[:len(data)/mpegts.PacketLen*mpegts.PacketLen]
+
+	const partTarget = 200 * time.Millisecond
+	s := NewSegmenter(time.Second, 6).WithParts(partTarget)
+	updated := s.Updated()
+	if err := s.push(data, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-updated:
+	default:
+		t.Error("Blocked requests should have been woken up")
+	}
+
+	for seq := uint64(0); seq < 2; seq++ {
+		seg, ok := s.Segment(seq)
+		if !ok {
+			t.Fatalf("Segment %d not found", seq)
+		}
+		if len(seg.Parts) < 2 {
+			t.Fatalf("Expected multiple parts, got %d", len(seg.Parts))
+		}
+		var joined []byte
+		var duration time.Duration
+		for i, part := range seg.Parts {
+			if part.Independent != (i == 0) {
+				t.Errorf("Part %d has independent %v", i, part.Independent)
+			}
+			if part.Duration > partTarget {
+				t.Errorf("Part %d duration %s exceeds target", i, part.Duration)
+			}
+			joined = append(joined, part.Data...)
+			duration += part.Duration
+		}
+		if !bytes.Equal(joined, seg.Data) {
+			t.Error("Parts should add up to the segment")
+		}
+		if duration != seg.Duration {
+			t.Errorf("Got total part duration %s, expected %s", duration, seg.Duration)
+		}
+	}
+
+	// the segment in progress is listed with its parts
+	playlist := s.Playlist()
+	if playlist.Next == nil || playlist.Next.Sequence != 2 {
+		t.Fatalf("Expected segment 2 in progress, got %+v", playlist.Next)
+	}
+	next := Position{Sequence: 2, Part: len(playlist.Next.Parts)}
+	if !s.Pending(next) {
+		t.Error("Next part should be pending")
+	}
+	if ready, err := s.Ready(next); ready || err != nil {
+		t.Errorf("Next part should not be ready, got %v, %v", ready, err)
+	}
+	if ready, err := s.Ready(Position{Sequence: 1, Part: -1}); !ready || err != nil {
+		t.Errorf("Finished segment should be ready, got %v, %v", ready, err)
+	}
+	if _, err := s.Ready(Position{Sequence: 5, Part: 0}); err != ErrInvalidPosition {
+		t.Errorf("Got error %v, expected %v", err, ErrInvalidPosition)
+	}
+	if _, ok := s.Data(Position{Sequence: 2, Part: 0}); !ok {
+		t.Error("First part of the segment in progress should be available")
+	}
+
+	var b bytes.Buffer
+	if err := playlist.Encode(&b, ""); err != nil {
+		t.Fatal(err)
+	}
+	m3u8 := b.String()
+	for _, tag := range []string{
+		"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=0.600\n",
+		"#EXT-X-PART-INF:PART-TARGET=0.200\n",
+		"#EXT-X-PART:DURATION=0.200,URI=\"0.0.ts\",INDEPENDENT=YES\n",
+		"#EXT-X-PART:DURATION=0.200,URI=\"2.0.ts\",INDEPENDENT=YES\n",
+		"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"" + PartName(next.Sequence, next.Part) + "\"\n",
+	} {
+		if !strings.Contains(m3u8, tag) {
+			t.Errorf("Playlist is missing %q\n%s", tag, m3u8)
+		}
+	}
+}
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected Position
+		ok       bool
+	}{
+		{"12.ts", Position{Sequence: 12, Part: -1}, true},
+		{"12.3.ts", Position{Sequence: 12, Part: 3}, true},
+		{"12.-1.ts", Position{}, false},
+		{"index.m3u8", Position{}, false},
+		{"a.ts", Position{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, ok := ParseName(tt.name)
+			if ok != tt.ok || pos != tt.expected {
+				t.Errorf("Got %+v, %v, expected %+v, %v", pos, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"log/slog"
@@ -15,11 +17,16 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/IGLOU-EU/go-wildcard/v2"
 	"github.com/haivision/srtgo"
 	"github.com/voc/srtrelay/api"
 	"github.com/voc/srtrelay/config"
+	"github.com/voc/srtrelay/record"
 	"github.com/voc/srtrelay/relay"
+	"github.com/voc/srtrelay/rist"
+	"github.com/voc/srtrelay/source"
 	"github.com/voc/srtrelay/srt"
+	"github.com/voc/srtrelay/udp"
 )
 
 func main() {
@@ -64,6 +71,31 @@ func main() {
 		log.Println(err)
 	}
 
+	var gopCacheSize uint
+	if conf.App.GOPCache {
+		gopCacheSize = conf.App.GOPCacheSize
+	}
+
+	var hlsSegmentDuration, hlsPartDuration time.Duration
+	if conf.HLS.Enabled {
+		hlsSegmentDuration = time.Duration(conf.HLS.SegmentDuration)
+		hlsPartDuration = time.Duration(conf.HLS.PartDuration)
+	}
+
+	var dashSegmentDuration time.Duration
+	if conf.DASH.Enabled {
+		dashSegmentDuration = time.Duration(conf.DASH.SegmentDuration)
+	}
+
+	encryption, err := encryptionConfig(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var publisherPolicy relay.PublisherPolicy
+	if err := publisherPolicy.UnmarshalText([]byte(conf.App.PublisherPolicy)); err != nil {
+		log.Fatal(err)
+	}
+
 	serverConfig := srt.Config{
 		Server: srt.ServerConfig{
 			Addresses:     conf.App.Addresses,
@@ -71,12 +103,28 @@ func main() {
 			Latency:       conf.App.Latency,
 			LossMaxTTL:    conf.App.LossMaxTTL,
 			SyncClients:   conf.App.SyncClients,
+			SyncTimeout:   time.Duration(conf.App.SyncTimeout) * time.Millisecond,
 			Auth:          auth,
 			ListenBacklog: conf.App.ListenBacklog,
+			Passphrase:    conf.App.Passphrase,
+			PBKeyLen:      conf.App.PBKeyLen,
+			Encryption:    encryption,
 		},
 		Relay: relay.RelayConfig{
-			BufferSize: conf.App.Buffersize,
-			PacketSize: conf.App.PacketSize,
+			BufferSize:           conf.App.Buffersize,
+			PacketSize:           conf.App.PacketSize,
+			PublisherGracePeriod: time.Duration(conf.App.PublisherGracePeriod),
+			PublisherPolicy:      publisherPolicy,
+			FailoverTimeout:      time.Duration(conf.App.FailoverTimeout) * time.Millisecond,
+			GOPCacheSize:         gopCacheSize,
+			AnalyzeStreams:       conf.App.AnalyzeStreams,
+			HLSSegmentDuration:   hlsSegmentDuration,
+			HLSPartDuration:      hlsPartDuration,
+			HLSWindowSize:        conf.HLS.WindowSize,
+			DASHSegmentDuration:  dashSegmentDuration,
+			DASHWindowSize:       conf.DASH.WindowSize,
+			TimeshiftWindow:      time.Duration(conf.App.TimeshiftWindow),
+			TimeshiftSize:        conf.App.TimeshiftSize,
 		},
 	}
 
@@ -87,6 +135,113 @@ func main() {
 	// create server
 	srtgo.InitSRT()
 	srtServer := srt.NewServer(&serverConfig)
+
+	// start recording before the first stream is published
+	var recorder *record.Recorder
+	if conf.Record.Enabled {
+		recorder = record.NewRecorder(record.Config{
+			Directory:      conf.Record.Directory,
+			Path:           conf.Record.Path,
+			Streams:        conf.Record.Streams,
+			RotateDuration: time.Duration(conf.Record.RotateDuration),
+			RotateSize:     conf.Record.RotateSize,
+		}, srtServer.Relay())
+	}
+
+	if len(conf.Fallbacks) > 0 {
+		fallbacks, err := fallbackFunc(conf.Fallbacks, srtServer.Relay())
+		if err != nil {
+			log.Fatal(err)
+		}
+		srtServer.Relay().SetFallbacks(fallbacks)
+	}
+
+	// forward streams to remote listeners
+	pusher := srtServer.NewPusher()
+	for _, push := range conf.Push {
+		err := pusher.Add(srt.Target{
+			Name:    push.Name,
+			Streams: push.Streams,
+			URL:     push.URL,
+		})
+		if err != nil {
+			log.Fatalf("push target %s: %v", push.Name, err)
+		}
+	}
+
+	// send streams via UDP
+	outputs := make([]*udp.Output, 0, len(conf.Outputs.UDP))
+	for _, output := range conf.Outputs.UDP {
+		o, err := udp.NewOutput(srtServer.Relay(), output.Stream, output.URL)
+		if err != nil {
+			log.Fatalf("udp output %s: %v", output.Stream, err)
+		}
+		outputs = append(outputs, o)
+	}
+
+	// publish configured sources
+	sources := source.NewManager(srtServer.Relay())
+	for _, file := range conf.Sources.File {
+		if err := sources.Add(file.Name, "file", source.NewFile(file.Path)); err != nil {
+			log.Fatalf("file source %s: %v", file.Name, err)
+		}
+		if !file.Autostart {
+			continue
+		}
+		if err := sources.Start(file.Name); err != nil {
+			log.Printf("file source %s: %v", file.Name, err)
+		}
+	}
+	for _, pull := range conf.Sources.SRT {
+		caller, err := srtServer.NewCaller(pull.Name, pull.URL)
+		if err != nil {
+			log.Fatalf("srt source %s: %v", pull.Name, err)
+		}
+		if err := sources.Add(pull.Name, "srt", caller); err != nil {
+			log.Fatalf("srt source %s: %v", pull.Name, err)
+		}
+		if !pull.Autostart {
+			continue
+		}
+		if err := sources.Start(pull.Name); err != nil {
+			log.Printf("srt source %s: %v", pull.Name, err)
+		}
+	}
+	for _, listen := range conf.Sources.UDP {
+		input, err := udp.NewInput(listen.URL)
+		if err != nil {
+			log.Fatalf("udp source %s: %v", listen.Name, err)
+		}
+		if err := sources.Add(listen.Name, "udp", input); err != nil {
+			log.Fatalf("udp source %s: %v", listen.Name, err)
+		}
+		if !listen.Autostart {
+			continue
+		}
+		if err := sources.Start(listen.Name); err != nil {
+			log.Printf("udp source %s: %v", listen.Name, err)
+		}
+	}
+	for _, contribution := range conf.Sources.RIST {
+		receiver, err := rist.NewReceiver(contribution.URL)
+		if err != nil {
+			log.Fatalf("rist source %s: %v", contribution.URL, err)
+		}
+		name := contribution.Name
+		if name == "" {
+			name = receiver.StreamName()
+		}
+		if err := sources.Add(name, "rist", receiver); err != nil {
+			log.Fatalf("rist source %s: %v", name, err)
+		}
+		if !contribution.Autostart {
+			continue
+		}
+		if err := sources.Start(name); err != nil {
+			log.Printf("rist source %s: %v", name, err)
+		}
+	}
+
 	err = srtServer.Listen(ctx)
 	if err != nil {
 		log.Fatal(err)
@@ -94,7 +249,7 @@ func main() {
 
 	var apiServer *api.Server
 	if conf.API.Enabled {
-		apiServer = api.NewServer(conf.API, srtServer)
+		apiServer = api.NewServer(conf.API, srtServer).WithRecorder(recorder).WithSources(sources).WithPusher(pusher)
 		err := apiServer.Listen(ctx)
 		if err != nil {
 			log.Fatal(err)
@@ -111,6 +266,14 @@ func main() {
 		if apiServer != nil {
 			apiServer.Wait()
 		}
+		sources.Close()
+		pusher.Close()
+		for _, o := range outputs {
+			o.Close()
+		}
+		if recorder != nil {
+			recorder.Close()
+		}
 		close(shutdownDone)
 	}()
 
@@ -122,6 +285,58 @@ func main() {
 	srtgo.CleanupSRT()
 }
 
+// encryptionConfig validates the global and per-stream SRT encryption
+func encryptionConfig(conf *config.Config) ([]srt.Encryption, error) {
+	global := srt.Encryption{Passphrase: conf.App.Passphrase, PBKeyLen: conf.App.PBKeyLen}
+	if err := global.Validate(); err != nil {
+		return nil, err
+	}
+	var res []srt.Encryption
+	for _, e := range conf.Encryption {
+		enc := srt.Encryption{
+			Streams:    e.Streams,
+			Passphrase: e.Passphrase,
+			PBKeyLen:   e.PBKeyLen,
+		}
+		if err := enc.Validate(); err != nil {
+			return nil, fmt.Errorf("encryption for %v: %w", e.Streams, err)
+		}
+		res = append(res, enc)
+	}
+	return res, nil
+}
+
+// fallbackFunc chooses the first configured fallback matching a stream
+// A fallback stream never falls back to itself.
+func fallbackFunc(fallbacks []config.FallbackConfig, r relay.Relay) (relay.FallbackFunc, error) {
+	for _, fallback := range fallbacks {
+		if (fallback.Stream == "") == (fallback.File == "") {
+			return nil, errors.New("fallback needs either a stream or a file")
+		}
+	}
+	return func(name string) *relay.Fallback {
+		for _, fallback := range fallbacks {
+			if fallback.Stream == name {
+				continue
+			}
+			for _, pattern := range fallback.Streams {
+				if !wildcard.Match(pattern, name) {
+					continue
+				}
+				var src relay.Source = source.NewStream(r, fallback.Stream)
+				if fallback.File != "" {
+					src = source.NewFile(fallback.File)
+				}
+				return &relay.Fallback{
+					Source:  src,
+					Timeout: time.Duration(fallback.Timeout),
+				}
+			}
+		}
+		return nil
+	}, nil
+}
+
 func enablePprof(addr string) error {
 	conn, err := net.Listen("tcp", addr)
 	if err != nil {
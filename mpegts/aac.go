@@ -0,0 +1,73 @@
+package mpegts
+
+// ADTS sampling frequencies by index
+var adtsSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// ADTSFrame is a single AAC frame of an ADTS stream
+type ADTSFrame struct {
+	ObjectType      int // MPEG-4 audio object type
+	SampleRateIndex int
+	SampleRate      int // 0 if the index is reserved
+	Channels        int
+	Data            []byte // raw AAC frame without ADTS header
+}
+
+// ParseADTS splits ADTS data into AAC frames
+// Parsing stops at the first invalid or truncated frame.
+func ParseADTS(data []byte) []ADTSFrame {
+	var frames []ADTSFrame
+	for len(data) >= 7 && syncword(data)&0xfff6 == 0xfff0 {
+		header := 7
+		if data[1]&0x1 == 0 {
+			header += 2 // CRC
+		}
+		length := int(data[3]&0x3)<<11 | int(data[4])<<3 | int(data[5]>>5)
+		if length <= header || length > len(data) {
+			break
+		}
+		frame := ADTSFrame{
+			ObjectType:      int(data[2]>>6) + 1,
+			SampleRateIndex: int(data[2]>>2) & 0xf,
+			Channels:        int(data[2]&0x1)<<2 | int(data[3]>>6),
+			Data:            data[header:length],
+		}
+		if frame.SampleRateIndex < len(adtsSampleRates) {
+			frame.SampleRate = adtsSampleRates[frame.SampleRateIndex]
+		}
+		frames = append(frames, frame)
+		data = data[length:]
+	}
+	return frames
+}
+
+// AACParser parser for ADTS AAC audio
+type AACParser struct {
+	info CodecInfo
+}
+
+// Parse checks whether the MPEG-TS packet starts a PES with an ADTS frame
+func (p *AACParser) Parse(pkt *Packet) (bool, error) {
+	data := frameStart(pkt)
+	if len(data) < 7 || syncword(data)&0xfff6 != 0xfff0 {
+		return false, nil
+	}
+
+	p.info.Codec = "aac"
+	if index := int(data[2]>>2) & 0xf; index < len(adtsSampleRates) {
+		p.info.SampleRate = adtsSampleRates[index]
+	}
+	p.info.Channels = int(data[2]&0x1)<<2 | int(data[3]>>6)
+	return true, nil
+}
+
+// ParameterSets returns no data, ADTS frames are self-contained
+func (p *AACParser) ParameterSets() []byte {
+	return []byte{}
+}
+
+func (p *AACParser) Info() CodecInfo {
+	if p.info.Codec == "" {
+		return CodecInfo{Codec: "aac"}
+	}
+	return p.info
+}
@@ -0,0 +1,81 @@
+package mpegts
+
+// AC-3 constants
+var (
+	ac3SampleRates  = []int{48000, 44100, 32000}
+	eac3SampleRates = []int{24000, 22050, 16000}    // reduced sampling rates
+	ac3Channels     = []int{2, 1, 2, 3, 3, 4, 4, 5} // channels by acmod
+)
+
+const ac3SyncWord = 0x0b77
+
+// AC3Parser parser for AC-3 and E-AC-3 audio
+type AC3Parser struct {
+	info CodecInfo
+}
+
+// Parse checks whether the MPEG-TS packet starts a PES with an AC-3 or E-AC-3 frame
+func (p *AC3Parser) Parse(pkt *Packet) (bool, error) {
+	data := frameStart(pkt)
+	if len(data) < 7 || syncword(data) != ac3SyncWord {
+		return false, nil
+	}
+
+	bsid := data[5] >> 3
+	switch {
+	case bsid <= 8:
+		p.parseAC3(data)
+	case bsid <= 16:
+		p.parseEAC3(data)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func (p *AC3Parser) parseAC3(data []byte) {
+	p.info.Codec = "ac3"
+	if fscod := int(data[4] >> 6); fscod < len(ac3SampleRates) {
+		p.info.SampleRate = ac3SampleRates[fscod]
+	}
+
+	// lfeon follows acmod and the optional mix levels
+	acmod := data[6] >> 5
+	bit := 3
+	if acmod&0x1 != 0 && acmod != 1 {
+		bit += 2 // cmixlev
+	}
+	if acmod&0x4 != 0 {
+		bit += 2 // surmixlev
+	}
+	if acmod == 2 {
+		bit += 2 // dsurmod
+	}
+	lfeon := int(data[6+bit/8]>>(7-bit%8)) & 0x1
+	p.info.Channels = ac3Channels[acmod] + lfeon
+}
+
+func (p *AC3Parser) parseEAC3(data []byte) {
+	p.info.Codec = "eac3"
+	fscod := int(data[4] >> 6)
+	if fscod < len(ac3SampleRates) {
+		p.info.SampleRate = ac3SampleRates[fscod]
+	} else if fscod2 := int(data[4]>>4) & 0x3; fscod2 < len(eac3SampleRates) {
+		p.info.SampleRate = eac3SampleRates[fscod2]
+	}
+	acmod := (data[4] >> 1) & 0x7
+	lfeon := int(data[4] & 0x1)
+	p.info.Channels = ac3Channels[acmod] + lfeon
+}
+
+// ParameterSets returns no data, AC-3 frames are self-contained
+func (p *AC3Parser) ParameterSets() []byte {
+	return []byte{}
+}
+
+func (p *AC3Parser) Info() CodecInfo {
+	if p.info.Codec == "" {
+		return CodecInfo{Codec: "ac3"}
+	}
+	return p.info
+}
@@ -0,0 +1,339 @@
+package mpegts
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ETSI TR 101 290 limits
+const (
+	SectionInterval = 500 * time.Millisecond // maximum PAT and PMT repetition interval
+	PCRInterval     = 100 * time.Millisecond // maximum PCR repetition interval
+	PIDTimeout      = 5 * time.Second        // maximum time a referenced PID may be missing
+
+	pcrMaxDelta = 27000000 / 10   // maximum PCR difference in 27 MHz units (100ms)
+	pcrWrap     = (1 << 33) * 300 // PCR wrap around in 27 MHz units
+
+	syncAcquire = 5 // consecutive sync bytes to acquire sync
+	syncLose    = 2 // consecutive corrupted sync bytes to lose sync
+)
+
+// Indicator of ETSI TR 101 290 priority 1 and 2 errors
+type Indicator uint8
+
+// Indicator constants
+const (
+	IndicatorSyncLoss         Indicator = iota // 1.1 TS_sync_loss
+	IndicatorSyncByte                          // 1.2 Sync_byte_error
+	IndicatorPAT                               // 1.3 PAT_error
+	IndicatorContinuity                        // 1.4 Continuity_count_error
+	IndicatorPMT                               // 1.5 PMT_error
+	IndicatorPID                               // 1.6 PID_error
+	IndicatorTransport                         // 2.1 Transport_error
+	IndicatorPCRRepetition                     // 2.3b PCR_repetition_error
+	IndicatorPCRDiscontinuity                  // 2.3a PCR_discontinuity_indicator_error
+	NumIndicators
+)
+
+func (i Indicator) String() string {
+	switch i {
+	case IndicatorSyncLoss:
+		return "ts_sync_loss"
+	case IndicatorSyncByte:
+		return "sync_byte_error"
+	case IndicatorPAT:
+		return "pat_error"
+	case IndicatorContinuity:
+		return "continuity_count_error"
+	case IndicatorPMT:
+		return "pmt_error"
+	case IndicatorPID:
+		return "pid_error"
+	case IndicatorTransport:
+		return "transport_error"
+	case IndicatorPCRRepetition:
+		return "pcr_repetition_error"
+	case IndicatorPCRDiscontinuity:
+		return "pcr_discontinuity_indicator_error"
+	default:
+		return "unknown"
+	}
+}
+
+// Health is a snapshot of the analyzer state
+type Health struct {
+	Synced bool              `json:"synced"`
+	Errors map[string]uint64 `json:"errors"` // error count by indicator
+	PIDs   []PIDHealth       `json:"pids"`
+}
+
+// PIDHealth holds the statistics of a single PID
+type PIDHealth struct {
+	PID              uint16    `json:"pid"`
+	Packets          uint64    `json:"packets"`
+	ContinuityErrors uint64    `json:"continuity_errors"`
+	LastSeen         time.Time `json:"last_seen"`
+}
+
+// pidState tracks continuity and timing of a single PID
+type pidState struct {
+	packets     uint64
+	ccErrors    uint64
+	lastSeen    time.Time
+	hasCC       bool
+	continuity  byte
+	duplicates  int
+	hasPCR      bool
+	pcr         uint64
+	pcrReceived time.Time
+}
+
+// Analyzer checks a MPEG-TS stream for ETSI TR 101 290 priority 1 and 2 errors
+// CRC, CAT and PTS checks are not implemented. Timing is based on the
+// arrival time of the packets.
+type Analyzer struct {
+	parser  *Parser // keeps track of PAT and PMT
+	onError func(Indicator)
+	errors  [NumIndicators]uint64
+	pids    map[uint16]*pidState
+	pmts    map[uint16]time.Time // last arrival of each PMT PID
+	lastPAT time.Time
+	synced  bool
+	good    int // consecutive valid sync bytes
+	bad     int // consecutive corrupted sync bytes
+}
+
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{
+		parser: NewParser(),
+		pids:   make(map[uint16]*pidState),
+		pmts:   make(map[uint16]time.Time),
+	}
+}
+
+// WithErrorHandler sets a function called on every detected error
+func (a *Analyzer) WithErrorHandler(onError func(Indicator)) *Analyzer {
+	a.onError = onError
+	return a
+}
+
+// Parse analyzes all MPEGTS packets from a buffer
+func (a *Analyzer) Parse(data []byte) {
+	a.parse(data, time.Now())
+}
+
+func (a *Analyzer) parse(data []byte, now time.Time) {
+	if a.lastPAT.IsZero() {
+		a.lastPAT = now
+	}
+
+	pkt := Packet{}
+	for ; len(data) >= PacketLen; data = data[PacketLen:] {
+		if !a.checkSync(data[0]) {
+			continue
+		}
+		if err := pkt.FromBytes(data); err != nil {
+			continue
+		}
+		if pkt.TransportError() {
+			a.report(IndicatorTransport)
+		}
+
+		pid := pkt.PID()
+		st := a.pid(pid, now)
+		st.packets++
+		st.lastSeen = now
+
+		if pid != PIDNull && !a.checkContinuity(&pkt, st) {
+			st.ccErrors++
+			a.report(IndicatorContinuity)
+		}
+
+		if pcr, ok := pkt.PCR(); ok {
+			a.checkPCR(&pkt, st, pcr, now)
+		}
+
+		_, isPMT := a.parser.pmtMap[pid]
+		switch {
+		case pid == PIDPAT:
+			a.lastPAT = now
+			if pkt.PUSI() {
+				a.parsePSI(&pkt, TableTypePAT)
+			}
+		case isPMT:
+			a.pmts[pid] = now
+			if pkt.PUSI() {
+				a.parsePSI(&pkt, TableTypePMT)
+			}
+		}
+	}
+	a.checkIntervals(now)
+}
+
+// checkSync counts sync byte errors and tracks synchronization with hysteresis
+func (a *Analyzer) checkSync(b byte) bool {
+	if b == SyncByte {
+		a.good++
+		a.bad = 0
+		if !a.synced && a.good >= syncAcquire {
+			a.synced = true
+		}
+		return true
+	}
+
+	a.report(IndicatorSyncByte)
+	a.bad++
+	a.good = 0
+	if a.synced && a.bad >= syncLose {
+		a.synced = false
+		a.report(IndicatorSyncLoss)
+	}
+	return false
+}
+
+// checkContinuity validates the continuity counter of a packet
+// A single duplicate packet is allowed, packets without payload must not
+// increment the counter.
+func (a *Analyzer) checkContinuity(pkt *Packet, st *pidState) bool {
+	cc := pkt.Continuity()
+	last := st.continuity
+	st.continuity = cc
+	if !st.hasCC || pkt.Discontinuity() {
+		st.hasCC = true
+		st.duplicates = 0
+		return true
+	}
+
+	if !pkt.HasPayload() {
+		return cc == last
+	}
+	if cc == last {
+		st.duplicates++
+		return st.duplicates <= 1
+	}
+	st.duplicates = 0
+	return cc == (last+1)&ContinuityHdrMask
+}
+
+// checkPCR validates the PCR repetition interval and PCR jumps
+func (a *Analyzer) checkPCR(pkt *Packet, st *pidState, pcr uint64, now time.Time) {
+	if st.hasPCR {
+		if now.Sub(st.pcrReceived) > PCRInterval {
+			a.report(IndicatorPCRRepetition)
+		}
+		// negative differences wrap around to large values
+		if delta := (pcr + pcrWrap - st.pcr) % pcrWrap; delta > pcrMaxDelta && !pkt.Discontinuity() {
+			a.report(IndicatorPCRDiscontinuity)
+		}
+	}
+	st.hasPCR = true
+	st.pcr = pcr
+	st.pcrReceived = now
+}
+
+// parsePSI checks the table id and updates the known PIDs
+func (a *Analyzer) parsePSI(pkt *Packet, tableID byte) {
+	payload := pkt.Payload()
+	if len(payload) == 0 || 1+int(payload[0]) >= len(payload) {
+		return
+	}
+	if payload[1+int(payload[0])] != tableID {
+		a.reportTable(tableID)
+		return
+	}
+	// tables spanning multiple packets are not supported, skip them
+	if _, err := a.parser.ParsePSI(payload); errors.Is(err, ErrInvalidPacket) {
+		a.reportTable(tableID)
+	}
+}
+
+// reportTable reports an error in a PAT or PMT
+func (a *Analyzer) reportTable(tableID byte) {
+	if tableID == TableTypePAT {
+		a.report(IndicatorPAT)
+	} else {
+		a.report(IndicatorPMT)
+	}
+}
+
+// checkIntervals reports missing PAT, PMT, PCR and elementary stream PIDs
+// Each missing interval is reported once.
+func (a *Analyzer) checkIntervals(now time.Time) {
+	if now.Sub(a.lastPAT) > SectionInterval {
+		a.report(IndicatorPAT)
+		a.lastPAT = now
+	}
+
+	for pid := range a.parser.pmtMap {
+		last, ok := a.pmts[pid]
+		if !ok {
+			a.pmts[pid] = now
+		} else if now.Sub(last) > SectionInterval {
+			a.report(IndicatorPMT)
+			a.pmts[pid] = now
+		}
+	}
+
+	for _, prog := range a.parser.programs {
+		for _, es := range prog.streams {
+			st := a.pid(es.PID, now)
+			if now.Sub(st.lastSeen) > PIDTimeout {
+				a.report(IndicatorPID)
+				st.lastSeen = now
+			}
+		}
+	}
+
+	for _, st := range a.pids {
+		if st.hasPCR && now.Sub(st.pcrReceived) > PCRInterval {
+			a.report(IndicatorPCRRepetition)
+			st.pcrReceived = now
+		}
+	}
+}
+
+// pid returns the state of a PID, creating it if needed
+func (a *Analyzer) pid(pid uint16, now time.Time) *pidState {
+	st, ok := a.pids[pid]
+	if !ok {
+		st = &pidState{lastSeen: now}
+		a.pids[pid] = st
+	}
+	return st
+}
+
+func (a *Analyzer) report(indicator Indicator) {
+	a.errors[indicator]++
+	if a.onError != nil {
+		a.onError(indicator)
+	}
+}
+
+// Health returns the current error counters and PID statistics
+func (a *Analyzer) Health() *Health {
+	health := &Health{
+		Synced: a.synced,
+		Errors: make(map[string]uint64, NumIndicators),
+		PIDs:   make([]PIDHealth, 0, len(a.pids)),
+	}
+	for i := range NumIndicators {
+		health.Errors[i.String()] = a.errors[i]
+	}
+	for pid, st := range a.pids {
+		// skip referenced PIDs which never occurred
+		if st.packets == 0 {
+			continue
+		}
+		health.PIDs = append(health.PIDs, PIDHealth{
+			PID:              pid,
+			Packets:          st.packets,
+			ContinuityErrors: st.ccErrors,
+			LastSeen:         st.lastSeen,
+		})
+	}
+	sort.Slice(health.PIDs, func(i, j int) bool {
+		return health.PIDs[i].PID < health.PIDs[j].PID
+	})
+	return health
+}
@@ -0,0 +1,119 @@
+package mpegts
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAnalyzer_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(data []byte) []byte
+		expected map[Indicator]uint64
+	}{
+		{"Clean", func(data []byte) []byte {
+			return data
+		}, nil},
+		{"PacketLoss", func(data []byte) []byte {
+			return append(data[:20*PacketLen:20*PacketLen], data[21*PacketLen:]...)
+		}, map[Indicator]uint64{IndicatorContinuity: 1}},
+		{"DuplicatePacket", func(data []byte) []byte {
+			res := append([]byte{}, data[:21*PacketLen]...)
+			return append(res, data[20*PacketLen:]...)
+		}, nil},
+		{"SyncLoss", func(data []byte) []byte {
+			data[20*PacketLen] = 0
+			data[21*PacketLen] = 0
+			return data
+		}, map[Indicator]uint64{IndicatorSyncByte: 2, IndicatorSyncLoss: 1, IndicatorContinuity: 1}},
+		{"TransportError", func(data []byte) []byte {
+			data[20*PacketLen+1] |= 0x80
+			return data
+		}, map[Indicator]uint64{IndicatorTransport: 1}},
+		{"InvalidPMT", corruptPMT, map[Indicator]uint64{IndicatorPMT: 1}},
+	}
+	// the test file carries a PCR only every 200ms
+	const pcrErrors = 14
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile("h264_long.ts")
+			if err != nil {
+				t.Fatalf("failed to open test file")
+			}
+			data = tt.modify(data)
+
+			var reported uint64
+			a := NewAnalyzer().WithErrorHandler(func(Indicator) { reported++ })
+			a.parse(data, time.Now())
+
+			health := a.Health()
+			var total uint64
+			for i := range NumIndicators {
+				got := health.Errors[i.String()]
+				total += got
+				expected := tt.expected[i]
+				if i == IndicatorPCRDiscontinuity {
+					expected = pcrErrors
+				}
+				if got != expected {
+					t.Errorf("Got %d %s, expected %d", got, i, expected)
+				}
+			}
+			if reported != total {
+				t.Errorf("Error handler called %d times, expected %d", reported, total)
+			}
+			if !health.Synced {
+				t.Error("Analyzer should be synced")
+			}
+		})
+	}
+}
+
+func TestAnalyzer_Intervals(t *testing.T) {
+	data, err := os.ReadFile("h264_long.ts")
+	if err != nil {
+		t.Fatalf("failed to open test file")
+	}
+	null := make([]byte, PacketLen)
+	if err := CreatePacket(PIDNull).WithPayload([]byte{}).ToBytes(null); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAnalyzer()
+	start := time.Now()
+	a.parse(data, start)
+
+	// PAT, PMT and PCR are overdue
+	a.parse(null, start.Add(SectionInterval+time.Millisecond))
+	health := a.Health()
+	for _, i := range []Indicator{IndicatorPAT, IndicatorPMT, IndicatorPCRRepetition} {
+		if health.Errors[i.String()] != 1 {
+			t.Errorf("Expected one %s, got %d", i, health.Errors[i.String()])
+		}
+	}
+	if health.Errors[IndicatorPID.String()] != 0 {
+		t.Errorf("Unexpected %s", IndicatorPID)
+	}
+
+	// elementary stream is missing
+	a.parse(null, start.Add(PIDTimeout+time.Millisecond))
+	if got := a.Health().Errors[IndicatorPID.String()]; got != 1 {
+		t.Errorf("Expected one %s, got %d", IndicatorPID, got)
+	}
+
+	expected := []uint16{PIDPAT, 0x11, 0x100, 0x1000, PIDNull}
+	pids := a.Health().PIDs
+	if len(pids) != len(expected) {
+		t.Fatalf("Expected %d PIDs, got %+v", len(expected), pids)
+	}
+	for i := range expected {
+		if pids[i].PID != expected[i] {
+			t.Errorf("Got PID %d, expected %d", pids[i].PID, expected[i])
+		}
+	}
+	if pids[4].Packets != 2 {
+		t.Errorf("Expected 2 null packets, got %d", pids[4].Packets)
+	}
+}
@@ -0,0 +1,124 @@
+package mpegts
+
+import "encoding/binary"
+
+// Descriptor tag constants
+const (
+	DescriptorTagRegistration = 0x05
+	DescriptorTagAC3          = 0x6a
+	DescriptorTagEAC3         = 0x7a
+	DescriptorTagExtension    = 0x7f
+
+	// DVB extension descriptor tag used for Opus
+	ExtensionTagOpus = 0x80
+)
+
+// CodecInfo describes the codec of an elementary stream
+type CodecInfo struct {
+	Codec      string `json:"codec"`
+	Profile    string `json:"profile,omitempty"`
+	Level      string `json:"level,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+}
+
+// StreamInfo describes an elementary stream of a program
+type StreamInfo struct {
+	PID        uint16 `json:"pid"`
+	StreamType byte   `json:"stream_type"`
+	Bitrate    uint64 `json:"bitrate"` // bits per second, only measured by Probe
+	CodecInfo
+}
+
+// ProgramInfo describes a program and its elementary streams
+type ProgramInfo struct {
+	Number  uint16       `json:"number"`
+	PMTPID  uint16       `json:"pmt_pid"`
+	Streams []StreamInfo `json:"streams"`
+}
+
+// descriptors holds the relevant ES descriptors of a PMT entry
+type descriptors struct {
+	registration string // format identifier of the registration descriptor
+	ac3          bool
+	eac3         bool
+	opusChannels int // channel config of the Opus extension descriptor, -1 if missing
+}
+
+// parseDescriptors parses the descriptor loop of a PMT entry
+func parseDescriptors(data []byte) descriptors {
+	desc := descriptors{opusChannels: -1}
+	for len(data) >= 2 {
+		tag := data[0]
+		length := int(data[1])
+		if 2+length > len(data) {
+			break
+		}
+		body := data[2 : 2+length]
+		data = data[2+length:]
+
+		switch tag {
+		case DescriptorTagRegistration:
+			if len(body) >= 4 {
+				desc.registration = string(body[:4])
+			}
+		case DescriptorTagAC3:
+			desc.ac3 = true
+		case DescriptorTagEAC3:
+			desc.eac3 = true
+		case DescriptorTagExtension:
+			if len(body) >= 2 && body[0] == ExtensionTagOpus {
+				desc.opusChannels = int(body[1])
+			}
+		}
+	}
+	return desc
+}
+
+// newCodecParser selects the parser for an elementary stream
+// Returns nil for unsupported streams.
+func newCodecParser(streamType byte, desc descriptors) CodecParser {
+	switch streamType {
+	case StreamTypeH264:
+		return &H264Parser{}
+	case StreamTypeH265:
+		return &H265Parser{}
+	case StreamTypeAAC:
+		return &AACParser{}
+	case StreamTypeMPEG1Audio, StreamTypeMPEG2Audio:
+		return &MPEGAudioParser{}
+	case StreamTypeAC3, StreamTypeEAC3:
+		return &AC3Parser{}
+	case StreamTypePrivateData:
+		switch {
+		case desc.registration == "Opus":
+			return NewOpusParser(desc.opusChannels)
+		case desc.ac3 || desc.eac3 || desc.registration == "AC-3" || desc.registration == "EAC3":
+			return &AC3Parser{}
+		}
+	}
+	return nil
+}
+
+// isVideo reports whether a stream type carries video
+func isVideo(streamType byte) bool {
+	return streamType == StreamTypeH264 || streamType == StreamTypeH265
+}
+
+// frameStart returns the PES data if the packet starts a PES, nil otherwise
+func frameStart(pkt *Packet) []byte {
+	if !pkt.PUSI() {
+		return nil
+	}
+	return PESData(pkt)
+}
+
+// syncword reads the first two bytes of a frame
+func syncword(data []byte) uint16 {
+	if len(data) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(data)
+}
@@ -0,0 +1,133 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+// audioPES packetizes a frame header padded to size bytes
+func audioPES(t *testing.T, pid uint16, header []byte, size int, continuity byte) [][]byte {
+	t.Helper()
+	data := make([]byte, size)
+	copy(data, header)
+	packets, err := CreatePESPackets(pid, PESStreamIDAudio, data, continuity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packets
+}
+
+// psiPacket wraps a PSI section into a single MPEG-TS packet
+func psiPacket(t *testing.T, pid uint16, section []byte) []byte {
+	t.Helper()
+	buf := make([]byte, PacketLen)
+	payload := append([]byte{0}, section...)
+	if err := CreatePacket(pid).WithPUSI(true).WithPayload(payload).ToBytes(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestCodecParsers(t *testing.T) {
+	tests := []struct {
+		name     string
+		parser   CodecParser
+		header   []byte
+		keyframe bool
+		info     CodecInfo
+	}{
+		{"AAC", &AACParser{}, []byte{0xff, 0xf1, 0x50, 0x80, 0x02, 0x1f, 0xfc}, true, CodecInfo{Codec: "aac", SampleRate: 44100, Channels: 2}},
+		{"AACInvalid", &AACParser{}, []byte{0x00, 0x00, 0x01}, false, CodecInfo{Codec: "aac"}},
+		{"MP2Mono", &MPEGAudioParser{}, []byte{0xff, 0xfd, 0x84, 0xc0}, true, CodecInfo{Codec: "mp2", SampleRate: 48000, Channels: 1}},
+		{"MP3MPEG2", &MPEGAudioParser{}, []byte{0xff, 0xf3, 0x00, 0x00}, true, CodecInfo{Codec: "mp3", SampleRate: 22050, Channels: 2}},
+		{"AC3Stereo", &AC3Parser{}, []byte{0x0b, 0x77, 0, 0, 0x00, 0x40, 0x40}, true, CodecInfo{Codec: "ac3", SampleRate: 48000, Channels: 2}},
+		{"AC3Surround", &AC3Parser{}, []byte{0x0b, 0x77, 0, 0, 0x40, 0x40, 0xe1}, true, CodecInfo{Codec: "ac3", SampleRate: 44100, Channels: 6}},
+		{"EAC3", &AC3Parser{}, []byte{0x0b, 0x77, 0, 0, 0x3f, 0x80, 0}, true, CodecInfo{Codec: "eac3", SampleRate: 48000, Channels: 6}},
+		{"Opus", NewOpusParser(2), []byte{0x7f, 0xe0}, true, CodecInfo{Codec: "opus", SampleRate: 48000, Channels: 2}},
+		{"OpusDualMono", NewOpusParser(0), []byte{0x00}, false, CodecInfo{Codec: "opus", SampleRate: 48000, Channels: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt := Packet{}
+			if err := pkt.FromBytes(audioPES(t, 0x101, tt.header, 100, 0)[0]); err != nil {
+				t.Fatal(err)
+			}
+			keyframe, err := tt.parser.Parse(&pkt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keyframe != tt.keyframe {
+				t.Errorf("Parse() = %v, expected %v", keyframe, tt.keyframe)
+			}
+			if info := tt.parser.Info(); info != tt.info {
+				t.Errorf("Info() = %+v, expected %+v", info, tt.info)
+			}
+			if tt.parser.ParameterSets() == nil {
+				t.Error("Audio parsers should not wait for parameter sets")
+			}
+		})
+	}
+}
+
+func TestParser_AudioSync(t *testing.T) {
+	pat := psiPacket(t, PIDPAT, []byte{
+		TableTypePAT, 0xb0, 13, 0, 1, 0xc1, 0, 0,
+		0, 1, 0xf0, 0x00, // program 1 -> PMT PID 0x1000
+		0, 0, 0, 0, // CRC
+	})
+	pmt := psiPacket(t, 0x1000, []byte{
+		TableTypePMT, 0xb0, 26, 0, 1, 0xc1, 0, 0,
+		0xe1, 0x01, 0xf0, 0, // PCR PID, no program info
+		StreamTypeAAC, 0xe1, 0x01, 0xf0, 0,
+		StreamTypePrivateData, 0xe1, 0x02, 0xf0, 3, DescriptorTagAC3, 1, 0,
+		0, 0, 0, 0, // CRC
+	})
+	aac := audioPES(t, 0x101, []byte{0xff, 0xf1, 0x50, 0x80}, 100, 0)
+	ac3 := audioPES(t, 0x102, []byte{0x0b, 0x77, 0, 0, 0x00, 0x40, 0x40}, 300, 0)
+	nextAC3 := audioPES(t, 0x102, []byte{0x0b, 0x77, 0, 0, 0x00, 0x40, 0x40}, 100, 2)
+
+	// AC-3 PES starts before the sync point
+	p := NewParser()
+	if err := p.Parse(bytes.Join([][]byte{pat, pmt, ac3[0], aac[0]}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	init, err := p.InitData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(init) != 3 || !bytes.Equal(init[2], aac[0]) {
+		t.Fatalf("Expected init to start at the AAC frame, got %d packets", len(init))
+	}
+
+	// remainder of the AC-3 PES is dropped, the next one is kept
+	res, err := p.Filter(bytes.Join([][]byte{ac3[1], nextAC3[0]}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, nextAC3[0]) {
+		t.Errorf("Expected only the next AC-3 PES, got %d bytes", len(res))
+	}
+
+	// all streams started, data is passed as is
+	res, err = p.Filter(ac3[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, ac3[1]) {
+		t.Error("Expected data to be passed after all streams started")
+	}
+
+	streams := p.Streams()
+	expected := []StreamInfo{
+		{PID: 0x101, StreamType: StreamTypeAAC, CodecInfo: CodecInfo{Codec: "aac", SampleRate: 44100, Channels: 2}},
+		{PID: 0x102, StreamType: StreamTypePrivateData, CodecInfo: CodecInfo{Codec: "ac3", SampleRate: 48000, Channels: 2}},
+	}
+	if len(streams) != len(expected) {
+		t.Fatalf("Expected %d streams, got %d", len(expected), len(streams))
+	}
+	for i := range expected {
+		if streams[i] != expected[i] {
+			t.Errorf("Stream %d: got %+v, expected %+v", i, streams[i], expected[i])
+		}
+	}
+}
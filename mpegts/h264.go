@@ -7,38 +7,53 @@ const (
 	NALUnitTypePPS       = 8
 )
 
-// H264Parser parser for h.264 init packets
-type H264Parser struct{}
+// H264Parser parser for h.264 random access points
+// The most recent SPS and PPS are cached for injection before a keyframe.
+type H264Parser struct {
+	sps  []byte
+	pps  []byte
+	info CodecInfo // parsed from the last SPS
+}
 
-// ContainsInit checks whether the MPEG-TS packet contains a h.264 PPS or SPS
-func (p H264Parser) ContainsInit(pkt *Packet) (bool, error) {
-	var state byte
-	buf := pkt.Payload()
-	for i := 0; i < len(buf); i++ {
-		if state == 0x57 {
-			nalType := buf[i] & 0x1F
-			switch nalType {
-			case NALUnitTypeSPS:
-				fallthrough
-			case NALUnitTypePPS:
-				return true, nil
+// Parse checks whether the MPEG-TS packet contains a h.264 IDR slice
+// and caches contained SPS and PPS
+func (p *H264Parser) Parse(pkt *Packet) (bool, error) {
+	keyframe := false
+	for _, unit := range FindNALUnits(PESData(pkt)) {
+		if len(unit.Data) == 0 {
+			continue
+		}
+		nalType := unit.Data[0] & 0x1F
+		switch nalType {
+		case NALUnitCodedSliceIDR:
+			keyframe = true
+		case NALUnitTypeSPS:
+			if unit.Complete {
+				p.sps = append([]byte{}, unit.Data...)
+				if info, err := parseH264SPS(p.sps); err == nil {
+					p.info = info
+				}
+			}
+		case NALUnitTypePPS:
+			if unit.Complete {
+				p.pps = append([]byte{}, unit.Data...)
 			}
 		}
+	}
+	return keyframe, nil
+}
 
-		cur := 0
-		switch buf[i] {
-		case 0x00:
-			cur = 1
-		case 0x01:
-			cur = 3
-		default:
-			cur = 2
-		}
+// ParameterSets returns the cached SPS and PPS or nil if not all were found yet
+func (p *H264Parser) ParameterSets() []byte {
+	if p.sps == nil || p.pps == nil {
+		return nil
+	}
+	return annexB(p.sps, p.pps)
+}
 
-		/* state of last four bytes packed into one byte; two bits for unseen/zero/over
-		 * one/one (0..3 respectively).
-		 */
-		state = (state << 2) | byte(cur)
+func (p *H264Parser) Info() CodecInfo {
+	if p.info.Codec == "" {
+		return CodecInfo{Codec: "h264"}
 	}
-	return false, nil
+	return p.info
 }
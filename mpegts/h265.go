@@ -1,45 +1,61 @@
 package mpegts
 
-// AVC NAL unit type constants
+// HEVC NAL unit type constants
 const (
-	HEVCNALUnitTypeVPS = 32
-	HEVCNALUnitTypeSPS = 33
-	HEVCNALUnitTypePPS = 34
+	HEVCNALUnitTypeBLAWLP    = 16
+	HEVCNALUnitTypeRSVIRAP23 = 23
+	HEVCNALUnitTypeVPS       = 32
+	HEVCNALUnitTypeSPS       = 33
+	HEVCNALUnitTypePPS       = 34
 )
 
-// H265Parser parser for h.265 (HEVC) init packets
-type H265Parser struct{}
+// H265Parser parser for h.265 (HEVC) random access points
+// The most recent VPS, SPS and PPS are cached for injection before a keyframe.
+type H265Parser struct {
+	vps  []byte
+	sps  []byte
+	pps  []byte
+	info CodecInfo // parsed from the last SPS
+}
 
-// ContainsInit checks whether the MPEG-TS packet contains a H.265 VPS, SPS, or PPS
-func (p H265Parser) ContainsInit(pkt *Packet) (bool, error) {
-	var state byte
-	buf := pkt.Payload()
-	for i := 0; i < len(buf); i++ {
-		if state == 0x57 {
-			// H.265 NAL unit type is in bits 1–6 of the first byte after start code
-			nalType := (buf[i] >> 1) & 0x3F
-			switch nalType {
-			case HEVCNALUnitTypeVPS,
-				HEVCNALUnitTypeSPS,
-				HEVCNALUnitTypePPS:
-				return true, nil
+// Parse checks whether the MPEG-TS packet contains a H.265 IRAP picture (IDR, CRA or BLA)
+// and caches contained VPS, SPS and PPS
+func (p *H265Parser) Parse(pkt *Packet) (bool, error) {
+	keyframe := false
+	for _, unit := range FindNALUnits(PESData(pkt)) {
+		if len(unit.Data) == 0 {
+			continue
+		}
+		// H.265 NAL unit type is in bits 1–6 of the first byte after start code
+		nalType := (unit.Data[0] >> 1) & 0x3F
+		switch {
+		case nalType >= HEVCNALUnitTypeBLAWLP && nalType <= HEVCNALUnitTypeRSVIRAP23:
+			keyframe = true
+		case nalType == HEVCNALUnitTypeVPS && unit.Complete:
+			p.vps = append([]byte{}, unit.Data...)
+		case nalType == HEVCNALUnitTypeSPS && unit.Complete:
+			p.sps = append([]byte{}, unit.Data...)
+			if info, err := parseH265SPS(p.sps); err == nil {
+				p.info = info
 			}
+		case nalType == HEVCNALUnitTypePPS && unit.Complete:
+			p.pps = append([]byte{}, unit.Data...)
 		}
+	}
+	return keyframe, nil
+}
 
-		cur := 0
-		switch buf[i] {
-		case 0x00:
-			cur = 1
-		case 0x01:
-			cur = 3
-		default:
-			cur = 2
-		}
+// ParameterSets returns the cached VPS, SPS and PPS or nil if not all were found yet
+func (p *H265Parser) ParameterSets() []byte {
+	if p.vps == nil || p.sps == nil || p.pps == nil {
+		return nil
+	}
+	return annexB(p.vps, p.sps, p.pps)
+}
 
-		/* state of last four bytes packed into one byte; two bits for unseen/zero/over
-		 * one/one (0..3 respectively).
-		 */
-		state = (state << 2) | byte(cur)
+func (p *H265Parser) Info() CodecInfo {
+	if p.info.Codec == "" {
+		return CodecInfo{Codec: "hevc"}
 	}
-	return false, nil
+	return p.info
 }
@@ -0,0 +1,50 @@
+package mpegts
+
+// MPEG audio sampling frequencies of MPEG-1 by index
+var mpegAudioSampleRates = []int{44100, 48000, 32000}
+
+// MPEGAudioParser parser for MPEG-1/2 audio layer I, II and III
+type MPEGAudioParser struct {
+	info CodecInfo
+}
+
+// Parse checks whether the MPEG-TS packet starts a PES with a MPEG audio frame
+func (p *MPEGAudioParser) Parse(pkt *Packet) (bool, error) {
+	data := frameStart(pkt)
+	if len(data) < 4 || syncword(data)&0xffe0 != 0xffe0 {
+		return false, nil
+	}
+
+	version := (data[1] >> 3) & 0x3
+	layer := (data[1] >> 1) & 0x3
+	index := int(data[2]>>2) & 0x3
+	if version == 1 || layer == 0 || index >= len(mpegAudioSampleRates) {
+		return false, nil
+	}
+
+	p.info.Codec = [...]string{"", "mp3", "mp2", "mp1"}[layer]
+	p.info.SampleRate = mpegAudioSampleRates[index]
+	switch version {
+	case 0: // MPEG-2.5
+		p.info.SampleRate /= 4
+	case 2: // MPEG-2
+		p.info.SampleRate /= 2
+	}
+	p.info.Channels = 2
+	if data[3]>>6 == 3 {
+		p.info.Channels = 1
+	}
+	return true, nil
+}
+
+// ParameterSets returns no data, MPEG audio frames are self-contained
+func (p *MPEGAudioParser) ParameterSets() []byte {
+	return []byte{}
+}
+
+func (p *MPEGAudioParser) Info() CodecInfo {
+	if p.info.Codec == "" {
+		return CodecInfo{Codec: "mp2"}
+	}
+	return p.info
+}
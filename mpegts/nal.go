@@ -0,0 +1,47 @@
+package mpegts
+
+// NALUnit is a single Annex B NAL unit without start code
+type NALUnit struct {
+	Data     []byte
+	Complete bool // false if the unit may continue beyond the buffer
+}
+
+// FindNALUnits splits an Annex B byte stream into NAL units
+// Data before the first start code is skipped.
+func FindNALUnits(buf []byte) []NALUnit {
+	var units []NALUnit
+	start := -1
+	for i := 0; i+2 < len(buf); i++ {
+		if buf[i] != 0 || buf[i+1] != 0 || buf[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			units = append(units, NALUnit{Data: trimTrailingZeros(buf[start:i]), Complete: true})
+		}
+		i += 2
+		start = i + 1
+	}
+	if start >= 0 && start < len(buf) {
+		units = append(units, NALUnit{Data: buf[start:], Complete: false})
+	}
+	return units
+}
+
+// trimTrailingZeros removes trailing zero bytes belonging to the next start code
+func trimTrailingZeros(buf []byte) []byte {
+	end := len(buf)
+	for end > 0 && buf[end-1] == 0 {
+		end--
+	}
+	return buf[:end]
+}
+
+// annexB joins NAL units with start codes
+func annexB(units ...[]byte) []byte {
+	var res []byte
+	for _, unit := range units {
+		res = append(res, 0, 0, 0, 1)
+		res = append(res, unit...)
+	}
+	return res
+}
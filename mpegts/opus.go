@@ -0,0 +1,37 @@
+package mpegts
+
+// OpusParser parser for Opus audio in MPEG-TS (ETSI TS 102 366 style mapping)
+type OpusParser struct {
+	info CodecInfo
+}
+
+// NewOpusParser creates an Opus parser using the channel config code
+// of the extension descriptor, -1 if unknown
+func NewOpusParser(channelConfig int) *OpusParser {
+	p := &OpusParser{info: CodecInfo{Codec: "opus", SampleRate: 48000}}
+	switch {
+	case channelConfig == 0:
+		p.info.Channels = 2 // dual mono
+	case channelConfig > 0 && channelConfig <= 8:
+		p.info.Channels = channelConfig
+	}
+	return p
+}
+
+// Parse checks whether the MPEG-TS packet starts a PES with an Opus control header
+func (p *OpusParser) Parse(pkt *Packet) (bool, error) {
+	data := frameStart(pkt)
+	if len(data) < 2 || syncword(data)&0xffe0 != 0x7fe0 {
+		return false, nil
+	}
+	return true, nil
+}
+
+// ParameterSets returns no data, Opus packets are self-contained
+func (p *OpusParser) ParameterSets() []byte {
+	return []byte{}
+}
+
+func (p *OpusParser) Info() CodecInfo {
+	return p.info
+}
@@ -20,11 +20,16 @@ const (
 	PacketLen = 188
 	PIDOffset = 8
 
+	TEIHdrMask        = 0x800000
 	PUSIHdrMask       = 0x400000
 	PIDHdrMask        = 0x1fff00
 	AdaptationHdrMask = 0x20
 	PayloadHdrMask    = 0x10
 	ContinuityHdrMask = 0xf
+
+	DiscontinuityAFMask = 0x80
+	RandomAccessAFMask  = 0x40
+	PCRAFMask           = 0x10
 )
 
 /**
@@ -81,6 +86,37 @@ func (pkt *Packet) PUSI() bool {
 	return pkt.header&PUSIHdrMask > 0
 }
 
+// TransportError reports the transport error indicator
+func (pkt *Packet) TransportError() bool {
+	return pkt.header&TEIHdrMask > 0
+}
+
+// HasPayload reports whether the packet carries payload
+func (pkt *Packet) HasPayload() bool {
+	return pkt.header&PayloadHdrMask > 0
+}
+
+// Discontinuity reports the discontinuity indicator of the adaptation field
+func (pkt *Packet) Discontinuity() bool {
+	return len(pkt.adaptationField) > 0 && pkt.adaptationField[0]&DiscontinuityAFMask > 0
+}
+
+// PCR returns the program clock reference in 27 MHz units if present
+func (pkt *Packet) PCR() (uint64, bool) {
+	af := pkt.adaptationField
+	if len(af) < 7 || af[0]&PCRAFMask == 0 {
+		return 0, false
+	}
+	base := uint64(af[1])<<25 | uint64(af[2])<<17 | uint64(af[3])<<9 | uint64(af[4])<<1 | uint64(af[5])>>7
+	ext := uint64(af[5]&0x1)<<8 | uint64(af[6])
+	return base*300 + ext, true
+}
+
+// RandomAccess reports the random access indicator of the adaptation field
+func (pkt *Packet) RandomAccess() bool {
+	return len(pkt.adaptationField) > 0 && pkt.adaptationField[0]&RandomAccessAFMask > 0
+}
+
 func (pkt *Packet) Payload() []byte {
 	return pkt.payload
 }
@@ -111,6 +147,11 @@ func (pkt *Packet) WithPUSI(pusi bool) *Packet {
 	return pkt
 }
 
+func (pkt *Packet) WithContinuity(continuity byte) *Packet {
+	pkt.header = pkt.header&^ContinuityHdrMask | uint32(continuity&ContinuityHdrMask)
+	return pkt
+}
+
 func (pkt *Packet) WithPayload(payload []byte) *Packet {
 	pkt.payload = payload
 	return pkt
@@ -133,7 +174,6 @@ func (pkt *Packet) ToBytes(data []byte) error {
 	//   TEI always 0
 	//   Transport priority always 0
 	//   TSC always 0
-	//   Continuity always 0
 	if pkt.AdaptationField() != nil {
 		pkt.header |= 0x1 << 5
 	}
@@ -54,3 +54,39 @@ func TestPacket_ToBytes_FromBytes(t *testing.T) {
 			hex.EncodeToString(pkt1.AdaptationField()))
 	}
 }
+
+func TestCreatePESPackets(t *testing.T) {
+	data := make([]byte, 300)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	packets, err := CreatePESPackets(0x100, PESStreamIDVideo, data, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("Expected 2 packets, got %d", len(packets))
+	}
+
+	var got []byte
+	for i, buf := range packets {
+		pkt := Packet{}
+		if err := pkt.FromBytes(buf); err != nil {
+			t.Fatal(err)
+		}
+		if pkt.PUSI() != (i == 0) {
+			t.Errorf("Packet %d has PUSI %v", i, pkt.PUSI())
+		}
+		if expected := byte(15+i) & 0xf; pkt.Continuity() != expected {
+			t.Errorf("Packet %d has continuity %d, expected %d", i, pkt.Continuity(), expected)
+		}
+		got = append(got, PESData(&pkt)...)
+	}
+
+	if hex.EncodeToString(got) != hex.EncodeToString(data) {
+		t.Errorf("Failed to packetize PES,\n got: %s,\n expected %s",
+			hex.EncodeToString(got),
+			hex.EncodeToString(data))
+	}
+}
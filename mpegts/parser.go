@@ -3,8 +3,7 @@ package mpegts
 import (
 	"encoding/binary"
 	"errors"
-	"io"
-	"log"
+	"sort"
 )
 
 // MPEGTS errors
@@ -22,49 +21,74 @@ const (
 
 // StreamType constants
 const (
-	StreamTypeH264 = 0x1b
-	StreamTypeH265 = 0x24
+	StreamTypeMPEG1Audio  = 0x03
+	StreamTypeMPEG2Audio  = 0x04
+	StreamTypePrivateData = 0x06
+	StreamTypeAAC         = 0x0f
+	StreamTypeH264        = 0x1b
+	StreamTypeH265        = 0x24
+	StreamTypeAC3         = 0x81
+	StreamTypeEAC3        = 0x87
 )
 
 // Parser object for finding the synchronization point in a MPEGTS stream
 // This works as follows: parse packets until all the following have been fulfilled
 //  1. Parse PAT to get PID->PMT mappings
 //  2. Parse PMTs to Find PID->PES mappings
-//  3. Parse PES to find H.264 SPS+PPS or equivalent
+//  3. Parse PES of the first video stream to find a random access point (keyframe)
+//     and the H.264 SPS+PPS or equivalent, audio-only streams sync on the
+//     first audio frame instead
 //
 // Store the original packets or generate new ones to send to a client
+// The last seen parameter sets are injected right before the keyframe,
+// so the client can decode from the first frame on.
+// Packets of the other elementary streams are dropped until their next
+// PES start, so every stream starts with a complete frame.
 type Parser struct {
 	init               [][]byte // collected packets to initialize a decoder
+	pending            [][]byte // packets since the last PES start of the sync stream
 	expectedPATSection byte     // id of next expected PAT section
 	expectedPMTSection byte     // id of next expected PMT section
 	PAT                []byte
 	PMT                []byte
 	hasPAT             bool                         // MPEG-TS PAT packet stored
 	hasPMT             bool                         // MPEG-TS PMT packet stored
+	synced             bool                         // random access point found
+	keyframe           bool                         // current PES of the sync stream is a random access point
+	syncStream         *ElementaryStream            // video stream to synchronize on
+	started            map[uint16]bool              // elementary streams which reached a PES start since sync
 	pmtMap             map[uint16]uint16            // map[pid]programNumber
 	tspMap             map[uint16]*ElementaryStream // transport stream program map
+	programs           map[uint16]*program          // map[programNumber]program
+}
+
+// program lists the elementary streams of a PMT including unsupported ones
+type program struct {
+	pmtPID  uint16
+	streams []*ElementaryStream
 }
 
 func NewParser() *Parser {
 	return &Parser{
-		pmtMap: make(map[uint16]uint16),
-		tspMap: make(map[uint16]*ElementaryStream),
-		init:   make([][]byte, 0, 3),
+		pmtMap:   make(map[uint16]uint16),
+		tspMap:   make(map[uint16]*ElementaryStream),
+		programs: make(map[uint16]*program),
+		init:     make([][]byte, 0, 3),
 	}
 }
 
 func (p *Parser) hasInit() bool {
-	if !p.hasPAT || !p.hasPMT {
-		return false
-	}
-
-	for _, es := range p.tspMap {
-		if !es.HasInit {
-			return false
-		}
-	}
+	return p.hasPAT && p.hasPMT && p.synced
+}
 
-	return true
+// Reset clears the collected init data to find the next synchronization point
+// Known PAT and PMT mappings and parameter sets are kept.
+func (p *Parser) Reset() {
+	p.init = make([][]byte, 0, 3)
+	p.pending = nil
+	p.keyframe = false
+	p.synced = false
+	p.started = nil
 }
 
 // InitData returns data needed for decoder init or nil if the parser is not ready yet
@@ -89,14 +113,19 @@ func (p *Parser) Parse(data []byte) error {
 		err := pkt.FromBytes(data)
 		if err != nil {
 			// Incomplete packet, TODO: keep rest data?
-			if err == io.ErrUnexpectedEOF {
-				log.Fatalln("parsing failed, incomplete packet")
-				return nil
-			}
 			return err
 		}
+		raw := data[:pkt.Size()]
+		data = data[pkt.Size():]
+
+		// store all remaining packets from the buffer after init
+		if p.hasInit() {
+			if p.accept(&pkt) {
+				p.init = append(p.init, raw)
+			}
+			continue
+		}
 
-		storePacket := false
 		if pkt.PID() == PIDPAT {
 			// parse PMT and store PAT packet
 			store, err := p.ParsePSI(pkt.Payload())
@@ -104,7 +133,7 @@ func (p *Parser) Parse(data []byte) error {
 				return err
 			}
 			if store {
-				p.PAT = data[:pkt.Size()]
+				p.PAT = raw
 			}
 
 		} else if _, ok := p.pmtMap[pkt.PID()]; ok {
@@ -114,34 +143,179 @@ func (p *Parser) Parse(data []byte) error {
 				return err
 			}
 			if store {
-				p.PMT = data[:pkt.Size()]
+				p.PMT = raw
+			}
+
+			// Nothing to synchronize on, start right after the PMT
+			if p.hasPAT && p.hasPMT && p.syncStream == nil {
+				p.synced = true
+				p.started = make(map[uint16]bool)
 			}
 
 		} else if stream, ok := p.tspMap[pkt.PID()]; ok {
-			// Parse PES and store SPS+PPS packet
-			// log.Println("payload", len(pkt.Payload), len(pkt.AdaptationField))
-			storePacket, err = stream.CodecParser.ContainsInit(&pkt)
+			// inspect every elementary stream to learn its codec properties
+			keyframe, err := stream.CodecParser.Parse(&pkt)
 			if err != nil {
 				return err
 			}
-			if storePacket {
-				stream.HasInit = true
+
+			if stream == p.syncStream {
+				// Collect packets from the start of each PES
+				if pkt.PUSI() {
+					p.pending = nil
+					p.keyframe = pkt.RandomAccess()
+				}
+				p.keyframe = p.keyframe || keyframe
+
+				if p.pending != nil || pkt.PUSI() {
+					p.pending = append(p.pending, raw)
+				}
+
+				if p.keyframe && p.pending != nil && p.hasPAT && p.hasPMT {
+					if err := p.sync(stream); err != nil {
+						return err
+					}
+				}
+				continue
 			}
 		}
 
-		// store init packets and all remaining packets from the buffer after init
-		if storePacket || p.hasInit() {
-			p.init = append(p.init, data[:pkt.Size()])
+		if p.pending != nil {
+			p.pending = append(p.pending, raw)
 		}
+	}
+}
 
-		data = data[pkt.Size():]
+// sync starts the init data at the pending keyframe PES
+// The cached parameter sets are injected right before the keyframe.
+func (p *Parser) sync(stream *ElementaryStream) error {
+	params := stream.CodecParser.ParameterSets()
+	if params == nil {
+		return nil
+	}
+
+	start := Packet{}
+	if err := start.FromBytes(p.pending[0]); err != nil {
+		return err
+	}
+
+	if len(params) > 0 {
+		// continue the continuity counter of the keyframe
+		payload := start.Payload()
+		streamID := byte(PESStreamIDVideo)
+		if len(payload) > 3 {
+			streamID = payload[3]
+		}
+		numPackets := NumPackets(PESHeaderSize + 3 + len(params))
+		continuity := (start.Continuity() - byte(numPackets)) & ContinuityHdrMask
+
+		inject, err := CreatePESPackets(stream.PID, streamID, params, continuity)
+		if err != nil {
+			return err
+		}
+		p.init = append(p.init, inject...)
+	}
+
+	p.started = map[uint16]bool{stream.PID: true}
+	pkt := Packet{}
+	for _, raw := range p.pending {
+		if err := pkt.FromBytes(raw); err != nil {
+			return err
+		}
+		if p.accept(&pkt) {
+			p.init = append(p.init, raw)
+		}
+	}
+	p.pending = nil
+	p.synced = true
+	return nil
+}
+
+// accept reports whether a packet after the synchronization point is forwarded
+// Elementary stream packets are dropped until the first PES start of their PID.
+func (p *Parser) accept(pkt *Packet) bool {
+	pid := pkt.PID()
+	if _, ok := p.tspMap[pid]; !ok || p.started[pid] {
+		return true
+	}
+	if pkt.PUSI() {
+		p.started[pid] = true
+		return true
+	}
+	return false
+}
+
+// Filter drops the packets of elementary streams which did not reach a PES start
+// since the synchronization point. The data is returned as is once all
+// streams started or if the parser is not synchronized.
+func (p *Parser) Filter(data []byte) ([]byte, error) {
+	if !p.hasInit() || len(p.started) >= len(p.tspMap) {
+		return data, nil
+	}
+
+	var res []byte
+	pkt := Packet{}
+	for offset := 0; offset < len(data); {
+		if err := pkt.FromBytes(data[offset:]); err != nil {
+			return nil, err
+		}
+		size := pkt.Size()
+		if p.accept(&pkt) {
+			if res != nil {
+				res = append(res, data[offset:offset+size]...)
+			}
+		} else if res == nil {
+			// copy on first drop, the buffer may be shared
+			res = make([]byte, offset, len(data))
+			copy(res, data[:offset])
+		}
+		offset += size
+	}
+	if res == nil {
+		return data, nil
+	}
+	return res, nil
+}
+
+// Streams returns the supported elementary streams ordered by PID
+func (p *Parser) Streams() []StreamInfo {
+	streams := make([]StreamInfo, 0, len(p.tspMap))
+	for _, es := range p.tspMap {
+		streams = append(streams, es.Info())
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].PID < streams[j].PID
+	})
+	return streams
+}
+
+// Programs returns all programs of the last parsed PMTs ordered by program number
+func (p *Parser) Programs() []ProgramInfo {
+	programs := make([]ProgramInfo, 0, len(p.programs))
+	for number, prog := range p.programs {
+		info := ProgramInfo{
+			Number:  number,
+			PMTPID:  prog.pmtPID,
+			Streams: make([]StreamInfo, 0, len(prog.streams)),
+		}
+		for _, es := range prog.streams {
+			info.Streams = append(info.Streams, es.Info())
+		}
+		programs = append(programs, info)
 	}
+	sort.Slice(programs, func(i, j int) bool {
+		return programs[i].Number < programs[j].Number
+	})
+	return programs
 }
 
 // ParsePSI selectively parses a Program Specific Information (PSI) table
 // We are only interested in PAT and PMT
 func (p *Parser) ParsePSI(data []byte) (bool, error) {
 	// skip to section header
+	if len(data) == 0 || 1+int(data[0]) > len(data) {
+		return false, ErrInvalidPacket
+	}
 	ptr := int(data[0])
 	offset := 1 + ptr
 	shouldStore := false
@@ -150,28 +324,29 @@ func (p *Parser) ParsePSI(data []byte) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	// the section ends with a 4 byte CRC
+	end := offset + 3 + int(hdr.sectionLength) - 4
 	offset += PSIHeaderLen
 
 	// We are only interested in PAT and PMT
 	switch hdr.tableID {
 	case TableTypePAT:
+		// header and CRC
+		if hdr.sectionLength < 9 {
+			return false, ErrInvalidPacket
+		}
 		// expect program map in order
 		if p.expectedPATSection != hdr.sectionNumber || !hdr.currentNext {
 			return false, nil
 		}
 
-		end := offset + int(hdr.sectionLength-9)/4
-		for {
+		for offset+4 <= end {
 			programNumber := binary.BigEndian.Uint16(data[offset : offset+2])
-			offset += 2
-			pid := binary.BigEndian.Uint16(data[offset:offset+2]) & 0x1fff
-			offset += 2
+			pid := binary.BigEndian.Uint16(data[offset+2:offset+4]) & 0x1fff
+			offset += 4
 			if programNumber != 0 {
 				p.pmtMap[pid] = programNumber
 			}
-			if offset >= end {
-				break
-			}
 		}
 		shouldStore = true
 		p.expectedPATSection = hdr.sectionNumber + 1
@@ -181,19 +356,30 @@ func (p *Parser) ParsePSI(data []byte) (bool, error) {
 		}
 
 	case TableTypePMT:
+		// header, PCR PID, program info length and CRC
+		if hdr.sectionLength < 13 {
+			return false, ErrInvalidPacket
+		}
 		// expect program map in order
 		if p.expectedPMTSection != hdr.sectionNumber || !hdr.currentNext {
 			return false, nil
 		}
 
+		prog := p.program(hdr.tableIDExtension)
+		if hdr.sectionNumber == 0 {
+			prog.streams = nil
+		}
+
 		// skip PCR PID
 		offset += 2
 
 		programInfoLength := binary.BigEndian.Uint16(data[offset:offset+2]) & 0xfff
+		if programInfoLength > hdr.sectionLength-13 {
+			return false, ErrInvalidPacket
+		}
 		offset += 2 + int(programInfoLength)
 
-		end := offset + int(hdr.sectionLength-programInfoLength-13)
-		for {
+		for offset+5 <= end {
 			streamType := data[offset]
 			offset++
 
@@ -201,23 +387,26 @@ func (p *Parser) ParsePSI(data []byte) (bool, error) {
 			offset += 2
 
 			esInfoLength := binary.BigEndian.Uint16(data[offset:offset+2]) & 0xfff
-			offset += 2 + int(esInfoLength)
+			offset += 2
+			if offset+int(esInfoLength) > end {
+				return false, ErrInvalidPacket
+			}
+			desc := parseDescriptors(data[offset : offset+int(esInfoLength)])
+			offset += int(esInfoLength)
 
-			_, hasParser := p.tspMap[elementaryPID]
+			es, hasParser := p.tspMap[elementaryPID]
 			if !hasParser {
-				switch streamType {
-				case StreamTypeH264:
-					p.tspMap[elementaryPID] = &ElementaryStream{CodecParser: H264Parser{}}
-				case StreamTypeH265:
-					p.tspMap[elementaryPID] = &ElementaryStream{CodecParser: H265Parser{}}
-				default:
-					// log.Println("Unknown streamtype", elementaryPID)
+				es = &ElementaryStream{PID: elementaryPID, StreamType: streamType}
+				es.CodecParser = newCodecParser(streamType, desc)
+				if es.CodecParser != nil {
+					p.tspMap[elementaryPID] = es
+					// prefer the first video stream, fall back to audio
+					if p.syncStream == nil || (isVideo(streamType) && !isVideo(p.syncStream.StreamType)) {
+						p.syncStream = es
+					}
 				}
 			}
-
-			if offset >= end {
-				break
-			}
+			prog.streams = append(prog.streams, es)
 		}
 
 		shouldStore = true
@@ -229,3 +418,18 @@ func (p *Parser) ParsePSI(data []byte) (bool, error) {
 	}
 	return shouldStore, nil
 }
+
+// program returns the program with the given number, creating it if needed
+func (p *Parser) program(number uint16) *program {
+	prog, ok := p.programs[number]
+	if !ok {
+		prog = &program{}
+		for pid, n := range p.pmtMap {
+			if n == number {
+				prog.pmtPID = pid
+			}
+		}
+		p.programs[number] = prog
+	}
+	return prog
+}
@@ -2,6 +2,7 @@ package mpegts
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"os"
 	"os/exec"
@@ -171,3 +172,137 @@ func TestParser_ParseH265_complex(t *testing.T) {
 		checkParser(t, p, data[tt.offset:], tt.name, tt.expectedFrames)
 	}
 }
+
+func TestParser_Reset(t *testing.T) {
+	data, err := os.ReadFile("h264_long.ts")
+	if err != nil {
+		t.Fatalf("failed to open test file")
+	}
+
+	// Find consecutive GOP starts
+	p := NewParser()
+	found := 0
+	for i := 0; i+PacketLen <= len(data); i += PacketLen {
+		if err := p.Parse(data[i : i+PacketLen]); err != nil {
+			t.Fatal(err)
+		}
+		if p.hasInit() {
+			found++
+			p.Reset()
+		}
+	}
+	if found < 2 {
+		t.Errorf("Found %d GOP starts, expected at least 2", found)
+	}
+}
+
+func TestParser_InjectParameterSets(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		numParams int
+	}{
+		{"H264", "h264_long.ts", 2},
+		{"H265", "h265_long.ts", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(tt.file)
+			if err != nil {
+				t.Fatalf("failed to open test file")
+			}
+
+			// Start mid-GOP
+			p := NewParser()
+			for i := 10 * PacketLen; i+PacketLen <= len(data) && !p.hasInit(); i += PacketLen {
+				if err := p.Parse(data[i : i+PacketLen]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			init, err := p.InitData()
+			if err != nil || len(init) < 4 {
+				t.Fatalf("Expected init data, got %d packets, err %v", len(init), err)
+			}
+
+			// PAT, PMT, injected parameter sets, keyframe
+			var inject, keyframe Packet
+			if err := inject.FromBytes(init[2]); err != nil {
+				t.Fatal(err)
+			}
+			if err := keyframe.FromBytes(init[3]); err != nil {
+				t.Fatal(err)
+			}
+			if !inject.PUSI() || !keyframe.PUSI() {
+				t.Error("Parameter sets and keyframe should start a PES")
+			}
+			if inject.PID() != keyframe.PID() {
+				t.Errorf("Parameter sets injected on PID %d, expected %d", inject.PID(), keyframe.PID())
+			}
+			if (inject.Continuity()+1)&0xf != keyframe.Continuity() {
+				t.Errorf("Invalid continuity %d before keyframe continuity %d", inject.Continuity(), keyframe.Continuity())
+			}
+			if units := FindNALUnits(PESData(&inject)); len(units) != tt.numParams {
+				t.Errorf("Injected %d NAL units, expected %d", len(units), tt.numParams)
+			}
+		})
+	}
+}
+
+// corruptPMT sets the program info length of the first PMT beyond its section
+func corruptPMT(data []byte) []byte {
+	var pkt Packet
+	for i := 0; i+PacketLen <= len(data); i += PacketLen {
+		if err := pkt.FromBytes(data[i:]); err != nil || pkt.PID() != 0x1000 || !pkt.PUSI() {
+			continue
+		}
+		// pointer field, table header, PCR PID
+		offset := i + PacketLen - len(pkt.Payload())
+		offset += 1 + int(data[offset]) + PSIHeaderLen + 2
+		data[offset] |= 0x0f
+		data[offset+1] = 0xff
+		break
+	}
+	return data
+}
+
+func TestParser_ParsePSI_Invalid(t *testing.T) {
+	data, err := os.ReadFile("h264_long.ts")
+	if err != nil {
+		t.Fatalf("failed to open test file")
+	}
+	data = corruptPMT(data)
+
+	p := NewParser()
+	for i := 0; i+PacketLen <= len(data); i += PacketLen {
+		if err = p.Parse(data[i : i+PacketLen]); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrInvalidPacket) {
+		t.Errorf("Got error %v, expected %v", err, ErrInvalidPacket)
+	}
+}
+
+func FuzzParsePSI(f *testing.F) {
+	data, err := os.ReadFile("h264_long.ts")
+	if err != nil {
+		f.Fatalf("failed to open test file")
+	}
+	// PAT and PMT
+	var pkt Packet
+	for i := 0; i+PacketLen <= len(data); i += PacketLen {
+		if err := pkt.FromBytes(data[i:]); err != nil {
+			f.Fatal(err)
+		}
+		if pkt.PUSI() && (pkt.PID() == PIDPAT || pkt.PID() == 0x1000) {
+			f.Add(pkt.Payload())
+		}
+		if i > 10*PacketLen {
+			break
+		}
+	}
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		p := NewParser()
+		_, _ = p.ParsePSI(payload)
+	})
+}
@@ -12,10 +12,133 @@ const (
 )
 
 type ElementaryStream struct {
-	HasInit     bool
-	CodecParser CodecParser
+	PID         uint16
+	StreamType  byte
+	CodecParser CodecParser // nil for unsupported stream types
+}
+
+// Info describes the elementary stream
+func (es *ElementaryStream) Info() StreamInfo {
+	info := StreamInfo{PID: es.PID, StreamType: es.StreamType}
+	if es.CodecParser != nil {
+		info.CodecInfo = es.CodecParser.Info()
+	}
+	return info
 }
 
 type CodecParser interface {
-	ContainsInit(pkt *Packet) (bool, error)
+	// Parse inspects a packet and reports whether it contains a random access point
+	Parse(pkt *Packet) (bool, error)
+
+	// ParameterSets returns the decoder configuration to inject before a
+	// random access point, an empty slice if the codec needs none
+	// or nil if it is not known yet
+	ParameterSets() []byte
+
+	// Info returns the codec properties known so far
+	Info() CodecInfo
+}
+
+// PESData returns the elementary stream data of a packet
+// The PES header is skipped on payload unit start.
+func PESData(pkt *Packet) []byte {
+	payload := pkt.Payload()
+	if !pkt.PUSI() {
+		return payload
+	}
+	if len(payload) < PESHeaderSize+3 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return nil
+	}
+	offset := PESHeaderSize + 3 + int(payload[8])
+	if offset > len(payload) {
+		return nil
+	}
+	return payload[offset:]
+}
+
+// PTS returns the presentation timestamp in 90 kHz units of a packet
+// starting a PES, if present
+func PTS(pkt *Packet) (uint64, bool) {
+	ts := pesTimestamps(pkt)
+	if len(ts) < 5 {
+		return 0, false
+	}
+	return parseTimestamp(ts), true
+}
+
+// DTS returns the decoding timestamp in 90 kHz units of a packet starting
+// a PES, if present. The DTS equals the PTS if only the latter is coded.
+func DTS(pkt *Packet) (uint64, bool) {
+	ts := pesTimestamps(pkt)
+	if len(ts) < 10 {
+		return PTS(pkt)
+	}
+	return parseTimestamp(ts[5:]), true
+}
+
+// pesTimestamps returns the coded PTS and DTS fields of a PES header
+func pesTimestamps(pkt *Packet) []byte {
+	payload := pkt.Payload()
+	if !pkt.PUSI() || len(payload) < PESHeaderSize+3 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return nil
+	}
+	var n int
+	switch payload[7] >> 6 {
+	case 0x2:
+		n = 5
+	case 0x3:
+		n = 10
+	}
+	if len(payload) < PESHeaderSize+3+n {
+		return nil
+	}
+	return payload[PESHeaderSize+3 : PESHeaderSize+3+n]
+}
+
+func parseTimestamp(b []byte) uint64 {
+	return uint64(b[0]>>1&0x07)<<30 | uint64(b[1])<<22 | uint64(b[2]>>1)<<15 | uint64(b[3])<<7 | uint64(b[4]>>1)
+}
+
+// CreatePESPackets packetizes data into a PES without timestamps
+// continuity is the continuity counter of the first packet.
+func CreatePESPackets(pid uint16, streamID byte, data []byte, continuity byte) ([][]byte, error) {
+	pesLength := 3 + len(data)
+	if pesLength > 0xffff {
+		return nil, ErrDataTooLong
+	}
+	pes := make([]byte, 0, PESHeaderSize+pesLength)
+	pes = append(pes, 0, 0, 1, streamID, byte(pesLength>>8), byte(pesLength), 0x80, 0, 0)
+	pes = append(pes, data...)
+
+	packets := make([][]byte, 0, NumPackets(len(pes)))
+	for first := true; len(pes) > 0; first = false {
+		n := min(len(pes), MaxPayloadSize)
+		pkt := CreatePacket(pid).
+			WithPUSI(first).
+			WithContinuity(continuity).
+			WithPayload(pes[:n])
+
+		// stuff remaining space using the adaptation field
+		if n < MaxPayloadSize {
+			adaptationField := make([]byte, MaxPayloadSize-n-1)
+			for i := 1; i < len(adaptationField); i++ {
+				adaptationField[i] = 0xff
+			}
+			pkt.WithAdaptationField(adaptationField)
+		}
+
+		buf := make([]byte, PacketLen)
+		if err := pkt.ToBytes(buf); err != nil {
+			return nil, err
+		}
+		packets = append(packets, buf)
+		pes = pes[n:]
+		continuity = (continuity + 1) & ContinuityHdrMask
+	}
+	return packets, nil
+}
+
+// NumPackets returns the number of MPEG-TS packets needed for a PES of the given size
+func NumPackets(pesLength int) int {
+	return (pesLength + MaxPayloadSize - 1) / MaxPayloadSize
 }
@@ -0,0 +1,116 @@
+package mpegts
+
+import "encoding/binary"
+
+// PES is a reassembled packetized elementary stream packet
+type PES struct {
+	PID          uint16
+	StreamType   byte
+	PTS          uint64 // 90 kHz
+	DTS          uint64 // 90 kHz, equals the PTS if not coded
+	HasPTS       bool
+	RandomAccess bool   // random access indicator of the first packet
+	Data         []byte // elementary stream data without PES header
+}
+
+// pendingPES is a PES waiting for more packets
+type pendingPES struct {
+	pes       *PES
+	remaining int // bytes left until the coded length is reached, -1 if unbounded
+}
+
+// PESReader reassembles the PES of all supported elementary streams
+// The program map is learned from the PAT and PMT in the stream.
+type PESReader struct {
+	parser  *Parser
+	pending map[uint16]*pendingPES
+}
+
+func NewPESReader() *PESReader {
+	return &PESReader{
+		parser:  NewParser(),
+		pending: make(map[uint16]*pendingPES),
+	}
+}
+
+// Streams returns the supported elementary streams known so far
+func (r *PESReader) Streams() []StreamInfo {
+	return r.parser.Streams()
+}
+
+// Read processes all MPEG-TS packets from a buffer and calls fn for every
+// complete PES. A PES is complete once its coded length is reached or the
+// next PES of the same stream starts. The PES must not be retained after fn
+// returns.
+func (r *PESReader) Read(data []byte, fn func(*PES)) error {
+	pkt := Packet{}
+	for len(data) > 0 {
+		if err := pkt.FromBytes(data); err != nil {
+			return err
+		}
+		data = data[pkt.Size():]
+
+		pid := pkt.PID()
+		if _, isPMT := r.parser.pmtMap[pid]; pid == PIDPAT || isPMT {
+			if pkt.PUSI() {
+				if _, err := r.parser.ParsePSI(pkt.Payload()); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		es, ok := r.parser.tspMap[pid]
+		if !ok {
+			continue
+		}
+		pending := r.pending[pid]
+		if pkt.PUSI() {
+			if pending != nil {
+				fn(pending.pes)
+			}
+			pending = r.start(&pkt, es)
+		}
+		if pending == nil {
+			continue
+		}
+
+		pending.pes.Data = append(pending.pes.Data, PESData(&pkt)...)
+		if len(pending.pes.Data) > PESMaxLength {
+			delete(r.pending, pid)
+			continue
+		}
+		if pending.remaining >= 0 {
+			pending.remaining -= len(pkt.Payload())
+			if pending.remaining <= 0 {
+				delete(r.pending, pid)
+				fn(pending.pes)
+			}
+		}
+	}
+	return nil
+}
+
+// start begins a PES at a packet with payload unit start
+func (r *PESReader) start(pkt *Packet, es *ElementaryStream) *pendingPES {
+	payload := pkt.Payload()
+	if len(payload) < PESHeaderSize || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		delete(r.pending, es.PID)
+		return nil
+	}
+	pending := &pendingPES{
+		pes: &PES{
+			PID:          es.PID,
+			StreamType:   es.StreamType,
+			RandomAccess: pkt.RandomAccess(),
+		},
+		remaining: -1,
+	}
+	pending.pes.PTS, pending.pes.HasPTS = PTS(pkt)
+	pending.pes.DTS, _ = DTS(pkt)
+	if length := int(binary.BigEndian.Uint16(payload[4:6])); length > 0 {
+		pending.remaining = PESHeaderSize + length
+	}
+	r.pending[es.PID] = pending
+	return pending
+}
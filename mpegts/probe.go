@@ -0,0 +1,93 @@
+package mpegts
+
+import "time"
+
+// ProbeWindow is the interval over which bitrates are measured
+const ProbeWindow = time.Second
+
+// MediaInfo describes the content of a MPEG-TS stream
+type MediaInfo struct {
+	Programs []ProgramInfo `json:"programs"`
+	Bitrate  uint64        `json:"bitrate"` // bits per second of all PIDs
+}
+
+// Probe passively analyzes a MPEG-TS stream
+// Unlike the Parser it never stops parsing, so PSI changes and codec
+// properties are picked up at any time. The bitrate is measured per PID.
+type Probe struct {
+	parser   *Parser
+	start    time.Time         // start of the current measurement window
+	bytes    map[uint16]uint64 // bytes per PID in the current window
+	bitrates map[uint16]uint64 // bits per second per PID of the last window
+}
+
+func NewProbe() *Probe {
+	return &Probe{
+		parser:   NewParser(),
+		bytes:    make(map[uint16]uint64),
+		bitrates: make(map[uint16]uint64),
+	}
+}
+
+// Parse processes all MPEGTS packets from a buffer
+func (p *Probe) Parse(data []byte) error {
+	return p.parse(data, time.Now())
+}
+
+func (p *Probe) parse(data []byte, now time.Time) error {
+	if p.start.IsZero() {
+		p.start = now
+	}
+
+	pkt := Packet{}
+	for len(data) > 0 {
+		if err := pkt.FromBytes(data); err != nil {
+			return err
+		}
+		data = data[pkt.Size():]
+		pid := pkt.PID()
+		p.bytes[pid] += uint64(pkt.Size())
+
+		_, isPMT := p.parser.pmtMap[pid]
+		if pid == PIDPAT || isPMT {
+			// tables spanning multiple packets are not supported, skip them
+			if pkt.PUSI() {
+				_, _ = p.parser.ParsePSI(pkt.Payload())
+			}
+		} else if es, ok := p.parser.tspMap[pid]; ok {
+			if _, err := es.CodecParser.Parse(&pkt); err != nil {
+				return err
+			}
+		}
+	}
+
+	// finish the measurement window
+	if elapsed := now.Sub(p.start); elapsed >= ProbeWindow {
+		p.bitrates = make(map[uint16]uint64, len(p.bytes))
+		for pid, n := range p.bytes {
+			p.bitrates[pid] = n * 8 * uint64(time.Second) / uint64(elapsed)
+		}
+		p.bytes = make(map[uint16]uint64, len(p.bitrates))
+		p.start = now
+	}
+	return nil
+}
+
+// MediaInfo returns the current stream layout and bitrates
+// Bitrates are omitted if the stream stalled for more than a window.
+func (p *Probe) MediaInfo() *MediaInfo {
+	info := &MediaInfo{Programs: p.parser.Programs()}
+	if time.Since(p.start) > 2*ProbeWindow {
+		return info
+	}
+
+	for _, bitrate := range p.bitrates {
+		info.Bitrate += bitrate
+	}
+	for _, prog := range info.Programs {
+		for i := range prog.Streams {
+			prog.Streams[i].Bitrate = p.bitrates[prog.Streams[i].PID]
+		}
+	}
+	return info
+}
@@ -0,0 +1,58 @@
+package mpegts
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProbe_MediaInfo(t *testing.T) {
+	tests := []struct {
+		file     string
+		expected StreamInfo
+	}{
+		{"h264.ts", StreamInfo{PID: 256, StreamType: StreamTypeH264, CodecInfo: CodecInfo{Codec: "h264", Profile: "High", Level: "1.0", Width: 32, Height: 18}}},
+		{"h264_long.ts", StreamInfo{PID: 256, StreamType: StreamTypeH264, CodecInfo: CodecInfo{Codec: "h264", Profile: "High", Level: "1.2", Width: 320, Height: 180}}},
+		{"h265.ts", StreamInfo{PID: 256, StreamType: StreamTypeH265, CodecInfo: CodecInfo{Codec: "hevc", Profile: "Main", Level: "1.0", Width: 32, Height: 18}}},
+		{"h265_long.ts", StreamInfo{PID: 256, StreamType: StreamTypeH265, CodecInfo: CodecInfo{Codec: "hevc", Profile: "Main", Level: "2.0", Width: 320, Height: 180}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(tt.file)
+			if err != nil {
+				t.Fatalf("failed to open test file")
+			}
+			data = data[:len(data)/PacketLen*PacketLen]
+
+			// receive the whole file within one measurement window
+			p := NewProbe()
+			start := time.Now()
+			if err := p.parse(data[:PacketLen], start); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.parse(data[PacketLen:], start.Add(ProbeWindow)); err != nil {
+				t.Fatal(err)
+			}
+
+			info := p.MediaInfo()
+			if len(info.Programs) != 1 || len(info.Programs[0].Streams) != 1 {
+				t.Fatalf("Expected a single program and stream, got %+v", info.Programs)
+			}
+			if info.Programs[0].Number != 1 || info.Programs[0].PMTPID != 0x1000 {
+				t.Errorf("Unexpected program %d on PMT PID %d", info.Programs[0].Number, info.Programs[0].PMTPID)
+			}
+			if info.Bitrate != uint64(len(data)*8) {
+				t.Errorf("Expected total bitrate %d, got %d", len(data)*8, info.Bitrate)
+			}
+
+			stream := info.Programs[0].Streams[0]
+			if stream.Bitrate == 0 || stream.Bitrate >= info.Bitrate {
+				t.Errorf("Invalid stream bitrate %d of total %d", stream.Bitrate, info.Bitrate)
+			}
+			stream.Bitrate = 0
+			if stream != tt.expected {
+				t.Errorf("Got %+v, expected %+v", stream, tt.expected)
+			}
+		})
+	}
+}
@@ -26,6 +26,7 @@ const (
 type PSIHeader struct {
 	tableID           byte
 	sectionLength     uint16
+	tableIDExtension  uint16 // transport stream id for PAT, program number for PMT
 	versionNumber     byte
 	currentNext       bool // true means current table version is valid, false means current table version not yet valid
 	sectionNumber     byte // number of current section
@@ -41,10 +42,11 @@ func ParsePSIHeader(data []byte) (*PSIHeader, error) {
 	hdr.tableID = data[0]
 	hdr.sectionLength = binary.BigEndian.Uint16(data[1:3]) & 0xfff
 
-	if len(data) < int(3+hdr.sectionLength) {
+	if len(data) < int(3+hdr.sectionLength) || len(data) < PSIHeaderLen {
 		return nil, io.ErrUnexpectedEOF
 	}
 
+	hdr.tableIDExtension = binary.BigEndian.Uint16(data[3:5])
 	hdr.versionNumber = data[5] >> 1 & 0x1f
 	currentNext := data[5] & 0x1
 	if currentNext == 1 {
@@ -0,0 +1,309 @@
+package mpegts
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var ErrInvalidSPS = errors.New("invalid sequence parameter set")
+
+// AVC profile names by profile_idc
+var h264Profiles = map[uint]string{
+	44:  "CAVLC 4:4:4",
+	66:  "Baseline",
+	77:  "Main",
+	88:  "Extended",
+	100: "High",
+	110: "High 10",
+	122: "High 4:2:2",
+	244: "High 4:4:4 Predictive",
+}
+
+// HEVC profile names by general_profile_idc
+var h265Profiles = map[uint]string{
+	1: "Main",
+	2: "Main 10",
+	3: "Main Still Picture",
+	4: "Rext",
+}
+
+// bitReader reads big endian bits and Exp-Golomb codes from a RBSP
+type bitReader struct {
+	data []byte
+	pos  int // position in bits
+	err  error
+}
+
+// newBitReader creates a reader for a NAL unit payload
+// Emulation prevention bytes are removed.
+func newBitReader(data []byte) *bitReader {
+	rbsp := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return &bitReader{data: rbsp}
+}
+
+func (r *bitReader) bits(n int) uint {
+	var v uint
+	for range n {
+		if r.pos >= len(r.data)*8 {
+			r.err = ErrInvalidSPS
+			return 0
+		}
+		v = v<<1 | uint(r.data[r.pos/8]>>(7-r.pos%8))&0x1
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) flag() bool {
+	return r.bits(1) == 1
+}
+
+func (r *bitReader) skip(n int) {
+	r.bits(n)
+}
+
+// ue reads an unsigned Exp-Golomb code
+func (r *bitReader) ue() uint {
+	zeros := 0
+	for r.bits(1) == 0 {
+		if r.err != nil || zeros > 31 {
+			r.err = ErrInvalidSPS
+			return 0
+		}
+		zeros++
+	}
+	return 1<<zeros - 1 + r.bits(zeros)
+}
+
+// se reads a signed Exp-Golomb code
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v&0x1 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+// formatLevel formats a level multiplied by 10, e.g. 31 as "3.1"
+func formatLevel(level uint) string {
+	return fmt.Sprintf("%d.%d", level/10, level%10)
+}
+
+// profileName returns the known name of a profile or its number
+func profileName(profiles map[uint]string, idc uint) string {
+	if name, ok := profiles[idc]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(idc), 10)
+}
+
+// ParseSPS reads profile, level and resolution from the SPS NAL unit of a
+// h.264 or h.265 stream
+func ParseSPS(streamType byte, nal []byte) (CodecInfo, error) {
+	switch streamType {
+	case StreamTypeH264:
+		return parseH264SPS(nal)
+	case StreamTypeH265:
+		return parseH265SPS(nal)
+	}
+	return CodecInfo{}, ErrInvalidSPS
+}
+
+// parseH264SPS reads profile, level and resolution from a h.264 SPS NAL unit
+func parseH264SPS(nal []byte) (CodecInfo, error) {
+	info := CodecInfo{Codec: "h264"}
+	r := newBitReader(nal)
+	r.skip(8) // NAL header
+	profileIdc := r.bits(8)
+	constraints := r.bits(8)
+	levelIdc := r.bits(8)
+	r.ue() // seq_parameter_set_id
+
+	chromaFormatIdc := uint(1)
+	switch profileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormatIdc = r.ue()
+		if chromaFormatIdc == 3 {
+			r.skip(1) // separate_colour_plane_flag
+		}
+		r.ue()    // bit_depth_luma_minus8
+		r.ue()    // bit_depth_chroma_minus8
+		r.skip(1) // qpprime_y_zero_transform_bypass_flag
+
+		// skip scaling lists
+		if r.flag() {
+			numLists := 8
+			if chromaFormatIdc == 3 {
+				numLists = 12
+			}
+			for i := range numLists {
+				if !r.flag() {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for range size {
+					if next != 0 {
+						next = (last + r.se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+
+	pocType := r.ue()
+	switch pocType {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.skip(1) // delta_pic_order_always_zero_flag
+		r.se()    // offset_for_non_ref_pic
+		r.se()    // offset_for_top_to_bottom_field
+		for range r.ue() {
+			r.se() // offset_for_ref_frame
+		}
+	}
+	r.ue()    // max_num_ref_frames
+	r.skip(1) // gaps_in_frame_num_value_allowed_flag
+	widthMbs := r.ue() + 1
+	heightMapUnits := r.ue() + 1
+	frameMbsOnly := r.bits(1)
+	if frameMbsOnly == 0 {
+		r.skip(1) // mb_adaptive_frame_field_flag
+	}
+	r.skip(1) // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	if r.flag() { // frame_cropping_flag
+		cropLeft, cropRight, cropTop, cropBottom = r.ue(), r.ue(), r.ue(), r.ue()
+	}
+	if r.err != nil {
+		return info, r.err
+	}
+
+	// crop units depend on the chroma subsampling
+	cropX, cropY := uint(1), 2-frameMbsOnly
+	switch chromaFormatIdc {
+	case 1:
+		cropX, cropY = 2, 2*(2-frameMbsOnly)
+	case 2:
+		cropX = 2
+	}
+
+	info.Profile = profileName(h264Profiles, profileIdc)
+	if profileIdc == 66 && constraints&0x40 != 0 {
+		info.Profile = "Constrained Baseline"
+	}
+	info.Level = formatLevel(levelIdc)
+	info.Width = int(widthMbs*16 - cropX*(cropLeft+cropRight))
+	info.Height = int((2-frameMbsOnly)*heightMapUnits*16 - cropY*(cropTop+cropBottom))
+	return info, nil
+}
+
+// HEVCConfig holds the SPS fields needed for a HEVC decoder configuration record
+type HEVCConfig struct {
+	ProfileTierLevel  []byte // general profile, tier and level fields, 12 bytes
+	ChromaFormat      uint
+	BitDepthLuma      uint
+	BitDepthChroma    uint
+	NumTemporalLayers uint
+	TemporalIDNested  bool
+	Width             uint // coded size before cropping
+	Height            uint
+	Crop              [4]uint // left, right, top and bottom in chroma units
+}
+
+// ParseHEVCConfig reads the decoder configuration fields of a h.265 SPS NAL unit
+func ParseHEVCConfig(nal []byte) (HEVCConfig, error) {
+	var conf HEVCConfig
+	r := newBitReader(nal)
+	r.skip(16) // NAL header
+	r.skip(4)  // sps_video_parameter_set_id
+	maxSubLayers := int(r.bits(3))
+	conf.NumTemporalLayers = uint(maxSubLayers) + 1
+	conf.TemporalIDNested = r.flag()
+
+	// profile_tier_level, the general part is byte aligned
+	if r.pos/8+12 <= len(r.data) {
+		conf.ProfileTierLevel = r.data[r.pos/8 : r.pos/8+12]
+	}
+	r.skip(96)
+	profilePresent := make([]bool, maxSubLayers)
+	levelPresent := make([]bool, maxSubLayers)
+	for i := range maxSubLayers {
+		profilePresent[i] = r.flag()
+		levelPresent[i] = r.flag()
+	}
+	if maxSubLayers > 0 {
+		r.skip(2 * (8 - maxSubLayers)) // reserved_zero_2bits
+	}
+	for i := range maxSubLayers {
+		if profilePresent[i] {
+			r.skip(88)
+		}
+		if levelPresent[i] {
+			r.skip(8)
+		}
+	}
+
+	r.ue() // sps_seq_parameter_set_id
+	conf.ChromaFormat = r.ue()
+	if conf.ChromaFormat == 3 {
+		r.skip(1) // separate_colour_plane_flag
+	}
+	conf.Width = r.ue()
+	conf.Height = r.ue()
+	if r.flag() { // conformance_window_flag
+		conf.Crop = [4]uint{r.ue(), r.ue(), r.ue(), r.ue()}
+	}
+	conf.BitDepthLuma = r.ue() + 8
+	conf.BitDepthChroma = r.ue() + 8
+	return conf, r.err
+}
+
+// parseH265SPS reads profile, level and resolution from a h.265 SPS NAL unit
+func parseH265SPS(nal []byte) (CodecInfo, error) {
+	info := CodecInfo{Codec: "hevc"}
+	conf, err := ParseHEVCConfig(nal)
+	if err != nil {
+		return info, err
+	}
+	profileIdc := uint(conf.ProfileTierLevel[0] & 0x1f)
+	levelIdc := uint(conf.ProfileTierLevel[11])
+
+	cropX, cropY := uint(1), uint(1)
+	switch conf.ChromaFormat {
+	case 1:
+		cropX, cropY = 2, 2
+	case 2:
+		cropX = 2
+	}
+
+	info.Profile = profileName(h265Profiles, profileIdc)
+	info.Level = formatLevel(levelIdc / 3)
+	info.Width = int(conf.Width - cropX*(conf.Crop[0]+conf.Crop[1]))
+	info.Height = int(conf.Height - cropY*(conf.Crop[2]+conf.Crop[3]))
+	return info, nil
+}
@@ -0,0 +1,252 @@
+package record
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IGLOU-EU/go-wildcard/v2"
+	"github.com/voc/srtrelay/relay"
+)
+
+var (
+	ErrRecording    = errors.New("stream is already being recorded")
+	ErrNotRecording = errors.New("stream is not being recorded")
+	ErrInvalidPath  = errors.New("recording path outside of directory")
+)
+
+type Config struct {
+	// directory all recordings are stored in
+	Directory string
+
+	// path of recorded files relative to the directory
+	// {name}, {date} and {time} are replaced when a file is started.
+	Path string
+
+	// stream names recorded automatically, supports the wildcards of the
+	// static authenticator
+	Streams []string
+
+	// start a new file at the next keyframe after this duration, 0 disables
+	RotateDuration time.Duration
+
+	// start a new file at the next keyframe after this size in bytes, 0 disables
+	RotateSize uint64
+}
+
+// Recording describes a recorded file
+type Recording struct {
+	Path     string    `json:"path"` // relative to the recording directory
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Stream   string    `json:"stream,omitempty"` // set while the file is written
+}
+
+// Recorder writes streams of the relay to disk
+// Streams matching the configured patterns are recorded from the moment they
+// are published, others can be started and stopped on demand.
+type Recorder struct {
+	config Config
+	relay  relay.Relay
+	now    func() time.Time
+
+	mutex  sync.Mutex
+	active map[string]*recording // by stream name
+}
+
+func NewRecorder(config Config, r relay.Relay) *Recorder {
+	rec := &Recorder{
+		config: config,
+		relay:  r,
+		now:    time.Now,
+		active: make(map[string]*recording),
+	}
+	r.OnChannel(rec.onChannel)
+	return rec
+}
+
+// onChannel starts recording new channels matching the configured patterns
+func (r *Recorder) onChannel(name string) {
+	for _, pattern := range r.config.Streams {
+		if !wildcard.Match(pattern, name) {
+			continue
+		}
+		if err := r.Start(name); err != nil && err != ErrRecording {
+			log.Printf("Failed to record %s: %v", name, err)
+		}
+		return
+	}
+}
+
+// Start records a stream until it is stopped or the stream ends
+// The first file starts at the next keyframe.
+func (r *Recorder) Start(name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.active[name]; ok {
+		return ErrRecording
+	}
+	sub, unsub, err := r.relay.Subscribe(name)
+	if err != nil {
+		return err
+	}
+	rec := newRecording(name, &r.config, sub, unsub)
+	r.active[name] = rec
+	log.Println("Started recording", name)
+	go r.run(rec)
+	return nil
+}
+
+// Stop ends the recording of a stream and waits until its file is closed
+func (r *Recorder) Stop(name string) error {
+	r.mutex.Lock()
+	rec, ok := r.active[name]
+	r.mutex.Unlock()
+	if !ok {
+		return ErrNotRecording
+	}
+	rec.stop()
+	<-rec.done
+	return nil
+}
+
+// Close stops all recordings
+func (r *Recorder) Close() {
+	r.mutex.Lock()
+	names := make([]string, 0, len(r.active))
+	for name := range r.active {
+		names = append(names, name)
+	}
+	r.mutex.Unlock()
+	for _, name := range names {
+		_ = r.Stop(name)
+	}
+}
+
+// run writes the stream until the recording is stopped or the stream ends
+func (r *Recorder) run(rec *recording) {
+	defer close(rec.done)
+	for {
+		buf, ok := rec.sub.Read()
+		if !ok {
+			if r.resubscribe(rec) {
+				continue
+			}
+			break
+		}
+		if err := rec.write(buf, r.now()); err != nil {
+			log.Printf("Recording %s failed: %v", rec.name, err)
+			rec.stop()
+		}
+	}
+	if err := rec.closeFile(); err != nil {
+		log.Printf("Recording %s failed: %v", rec.name, err)
+	}
+	log.Println("Stopped recording", rec.name)
+}
+
+// resubscribe continues a recording after its subscriber ended, e.g. because
+// it fell behind or the stream was published again in the meantime
+// The recording is removed if it was stopped or the stream no longer exists.
+func (r *Recorder) resubscribe(rec *recording) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if !rec.stopped {
+		sub, unsub, err := r.relay.Subscribe(rec.name)
+		if err == nil {
+			rec.sub, rec.unsub = sub, unsub
+			rec.restart()
+			return true
+		}
+	}
+	delete(r.active, rec.name)
+	return false
+}
+
+// Recordings lists all files in the recording directory
+func (r *Recorder) Recordings() ([]Recording, error) {
+	r.mutex.Lock()
+	writing := make(map[string]string, len(r.active))
+	for name, rec := range r.active {
+		if path := rec.currentPath(); path != "" {
+			writing[path] = name
+		}
+	}
+	r.mutex.Unlock()
+
+	recordings := make([]Recording, 0)
+	err := filepath.WalkDir(r.config.Directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(r.config.Directory, path)
+		if err != nil {
+			return err
+		}
+		recordings = append(recordings, Recording{
+			Path:     filepath.ToSlash(rel),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+			Stream:   writing[rel],
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(recordings, func(a, b Recording) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return recordings, nil
+}
+
+// expandPath fills in the path template for a file started at t
+func expandPath(template, name string, t time.Time) (string, error) {
+	path := strings.NewReplacer(
+		"{name}", name,
+		"{date}", t.Format("2006-01-02"),
+		"{time}", t.Format("15-04-05"),
+	).Replace(template)
+	path = filepath.Clean(filepath.FromSlash(path))
+	if !filepath.IsLocal(path) {
+		return "", ErrInvalidPath
+	}
+	return path, nil
+}
+
+// createFile creates a new file for path in dir, a counter is appended if
+// the file already exists
+func createFile(dir, path string) (*os.File, string, error) {
+	full := filepath.Join(dir, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return nil, "", err
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(filepath.Join(dir, path), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, path, err
+		}
+		path = base + "-" + strconv.Itoa(i) + ext
+	}
+}
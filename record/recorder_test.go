@@ -0,0 +1,129 @@
+package record
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/voc/srtrelay/mpegts"
+	"github.com/voc/srtrelay/relay"
+)
+
+func TestRecorder_Rotate(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = data[:len(data)/1316*1316]
+	tests := []struct {
+		name     string
+		streams  []string
+		config   Config
+		expected []string
+	}{
+		// the test file contains three keyframes one second apart
+		{"Size", []string{"te*"}, Config{RotateSize: 1}, []string{"test/2024-01-01/12-00-00-1.ts", "test/2024-01-01/12-00-00-2.ts", "test/2024-01-01/12-00-00.ts"}},
+		{"Disabled", []string{"te*"}, Config{}, []string{"test/2024-01-01/12-00-00.ts"}},
+		{"NotMatching", []string{"other"}, Config{RotateSize: 1}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := relay.NewRelay(&relay.RelayConfig{BufferSize: uint(len(data)), PacketSize: 1316})
+			config := tt.config
+			config.Directory = t.TempDir()
+			config.Path = "{name}/{date}/{time}.ts"
+			config.Streams = tt.streams
+			rec := NewRecorder(config, r)
+			rec.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local) }
+
+			pub, _, err := r.Publish("test", relay.PolicyDefault)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec.mutex.Lock()
+			active := rec.active["test"]
+			rec.mutex.Unlock()
+			for offset := 0; offset < len(data); offset += 1316 {
+				pub <- data[offset : offset+1316]
+			}
+			close(pub)
+			if active != nil {
+				<-active.done
+			}
+
+			recordings, err := rec.Recordings()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recordings) != len(tt.expected) {
+				t.Fatalf("Expected %d recordings, got %+v", len(tt.expected), recordings)
+			}
+			for i, recording := range recordings {
+				if recording.Path != tt.expected[i] || recording.Stream != "" {
+					t.Errorf("Got recording %+v, expected %s", recording, tt.expected[i])
+				}
+				file, err := os.ReadFile(filepath.Join(config.Directory, recording.Path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(file)%mpegts.PacketLen != 0 || file[0] != mpegts.SyncByte || file[1]&0x1f != 0 || file[2] != 0 {
+					t.Errorf("Recording %s does not start with a PAT", recording.Path)
+				}
+			}
+		})
+	}
+}
+
+func TestRecorder_StartStop(t *testing.T) {
+	r := relay.NewRelay(&relay.RelayConfig{BufferSize: 1316 * 10, PacketSize: 1316})
+	rec := NewRecorder(Config{Directory: t.TempDir(), Path: "{name}.ts"}, r)
+	if err := rec.Start("test"); err != relay.ErrStreamNotExisting {
+		t.Errorf("Got %v, expected %v", err, relay.ErrStreamNotExisting)
+	}
+	pub, _, err := r.Publish("test", relay.PolicyDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(pub)
+	if err := rec.Start("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Start("test"); err != ErrRecording {
+		t.Errorf("Got %v, expected %v", err, ErrRecording)
+	}
+	if err := rec.Stop("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Stop("test"); err != ErrNotRecording {
+		t.Errorf("Got %v, expected %v", err, ErrNotRecording)
+	}
+	// can be started again
+	if err := rec.Start("test"); err != nil {
+		t.Fatal(err)
+	}
+	rec.Close()
+}
+
+func TestExpandPath(t *testing.T) {
+	now := time.Date(2024, 3, 9, 8, 7, 6, 0, time.UTC)
+	tests := []struct {
+		template string
+		name     string
+		expected string
+		err      error
+	}{
+		{"{name}/{date}/{time}.ts", "live", filepath.FromSlash("live/2024-03-09/08-07-06.ts"), nil},
+		{"{date}_{name}.ts", "a/b", filepath.FromSlash("2024-03-09_a/b.ts"), nil},
+		{"{name}.ts", "../secret", "", ErrInvalidPath},
+		{"/{name}.ts", "live", "", ErrInvalidPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			path, err := expandPath(tt.template, tt.name, now)
+			if path != tt.expected || err != tt.err {
+				t.Errorf("Got %q, %v, expected %q, %v", path, err, tt.expected, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package record
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/voc/srtrelay/format"
+	"github.com/voc/srtrelay/mpegts"
+	"github.com/voc/srtrelay/relay"
+)
+
+// recording writes a single stream into a sequence of files
+type recording struct {
+	name   string
+	config *Config
+	demux  *format.Demuxer
+	raw    bool          // transport without synchronization points
+	done   chan struct{} // closed when the last file was closed
+
+	// guards the subscription and the current path
+	mutex   sync.Mutex
+	sub     *relay.Subscriber
+	unsub   relay.UnsubscribeFunc
+	stopped bool
+	path    string // relative to the directory, empty without open file
+
+	file    *os.File
+	w       *bufio.Writer
+	size    uint64
+	started time.Time
+}
+
+func newRecording(name string, config *Config, sub *relay.Subscriber, unsub relay.UnsubscribeFunc) *recording {
+	return &recording{
+		name:   name,
+		config: config,
+		demux:  format.NewDemuxer(),
+		done:   make(chan struct{}),
+		sub:    sub,
+		unsub:  unsub,
+	}
+}
+
+// stop ends the subscription, the recording finishes after the buffered
+// packets were written
+func (rec *recording) stop() {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if rec.stopped {
+		return
+	}
+	rec.stopped = true
+	rec.unsub()
+}
+
+// restart waits for the next keyframe after the stream was interrupted
+// expects the recording mutex to be held
+func (rec *recording) restart() {
+	rec.demux = format.NewDemuxer()
+	rec.raw = false
+	if err := rec.closeFileLocked(); err != nil {
+		log.Printf("Recording %s failed: %v", rec.name, err)
+	}
+}
+
+func (rec *recording) currentPath() string {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	return rec.path
+}
+
+// write processes a buffer of the stream
+// Files are only started at keyframes, so every file can be played on its own.
+func (rec *recording) write(buf []byte, now time.Time) error {
+	if rec.raw {
+		return rec.writeRaw(buf, now)
+	}
+	for i := 0; i+mpegts.PacketLen <= len(buf); i += mpegts.PacketLen {
+		pkt := buf[i : i+mpegts.PacketLen]
+
+		init, err := rec.demux.FindInit(pkt)
+		if err != nil {
+			// resynchronize but keep the current file
+			rec.demux = format.NewDemuxer()
+		}
+		if init == nil {
+			if rec.file != nil {
+				if err := rec.append(pkt); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		rec.demux.Reset()
+
+		// unsupported transport, write as is
+		if len(init) == 0 {
+			rec.raw = true
+			return rec.writeRaw(buf[i:], now)
+		}
+
+		if rec.file != nil && !rec.due(now) {
+			if err := rec.append(pkt); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := rec.rotate(now); err != nil {
+			return err
+		}
+		for _, p := range init {
+			if err := rec.append(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeRaw writes a stream without synchronization points, files are
+// rotated at buffer boundaries
+func (rec *recording) writeRaw(buf []byte, now time.Time) error {
+	if rec.file == nil || rec.due(now) {
+		if err := rec.rotate(now); err != nil {
+			return err
+		}
+	}
+	return rec.append(buf)
+}
+
+// due returns whether the current file reached the rotation limits
+func (rec *recording) due(now time.Time) bool {
+	if rec.config.RotateDuration > 0 && now.Sub(rec.started) >= rec.config.RotateDuration {
+		return true
+	}
+	return rec.config.RotateSize > 0 && rec.size >= rec.config.RotateSize
+}
+
+func (rec *recording) append(data []byte) error {
+	n, err := rec.w.Write(data)
+	rec.size += uint64(n)
+	return err
+}
+
+// rotate closes the current file and starts the next one
+func (rec *recording) rotate(now time.Time) error {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if err := rec.closeFileLocked(); err != nil {
+		return err
+	}
+	path, err := expandPath(rec.config.Path, rec.name, now)
+	if err != nil {
+		return err
+	}
+	f, path, err := createFile(rec.config.Directory, path)
+	if err != nil {
+		return err
+	}
+	rec.file = f
+	rec.w = bufio.NewWriterSize(f, 64*1024)
+	rec.path = path
+	rec.size = 0
+	rec.started = now
+	return nil
+}
+
+func (rec *recording) closeFile() error {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	return rec.closeFileLocked()
+}
+
+// closeFileLocked flushes and closes the current file
+// expects the recording mutex to be held
+func (rec *recording) closeFileLocked() error {
+	if rec.file == nil {
+		return nil
+	}
+	err := rec.w.Flush()
+	if cerr := rec.file.Close(); err == nil {
+		err = cerr
+	}
+	rec.file = nil
+	rec.w = nil
+	rec.path = ""
+	return err
+}
@@ -1,12 +1,17 @@
 package relay
 
 import (
+	"context"
 	"log"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/voc/srtrelay/dash"
+	"github.com/voc/srtrelay/format"
+	"github.com/voc/srtrelay/hls"
 	"github.com/voc/srtrelay/internal/metrics"
+	"github.com/voc/srtrelay/mpegts"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -29,61 +34,139 @@ var (
 		},
 		[]string{"channel_name"},
 	)
+	ingressBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(metrics.Namespace, relaySubsystem, "ingress_bytes_total"),
+			Help: "The number of bytes published to the channel",
+		},
+		[]string{"channel_name"},
+	)
+	ingressPackets = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(metrics.Namespace, relaySubsystem, "ingress_packets_total"),
+			Help: "The number of packets published to the channel",
+		},
+		[]string{"channel_name"},
+	)
+	egressBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(metrics.Namespace, relaySubsystem, "egress_bytes_total"),
+			Help: "The number of bytes read by all clients of the channel",
+		},
+		[]string{"channel_name"},
+	)
+	ingressBitrate = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(metrics.Namespace, relaySubsystem, "ingress_bits_per_second"),
+			Help: "The moving average of the published bitrate",
+		},
+		[]string{"channel_name"},
+	)
+	droppedClients = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(metrics.Namespace, relaySubsystem, "dropped_clients_total"),
+			Help: "The number of clients dropped for falling behind by more than the buffer size",
+		},
+		[]string{"channel_name"},
+	)
+	tsErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(metrics.Namespace, relaySubsystem, "ts_errors_total"),
+			Help: "The number of ETSI TR 101 290 errors per channel and indicator",
+		},
+		[]string{"channel_name", "indicator"},
+	)
+)
+
+// bytes read by a subscriber before they are added to the egress counter,
+// which is shared by all subscribers of a channel
+const egressBatch = 64 * 1316
+
+// bitrate measurement
+const (
+	bitrateInterval  = time.Second
+	bitrateSmoothing = 0.2 // weight of the latest interval in the moving average
 )
 
 type UnsubscribeFunc func()
 
+// Channel fans out published packets to its subscribers.
+// Packets are stored once in a shared ring buffer and every subscriber
+// reads from it using its own cursor.
+// Parsing the stream happens after the packet was handed to the subscribers,
+// under a separate lock, so it does not delay them.
 type Channel struct {
-	name       string
-	mutex      sync.Mutex
-	subs       Subs
-	maxPackets uint
+	name   string
+	mutex  sync.RWMutex
+	ring   [][]byte      // shared ring buffer of published packets
+	head   uint64        // total number of published packets, next write position
+	notify chan struct{} // closed on every publish to wake up waiting subscribers
+	subs   map[*Subscriber]struct{}
+	closed bool             // changed with both mutexes held
+	gop    *gopCache        // replayed to new subscribers, nil if disabled
+	shift  *timeshiftBuffer // nil if disabled
+
+	// parsed stream, locked after mutex if both are needed
+	media  sync.RWMutex
+	demux  *format.Demuxer  // finds GOP starts, nil without GOP cache and timeshift
+	probe  *format.Prober   // nil until MPEG-TS is published
+	health *mpegts.Analyzer // nil if disabled
+	hls    *hls.Segmenter   // nil if disabled
+	dash   *dash.Packager   // nil if disabled
 
 	// statistics
-	clients atomic.Value
-	created time.Time
+	clients      atomic.Value
+	created      time.Time
+	rateStart    time.Time // start of the current bitrate interval
+	rateBytes    uint64    // bytes published in the current bitrate interval
+	rateAverage  float64   // moving average of the bitrate in bits per second
+	rateMeasured bool      // whether the moving average was initialized
 
 	// Prometheus metrics.
 	activeClients    prometheus.Gauge
 	createdTimestamp prometheus.Gauge
+	ingressBytes     prometheus.Counter
+	ingressPackets   prometheus.Counter
+	egressBytes      prometheus.Counter
+	ingressBitrate   prometheus.Gauge
+	droppedClients   prometheus.Counter
 }
-type Subs []chan []byte
 
 type Stats struct {
 	clients int
 	created time.Time
+	media   *mpegts.MediaInfo
 }
 
-// Remove single subscriber
-func (subs Subs) Remove(sub chan []byte) Subs {
-	idx := -1
-	for i := range subs {
-		if subs[i] == sub {
-			idx = i
-			break
-		}
-	}
-
-	// subscriber was already removed
-	if idx < 0 {
-		return subs
-	}
-
-	defer close(sub)
-
-	subs[idx] = subs[len(subs)-1] // Copy last element to index i.
-	subs[len(subs)-1] = nil       // Erase last element (write zero value).
-	return subs[:len(subs)-1]     // Truncate slice.
+// Subscriber reads packets from a Channel
+type Subscriber struct {
+	ch      *Channel
+	backlog [][]byte      // cached packets to read before the live edge
+	cursor  uint64        // position of the next packet to read
+	done    chan struct{} // closed when the subscriber is removed from the channel
+	delay   time.Duration // timeshift delay, 0 reads from the live edge
+	egress  atomic.Int64  // bytes read but not counted yet
 }
 
 func NewChannel(name string, maxPackets uint) *Channel {
+	// keep at least one packet, otherwise every subscriber would overflow
+	if maxPackets == 0 {
+		maxPackets = 1
+	}
 	channelActiveClients := activeClients.WithLabelValues(name)
 	ch := &Channel{
 		name:          name,
-		subs:          make([]chan []byte, 0, 10),
-		maxPackets:    maxPackets,
+		ring:          make([][]byte, maxPackets),
+		notify:        make(chan struct{}),
+		subs:          make(map[*Subscriber]struct{}),
 		created:       time.Now(),
 		activeClients: channelActiveClients,
+
+		ingressBytes:   ingressBytes.WithLabelValues(name),
+		ingressPackets: ingressPackets.WithLabelValues(name),
+		egressBytes:    egressBytes.WithLabelValues(name),
+		ingressBitrate: ingressBitrate.WithLabelValues(name),
+		droppedClients: droppedClients.WithLabelValues(name),
 	}
 	ch.clients.Store(0)
 	ch.createdTimestamp = channelCreatedTimestamp.WithLabelValues(name)
@@ -91,70 +174,539 @@ func NewChannel(name string, maxPackets uint) *Channel {
 	return ch
 }
 
-// Sub subscribes to a channel
-func (ch *Channel) Sub() (<-chan []byte, UnsubscribeFunc) {
+// WithGOPCache enables replaying the most recent GOP to new subscribers
+// The cache is limited to maxBytes, larger GOPs are not cached.
+func (ch *Channel) WithGOPCache(maxBytes uint) *Channel {
+	ch.gop = newGOPCache(maxBytes)
+	ch.demux = format.NewDemuxer()
+	return ch
+}
+
+// WithAnalyzer enables checking the published stream for TR 101 290 errors
+func (ch *Channel) WithAnalyzer() *Channel {
+	counters := make([]prometheus.Counter, mpegts.NumIndicators)
+	for i := range mpegts.NumIndicators {
+		counters[i] = tsErrors.WithLabelValues(ch.name, i.String())
+	}
+	ch.health = mpegts.NewAnalyzer().WithErrorHandler(func(indicator mpegts.Indicator) {
+		counters[indicator].Inc()
+	})
+	return ch
+}
+
+// WithSegmenter enables packaging the published stream as HLS
+// Segments are at least duration long, the playlist holds window segments.
+// A part duration above 0 enables LL-HLS parts.
+func (ch *Channel) WithSegmenter(duration, partDuration time.Duration, window uint) *Channel {
+	ch.hls = hls.NewSegmenter(duration, window)
+	if partDuration > 0 {
+		ch.hls.WithParts(partDuration)
+	}
+	return ch
+}
+
+// WithPackager enables packaging the published stream as MPEG-DASH
+// Segments are at least duration long, the manifest holds window segments.
+func (ch *Channel) WithPackager(duration time.Duration, window uint) *Channel {
+	ch.dash = dash.NewPackager(duration, window)
+	return ch
+}
+
+// WithTimeshift enables starting playback up to window in the past
+// The buffer is limited to maxBytes, the window shrinks for larger streams.
+func (ch *Channel) WithTimeshift(window time.Duration, maxBytes uint) *Channel {
+	ch.shift = newTimeshiftBuffer(window, maxBytes)
+	ch.demux = format.NewDemuxer()
+	return ch
+}
+
+// Sub subscribes to a channel, the subscriber starts reading at the live edge
+// or at the start of the cached GOP
+func (ch *Channel) Sub() (*Subscriber, UnsubscribeFunc) {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	sub := &Subscriber{
+		ch:     ch,
+		cursor: ch.head,
+		done:   make(chan struct{}),
+	}
+	if ch.gop != nil {
+		sub.backlog = ch.gop.snapshot()
+	}
+	return sub, ch.add(sub)
+}
+
+// SubAt subscribes to a channel starting offset in the past
+// Playback starts at the buffered keyframe closest to offset and keeps the
+// original timing of the stream, so the subscriber stays delayed.
+func (ch *Channel) SubAt(offset time.Duration) (*Subscriber, UnsubscribeFunc, error) {
 	ch.mutex.Lock()
 	defer ch.mutex.Unlock()
-	sub := make(chan []byte, ch.maxPackets)
-	ch.subs = append(ch.subs, sub)
+	if ch.shift == nil {
+		return nil, nil, ErrTimeshiftUnavailable
+	}
+	now := time.Now()
+	kf, ok := ch.shift.seek(offset, now)
+	if !ok {
+		return nil, nil, ErrTimeshiftUnavailable
+	}
+	// buffer positions match the ring buffer, so subscribers without delay
+	// continue at the live edge
+	sub := &Subscriber{
+		ch:      ch,
+		backlog: kf.init[:len(kf.init):len(kf.init)],
+		cursor:  kf.pos,
+		done:    make(chan struct{}),
+		delay:   now.Sub(kf.time),
+	}
+	return sub, ch.add(sub), nil
+}
+
+// add registers a new subscriber
+// expects the channel mutex to be held
+func (ch *Channel) add(sub *Subscriber) UnsubscribeFunc {
+	// Channel already closed, return a finished subscriber
+	if ch.closed {
+		close(sub.done)
+		return func() {}
+	}
+
+	ch.subs[sub] = struct{}{}
 	ch.clients.Store(len(ch.subs))
 	ch.activeClients.Inc()
 
-	var unsub UnsubscribeFunc = func() {
+	return func() {
+		defer sub.countEgress()
 		ch.mutex.Lock()
 		defer ch.mutex.Unlock()
+		ch.remove(sub)
+	}
+}
 
-		// Channel already closed, just skip unsub
-		if ch.subs == nil {
-			return
-		}
+// remove a single subscriber, returns false if it was already removed
+// expects the channel mutex to be held
+func (ch *Channel) remove(sub *Subscriber) bool {
+	// Channel already closed, just skip unsub
+	if ch.closed {
+		return false
+	}
 
-		ch.subs = ch.subs.Remove(sub)
-		ch.clients.Store(len(ch.subs))
-		ch.activeClients.Dec()
+	// subscriber was already removed
+	if _, ok := ch.subs[sub]; !ok {
+		return false
+	}
+
+	delete(ch.subs, sub)
+	close(sub.done)
+	ch.clients.Store(len(ch.subs))
+	ch.activeClients.Dec()
+	return true
+}
+
+// drop removes a subscriber which fell behind by more than the buffer size
+func (ch *Channel) drop(sub *Subscriber) {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	if ch.remove(sub) {
+		log.Println("dropping overflowing client", ch.name)
+		ch.droppedClients.Inc()
 	}
-	return sub, unsub
 }
 
 // Pub publishes a packet to a channel
 func (ch *Channel) Pub(b []byte) {
+	// parse before publishing, so the GOP cache stays in sync with the ring
+	init := ch.parse(b)
+
 	ch.mutex.Lock()
-	defer ch.mutex.Unlock()
+	if ch.closed {
+		ch.mutex.Unlock()
+		return
+	}
 
-	toRemove := make(Subs, 0, 5)
-	for i := range ch.subs {
-		select {
-		case ch.subs[i] <- b:
-			continue
+	ch.ring[ch.head%uint64(len(ch.ring))] = b
+	ch.head++
+	ch.ingressBytes.Add(float64(len(b)))
+	ch.ingressPackets.Inc()
+	ch.measureBitrate(len(b), time.Now())
+	if ch.gop != nil {
+		ch.gop.push(b, init)
+	}
+	if ch.shift != nil {
+		ch.shift.push(b, init, time.Now())
+	}
 
-		// Remember overflowed chans for drop
-		default:
-			toRemove = append(toRemove, ch.subs[i])
-			log.Println("dropping overflowing client", i)
+	// wake up all waiting subscribers
+	close(ch.notify)
+	ch.notify = make(chan struct{})
+	ch.mutex.Unlock()
+}
+
+// parse passes a published packet to the probe, analyzer and packagers
+// Returns the init data if the packet starts a GOP, see findGOP.
+func (ch *Channel) parse(b []byte) [][]byte {
+	ch.media.Lock()
+	defer ch.media.Unlock()
+	if ch.closed {
+		return nil
+	}
+	init := ch.findGOP(b)
+	if ch.probe == nil && format.DetermineTransport(b) != format.Unknown {
+		ch.probe = format.NewProber()
+	}
+	if ch.health != nil {
+		ch.health.Parse(b)
+	}
+	if ch.hls != nil {
+		if err := ch.hls.Push(b); err != nil {
+			log.Println("hls:", err)
 		}
 	}
-	for _, sub := range toRemove {
-		ch.subs = ch.subs.Remove(sub)
-		ch.activeClients.Dec()
+	if ch.dash != nil {
+		if err := ch.dash.Push(b); err != nil {
+			log.Println("dash:", err)
+		}
 	}
-	ch.clients.Store(len(ch.subs))
+	if ch.probe != nil {
+		if err := ch.probe.Parse(b); err != nil {
+			log.Println("probe:", err)
+			ch.probe = nil
+		}
+	}
+	return init
+}
+
+// findGOP returns the init data up to and including b if b starts a GOP
+// The result is nil within a GOP and empty for unsupported transports or
+// corrupt streams.
+func (ch *Channel) findGOP(b []byte) [][]byte {
+	if ch.demux == nil {
+		return nil
+	}
+	init, err := ch.demux.FindInit(b)
+	if err != nil {
+		log.Println("demux:", err)
+		ch.demux = format.NewDemuxer()
+		return [][]byte{}
+	}
+	if init != nil {
+		ch.demux.Reset()
+	}
+	return init
+}
+
+// measureBitrate updates the moving average of the published bitrate
+// expects the channel mutex to be held
+func (ch *Channel) measureBitrate(n int, now time.Time) {
+	if ch.rateStart.IsZero() {
+		ch.rateStart = now
+	}
+	ch.rateBytes += uint64(n)
+
+	elapsed := now.Sub(ch.rateStart)
+	if elapsed < bitrateInterval {
+		return
+	}
+	rate := float64(ch.rateBytes*8) / elapsed.Seconds()
+	if ch.rateMeasured {
+		ch.rateAverage += bitrateSmoothing * (rate - ch.rateAverage)
+	} else {
+		ch.rateAverage = rate
+		ch.rateMeasured = true
+	}
+	ch.ingressBitrate.Set(ch.rateAverage)
+	ch.rateStart = now
+	ch.rateBytes = 0
 }
 
 // Close closes a channel
 func (ch *Channel) Close() {
 	ch.mutex.Lock()
 	defer ch.mutex.Unlock()
-	for i := range ch.subs {
-		close(ch.subs[i])
+	if ch.closed {
+		return
+	}
+	ch.media.Lock()
+	ch.closed = true
+	if ch.hls != nil {
+		ch.hls.Close()
 	}
+	ch.media.Unlock()
+	close(ch.notify)
 	ch.subs = nil
+	ch.clients.Store(0)
 	activeClients.DeleteLabelValues(ch.name)
 	channelCreatedTimestamp.DeleteLabelValues(ch.name)
+	ingressBytes.DeleteLabelValues(ch.name)
+	ingressPackets.DeleteLabelValues(ch.name)
+	egressBytes.DeleteLabelValues(ch.name)
+	ingressBitrate.DeleteLabelValues(ch.name)
+	droppedClients.DeleteLabelValues(ch.name)
+	tsErrors.DeletePartialMatch(prometheus.Labels{"channel_name": ch.name})
 }
 
 func (ch *Channel) Stats() Stats {
-	return Stats{
+	ch.media.RLock()
+	defer ch.media.RUnlock()
+	stats := Stats{
 		clients: ch.clients.Load().(int),
 		created: ch.created,
 	}
+	if ch.probe != nil {
+		stats.media = ch.probe.MediaInfo()
+	}
+	return stats
+}
+
+// Health returns the analyzer results or nil if the analyzer is disabled
+func (ch *Channel) Health() *mpegts.Health {
+	ch.media.RLock()
+	defer ch.media.RUnlock()
+	if ch.health == nil {
+		return nil
+	}
+	return ch.health.Health()
+}
+
+// Playlist returns the current HLS playlist
+// If until is set, blocks until the playlist contains the position.
+func (ch *Channel) Playlist(ctx context.Context, until *hls.Position) (*hls.Playlist, error) {
+	var playlist *hls.Playlist
+	var err error
+	waitErr := ch.waitHLS(ctx, func(s *hls.Segmenter) bool {
+		if until != nil {
+			var ready bool
+			ready, err = s.Ready(*until)
+			if !ready && err == nil {
+				return false
+			}
+		}
+		playlist = s.Playlist()
+		return true
+	})
+	if waitErr != nil {
+		return nil, waitErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if playlist == nil {
+		return nil, ErrSegmentNotFound
+	}
+	return playlist, nil
+}
+
+// Segment returns a HLS segment or part
+// Blocks if the part is the next one to be finished.
+func (ch *Channel) Segment(ctx context.Context, pos hls.Position) ([]byte, error) {
+	var data []byte
+	err := ch.waitHLS(ctx, func(s *hls.Segmenter) bool {
+		var ok bool
+		data, ok = s.Data(pos)
+		return ok || !s.Pending(pos)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrSegmentNotFound
+	}
+	return data, nil
+}
+
+// waitHLS blocks until done returns true for the segmenter
+// done is called with the media mutex held. Waits at most the block timeout
+// of the segmenter.
+func (ch *Channel) waitHLS(ctx context.Context, done func(*hls.Segmenter) bool) error {
+	ch.media.RLock()
+	if ch.hls == nil {
+		ch.media.RUnlock()
+		return ErrSegmentNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, ch.hls.BlockTimeout())
+	defer cancel()
+	for {
+		if done(ch.hls) {
+			ch.media.RUnlock()
+			return nil
+		}
+		closed := ch.closed
+		updated := ch.hls.Updated()
+		ch.media.RUnlock()
+		if closed {
+			return ErrSegmentNotFound
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return ErrHLSTimeout
+		}
+		ch.media.RLock()
+	}
+}
+
+// Manifest returns the current MPEG-DASH manifest
+func (ch *Channel) Manifest() (*dash.Manifest, error) {
+	ch.media.RLock()
+	defer ch.media.RUnlock()
+	if ch.dash == nil {
+		return nil, ErrSegmentNotFound
+	}
+	manifest := ch.dash.Manifest()
+	if manifest == nil {
+		return nil, ErrSegmentNotFound
+	}
+	return manifest, nil
+}
+
+// Fragment returns a MPEG-DASH init or media segment
+func (ch *Channel) Fragment(file dash.File) ([]byte, error) {
+	ch.media.RLock()
+	defer ch.media.RUnlock()
+	if ch.dash == nil {
+		return nil, ErrSegmentNotFound
+	}
+	data, ok := ch.dash.File(file)
+	if !ok {
+		return nil, ErrSegmentNotFound
+	}
+	return data, nil
+}
+
+// Read blocks until the next packet is available.
+// Packets still buffered when the channel is closed are returned first.
+// Returns false if the channel was closed, the subscriber was unsubscribed
+// or if it fell behind by more than the buffer size.
+func (s *Subscriber) Read() ([]byte, bool) {
+	buf, ok := s.read()
+	if s.egress.Add(int64(len(buf))) >= egressBatch || !ok {
+		s.countEgress()
+	}
+	return buf, ok
+}
+
+// countEgress adds the bytes read since the last batch to the egress counter
+func (s *Subscriber) countEgress() {
+	if n := s.egress.Swap(0); n > 0 {
+		s.ch.egressBytes.Add(float64(n))
+	}
+}
+
+func (s *Subscriber) read() ([]byte, bool) {
+	ch := s.ch
+	for {
+		select {
+		case <-s.done:
+			return nil, false
+		default:
+		}
+
+		// Replay cached packets first
+		if len(s.backlog) > 0 {
+			buf := s.backlog[0]
+			s.backlog = s.backlog[1:]
+			return buf, true
+		}
+
+		if s.delay > 0 {
+			return s.readDelayed()
+		}
+
+		ch.mutex.RLock()
+		if s.cursor < ch.head {
+			// Packets at the cursor were already overwritten
+			if ch.head-s.cursor > uint64(len(ch.ring)) {
+				ch.mutex.RUnlock()
+				ch.drop(s)
+				return nil, false
+			}
+			buf := ch.ring[s.cursor%uint64(len(ch.ring))]
+			ch.mutex.RUnlock()
+			s.cursor++
+			return buf, true
+		}
+		if ch.closed {
+			ch.mutex.RUnlock()
+			return nil, false
+		}
+		notify := ch.notify
+		ch.mutex.RUnlock()
+
+		select {
+		case <-notify:
+		case <-s.done:
+		}
+	}
+}
+
+// readDelayed reads the next packet from the timeshift buffer once it is due
+func (s *Subscriber) readDelayed() ([]byte, bool) {
+	ch := s.ch
+	for {
+		select {
+		case <-s.done:
+			return nil, false
+		default:
+		}
+
+		ch.mutex.RLock()
+		shift := ch.shift
+		// Packets at the cursor were already dropped from the buffer
+		if s.cursor < shift.start {
+			ch.mutex.RUnlock()
+			ch.drop(s)
+			return nil, false
+		}
+		if s.cursor < shift.end() {
+			pkt := shift.packets[s.cursor-shift.start]
+			ch.mutex.RUnlock()
+			if wait := time.Until(pkt.time.Add(s.delay)); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-s.done:
+					timer.Stop()
+					return nil, false
+				}
+			}
+			s.cursor++
+			return pkt.data, true
+		}
+		if ch.closed {
+			ch.mutex.RUnlock()
+			return nil, false
+		}
+		notify := ch.notify
+		ch.mutex.RUnlock()
+
+		select {
+		case <-notify:
+		case <-s.done:
+			return nil, false
+		}
+	}
+}
+
+// Len returns the number of packets the subscriber is behind the live edge
+// Cached packets are not counted, see Backlog. Delayed subscribers are
+// behind on purpose and always return 0.
+func (s *Subscriber) Len() int {
+	if s.delay > 0 {
+		return 0
+	}
+	s.ch.mutex.RLock()
+	defer s.ch.mutex.RUnlock()
+	return int(s.ch.head - s.cursor)
+}
+
+// Backlog returns the number of cached packets left to replay before the
+// subscriber reads from the ring buffer
+func (s *Subscriber) Backlog() int {
+	return len(s.backlog)
+}
+
+// Cap returns the maximum number of packets a subscriber may fall behind
+func (s *Subscriber) Cap() int {
+	return len(s.ch.ring)
 }
@@ -1,20 +1,26 @@
 package relay
 
 import (
+	"os"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/voc/srtrelay/format"
 )
 
 func TestChannel_PubSub(t *testing.T) {
 	ch := NewChannel("test", uint(1316*50))
 
 	// sub
-	out, unsub := ch.Sub()
+	sub, unsub := ch.Sub()
 	data := []byte{1, 2, 3, 4}
 
 	// pub
 	ch.Pub(data)
-	got := <-out
+	got, _ := sub.Read()
 
 	if !reflect.DeepEqual(got, data) {
 		t.Errorf("Sub ret = %x, want %x", got, data)
@@ -25,37 +31,56 @@ func TestChannel_PubSub(t *testing.T) {
 
 	// pub2
 	ch.Pub(data)
-	got = <-out
+	got, ok := sub.Read()
 
-	if got != nil {
+	if got != nil || ok {
 		t.Errorf("Read after unsub ret %x, want nil", got)
 	}
 }
 
+func TestChannel_MultipleSubscribers(t *testing.T) {
+	ch := NewChannel("test", 10)
+	sub1, _ := ch.Sub()
+	sub2, _ := ch.Sub()
+
+	for i := 0; i < 5; i++ {
+		ch.Pub([]byte{byte(i)})
+	}
+
+	// Every subscriber reads all packets independently
+	for _, sub := range []*Subscriber{sub1, sub2} {
+		for i := 0; i < 5; i++ {
+			got, ok := sub.Read()
+			if !ok || got[0] != byte(i) {
+				t.Errorf("Read pos %d ret %x, %t, want %x", i, got, ok, i)
+			}
+		}
+	}
+}
+
 func TestChannel_DropOnOverflow(t *testing.T) {
-	ch := NewChannel("test", uint(1316*50))
+	ch := NewChannel("test", 50)
 
 	sub, _ := ch.Sub()
-	capacity := cap(sub) + 1
+	capacity := sub.Cap() + 1
 
 	// Overflow subscriber on purpose
 	for i := 0; i < capacity; i++ {
 		ch.Pub([]byte{})
-		// Should drop and close subscriber on last iteration
 	}
 
-	// Check removal
-	if remaining := len(ch.subs); remaining > 0 {
-		t.Errorf("Got %d remaining channels, expected 0", remaining)
+	if got := sub.Len(); got != capacity {
+		t.Errorf("Expected subscriber to be %d packets late, got %d", capacity, got)
 	}
 
-	// Read elements from buffer
-	for i := 0; i < capacity; i++ {
-		expected := (i < capacity-1)
-		_, got := <-sub
-		if got != expected {
-			t.Errorf("Channel read expects %t on pos %d, got %t", expected, i, got)
-		}
+	// Overflowed subscriber should be dropped on read
+	if _, ok := sub.Read(); ok {
+		t.Error("Read on overflowed subscriber should fail")
+	}
+
+	// Check removal
+	if remaining := len(ch.subs); remaining > 0 {
+		t.Errorf("Got %d remaining subscribers, expected 0", remaining)
 	}
 }
 
@@ -74,8 +99,187 @@ func TestChannel_Close(t *testing.T) {
 		t.Errorf("Expected 0 subscribers after close, got %d", got)
 	}
 
-	if _, ok := <-sub1; ok {
-		t.Error("Subscriber channel should be closed after Close")
+	if _, ok := sub1.Read(); ok {
+		t.Error("Subscriber should be closed after Close")
+	}
+}
+
+func TestChannel_CloseDrainsBuffer(t *testing.T) {
+	ch := NewChannel("test", 10)
+	sub, _ := ch.Sub()
+	data := []byte{1, 2, 3, 4}
+
+	ch.Pub(data)
+	ch.Close()
+
+	if got, ok := sub.Read(); !ok || !reflect.DeepEqual(got, data) {
+		t.Errorf("Read after close ret %x, want %x", got, data)
+	}
+	if _, ok := sub.Read(); ok {
+		t.Error("Subscriber should be closed after reading buffer")
+	}
+}
+
+func TestChannel_GOPCache(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		maxBytes    uint
+		wantBacklog bool
+	}{
+		{"Cached", 1316 * 100, true},
+		{"TooLarge", 1316, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := NewChannel("test", 200).WithGOPCache(tt.maxBytes)
+
+			// Publish mid-GOP until after the next GOP start
+			for offset := 10 * 188; offset < 100*188; offset += 1316 {
+				ch.Pub(data[offset : offset+1316])
+			}
+
+			sub, _ := ch.Sub()
+			if got := sub.Backlog() > 0; got != tt.wantBacklog {
+				t.Fatalf("Subscriber has backlog %t, want %t", got, tt.wantBacklog)
+			}
+			if sub.Len() != 0 {
+				t.Errorf("Subscriber is %d packets late, want 0", sub.Len())
+			}
+			if !tt.wantBacklog {
+				return
+			}
+
+			// Replay starts with the PAT
+			got, _ := sub.Read()
+			if pid := uint16(got[1]&0x1f)<<8 | uint16(got[2]); pid != 0 {
+				t.Errorf("First cached packet has PID %d, want PAT", pid)
+			}
+
+			// Live packets follow after the backlog
+			live := []byte{1, 2, 3, 4}
+			ch.Pub(live)
+			for sub.Backlog()+sub.Len() > 1 {
+				sub.Read()
+			}
+			if got, _ := sub.Read(); !reflect.DeepEqual(got, live) {
+				t.Errorf("Read after backlog ret %x, want %x", got, live)
+			}
+		})
+	}
+}
+
+func TestChannel_Timeshift(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = data[:len(data)/1316*1316]
+
+	if _, _, err := NewChannel("test", 200).SubAt(time.Second); err != ErrTimeshiftUnavailable {
+		t.Fatalf("Got %v, expected %v", err, ErrTimeshiftUnavailable)
+	}
+
+	ch := NewChannel("test", 200).WithTimeshift(time.Minute, 100000000)
+	if _, _, err := ch.SubAt(time.Second); err != ErrTimeshiftUnavailable {
+		t.Fatalf("Got %v before the first keyframe, expected %v", err, ErrTimeshiftUnavailable)
+	}
+	for offset := 0; offset < len(data); offset += 1316 {
+		ch.Pub(data[offset : offset+1316])
+	}
+	sub, _, err := ch.SubAt(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.Close()
+
+	// playback starts with the PAT of the oldest keyframe and ends at the live edge
+	var last []byte
+	for i := 0; ; i++ {
+		buf, ok := sub.Read()
+		if !ok {
+			break
+		}
+		if pid := uint16(buf[1]&0x1f)<<8 | uint16(buf[2]); i == 0 && pid != 0 {
+			t.Errorf("First packet has PID %d, want PAT", pid)
+		}
+		last = buf
+	}
+	if !reflect.DeepEqual(last, data[len(data)-1316:]) {
+		t.Error("Playback did not reach the live edge")
+	}
+}
+
+func TestTimeshiftBuffer(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = data[:len(data)/1316*1316]
+	start := time.Now()
+	push := func(buf *timeshiftBuffer) time.Time {
+		ch := &Channel{demux: format.NewDemuxer()}
+		now := start
+		for offset := 0; offset < len(data); offset += 1316 {
+			now = now.Add(10 * time.Millisecond)
+			pkt := data[offset : offset+1316]
+			buf.push(pkt, ch.findGOP(pkt), now)
+		}
+		return now
+	}
+
+	// the test file contains three keyframes
+	buf := newTimeshiftBuffer(time.Hour, uint(len(data)))
+	now := push(buf)
+	if len(buf.keyframes) != 3 || buf.start != buf.keyframes[0].pos {
+		t.Fatalf("Got %d keyframes, buffer starting at %d", len(buf.keyframes), buf.start)
+	}
+	for i, kf := range buf.keyframes {
+		// snaps to the closest keyframe
+		got, ok := buf.seek(now.Sub(kf.time)+time.Millisecond, now)
+		if !ok || got.pos != kf.pos {
+			t.Errorf("Seeking keyframe %d got position %d, expected %d", i, got.pos, kf.pos)
+		}
+	}
+	if got, _ := buf.seek(time.Hour, now); got.pos != buf.keyframes[0].pos {
+		t.Errorf("Seeking beyond the window got position %d, expected the oldest keyframe", got.pos)
+	}
+
+	// only the newest keyframe is kept without window
+	buf = newTimeshiftBuffer(0, uint(len(data)))
+	now = push(buf)
+	if len(buf.keyframes) != 1 || buf.start != buf.keyframes[0].pos {
+		t.Fatalf("Got %d keyframes, buffer starting at %d", len(buf.keyframes), buf.start)
+	}
+	if _, ok := buf.seek(0, now); ok {
+		t.Error("Keyframes outside of the window should not be seekable")
+	}
+	if buf.end() != uint64(len(data)/1316) {
+		t.Errorf("Buffer ends at %d, expected %d", buf.end(), len(data)/1316)
+	}
+
+	// the oldest keyframes are dropped to stay below maxBytes
+	full := newTimeshiftBuffer(time.Hour, uint(len(data)))
+	push(full)
+	maxBytes := full.size - 1
+	buf = newTimeshiftBuffer(time.Hour, uint(maxBytes))
+	push(buf)
+	if buf.size > maxBytes || len(buf.keyframes) != 2 || buf.start != full.keyframes[1].pos {
+		t.Errorf("Got %d bytes, %d keyframes, buffer starting at %d", buf.size, len(buf.keyframes), buf.start)
+	}
+
+	// nothing is kept if a single GOP exceeds maxBytes
+	buf = newTimeshiftBuffer(time.Hour, 1316)
+	now = push(buf)
+	if buf.size > 1316 || len(buf.keyframes) != 0 {
+		t.Errorf("Got %d bytes and %d keyframes", buf.size, len(buf.keyframes))
+	}
+	if _, ok := buf.seek(0, now); ok {
+		t.Error("Expected no keyframe to seek to")
 	}
 }
 
@@ -95,3 +299,81 @@ func TestChannel_Stats(t *testing.T) {
 		t.Errorf("Expected 0 clients after unsubscribe, got %d", num)
 	}
 }
+
+func TestChannel_StatsMedia(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := NewChannel("test", 10)
+	if media := ch.Stats().media; media != nil {
+		t.Errorf("Expected no media info before publishing, got %+v", media)
+	}
+
+	for offset := 0; offset+1316 <= len(data); offset += 1316 {
+		ch.Pub(data[offset : offset+1316])
+	}
+
+	media := ch.Stats().media
+	if media == nil || len(media.Programs) != 1 || len(media.Programs[0].Streams) != 1 {
+		t.Fatalf("Expected a single program and stream, got %+v", media)
+	}
+	if info := media.Programs[0].Streams[0]; info.Codec != "h264" || info.Width != 320 || info.Height != 180 {
+		t.Errorf("Unexpected stream info %+v", info)
+	}
+}
+
+func TestChannel_Metrics(t *testing.T) {
+	ch := NewChannel("metrics", 2)
+	sub, _ := ch.Sub()
+	other, unsubscribe := ch.Sub()
+	for i := 0; i < 2; i++ {
+		ch.Pub(make([]byte, 100))
+		sub.Read()
+	}
+
+	// counted on unsubscribe
+	other.Read()
+	unsubscribe()
+
+	// overflow
+	for i := 0; i < 3; i++ {
+		ch.Pub(make([]byte, 100))
+	}
+	if _, ok := sub.Read(); ok {
+		t.Fatal("Subscriber should have been dropped")
+	}
+
+	tests := []struct {
+		name     string
+		metric   prometheus.Collector
+		expected float64
+	}{
+		{"IngressBytes", ch.ingressBytes, 500},
+		{"IngressPackets", ch.ingressPackets, 5},
+		{"EgressBytes", ch.egressBytes, 300},
+		{"DroppedClients", ch.droppedClients, 1},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(tt.metric); got != tt.expected {
+			t.Errorf("%s: got %v, expected %v", tt.name, got, tt.expected)
+		}
+	}
+
+	// moving average of 1000 and 2000 bits per second
+	start := time.Now()
+	ch.rateStart = start
+	ch.rateBytes = 0
+	ch.measureBitrate(125, start.Add(bitrateInterval))
+	ch.measureBitrate(250, start.Add(2*bitrateInterval))
+	if got := testutil.ToFloat64(ch.ingressBitrate); got != 1200 {
+		t.Errorf("Bitrate: got %v, expected 1200", got)
+	}
+
+	ch.Close()
+	for _, vec := range []*prometheus.MetricVec{ingressBytes.MetricVec, ingressPackets.MetricVec, egressBytes.MetricVec, ingressBitrate.MetricVec, droppedClients.MetricVec} {
+		if vec.DeleteLabelValues("metrics") {
+			t.Error("Expected metrics to be deleted on close")
+		}
+	}
+}
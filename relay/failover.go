@@ -0,0 +1,271 @@
+package relay
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/voc/srtrelay/format"
+)
+
+// publisher is a single source feeding a channel
+type publisher struct {
+	lastSeen time.Time
+	replaced bool
+	kicked   chan struct{} // closed when replaced by another publisher
+}
+
+// failover selects which of the publishers of a channel is forwarded
+// The first publisher is active, additional publishers are kept as backups
+// and their data is discarded until the active publisher leaves or stays
+// silent for longer than the timeout.
+// An optional fallback publisher takes over if no backup is left and hands
+// the channel back to the first publisher sending again.
+type failover struct {
+	name    string
+	channel *Channel
+	timeout time.Duration
+
+	mutex    sync.Mutex
+	active   *publisher
+	backups  []*publisher
+	pending  *publisher      // backup waiting for a sync point to take over
+	demux    *format.Demuxer // finds the sync point of the pending backup
+	fallback *publisher      // nil without fallback
+	lifetime time.Duration   // time the fallback stays on air, 0 forever
+	stop     context.CancelFunc
+}
+
+func newPublisher() *publisher {
+	return &publisher{
+		lastSeen: time.Now(),
+		kicked:   make(chan struct{}),
+	}
+}
+
+// default time after which a silent publisher is replaced by a backup
+const defaultFailoverTimeout = time.Second
+
+func newFailover(name string, channel *Channel, timeout time.Duration) *failover {
+	// without a timeout every backup packet would take over
+	if timeout <= 0 {
+		timeout = defaultFailoverTimeout
+	}
+	return &failover{
+		name:    name,
+		channel: channel,
+		timeout: timeout,
+	}
+}
+
+// withFallback starts feeding a fallback source as lowest priority publisher
+func (f *failover) withFallback(fallback *Fallback) *failover {
+	ctx, cancel := context.WithCancel(context.Background())
+	f.fallback = newPublisher()
+	f.lifetime = fallback.Timeout
+	f.stop = cancel
+	go f.runFallback(ctx, fallback.Source)
+	return f
+}
+
+// close stops the fallback
+func (f *failover) close() {
+	if f.stop != nil {
+		f.stop()
+	}
+}
+
+// vacant reports whether only the fallback is left
+func (f *failover) vacant() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.fallback != nil && f.isVacant()
+}
+
+// isVacant reports whether no publisher other than the fallback is left
+// expects the failover mutex to be held
+func (f *failover) isVacant() bool {
+	isPublisher := func(pub *publisher) bool { return pub != nil && pub != f.fallback }
+	return !isPublisher(f.active) && !isPublisher(f.pending) && len(f.backups) == 0
+}
+
+// onFallback reports whether the fallback is on air or about to take over
+// expects the failover mutex to be held
+func (f *failover) onFallback() bool {
+	return f.fallback != nil && (f.active == f.fallback || f.pending == f.fallback)
+}
+
+// join adds a new publisher, it becomes active if there is no other publisher
+func (f *failover) join() *publisher {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	pub := newPublisher()
+	if f.active == nil && f.pending == nil {
+		f.active = pub
+	} else {
+		log.Println("Added backup publisher for stream", f.name)
+		f.backups = append(f.backups, pub)
+	}
+	return pub
+}
+
+// replace kicks the active publisher and hands the channel to a new one
+func (f *failover) replace() *publisher {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.active != nil && f.active != f.fallback {
+		log.Println("Replacing publisher on stream", f.name)
+		f.active.replaced = true
+		close(f.active.kicked)
+	}
+	pub := newPublisher()
+	f.active = pub
+	f.pending = nil
+	f.demux = nil
+	return pub
+}
+
+// leave removes a publisher, returns true if no publisher except the fallback
+// is left
+func (f *failover) leave(pub *publisher) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.removeBackup(pub)
+	if f.pending == pub {
+		f.pending = nil
+	}
+	if f.active == pub {
+		f.active = nil
+	}
+
+	if f.active == nil && f.pending == nil {
+		switch {
+		case len(f.backups) > 0:
+			log.Println("Active publisher left stream", f.name)
+			f.startSwitch(f.backups[0])
+		case f.fallback != nil:
+			log.Println("Last publisher left stream", f.name)
+			f.startSwitch(f.fallback)
+		}
+	}
+
+	return f.isVacant()
+}
+
+// resync lets a publisher take over again at its next sync point, e.g. after
+// its source restarted
+func (f *failover) resync(pub *publisher) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.active == pub {
+		f.active = nil
+		f.startSwitch(pub)
+	}
+}
+
+// push forwards the data of the active publisher to the channel
+func (f *failover) push(pub *publisher, buf []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// discard data of replaced publishers
+	if pub.replaced {
+		return
+	}
+
+	now := time.Now()
+	pub.lastSeen = now
+
+	switch pub {
+	case f.active:
+		// active publisher recovered, cancel switch
+		if f.pending != nil && pub != f.fallback {
+			log.Println("Active publisher recovered on stream", f.name)
+			f.pending = nil
+			f.demux = nil
+		}
+		f.channel.Pub(buf)
+		return
+
+	case f.pending:
+
+	default:
+		switch {
+		// publisher returned while the fallback is on air
+		case pub != f.fallback && f.onFallback():
+			log.Println("Publisher returned on stream", f.name)
+			f.startSwitch(pub)
+		// backups take precedence over the fallback
+		case pub == f.fallback && len(f.backups) > 0:
+			return
+		// discard backup data while the active publisher is healthy
+		case f.pending != nil || f.active == nil || now.Sub(f.active.lastSeen) < f.timeout:
+			return
+		default:
+			log.Println("Active publisher went silent on stream", f.name)
+			f.startSwitch(pub)
+		}
+	}
+
+	// Switch once the backup reaches a synchronization point
+	init, err := f.demux.FindInit(buf)
+	if err != nil {
+		log.Printf("%s - failover sync failed: %v", f.name, err)
+		init = [][]byte{buf}
+	} else if init == nil {
+		return
+	} else if len(init) == 0 {
+		// unknown transport, switch immediately
+		init = [][]byte{buf}
+	}
+
+	for _, pkt := range init {
+		f.channel.Pub(pkt)
+	}
+	f.promote(pub)
+}
+
+// startSwitch prepares a backup to take over at its next sync point
+// expects the failover mutex to be held
+func (f *failover) startSwitch(pub *publisher) {
+	if pub == f.fallback {
+		log.Println("Switching to fallback on stream", f.name)
+	} else {
+		log.Println("Switching to backup publisher on stream", f.name)
+	}
+	f.pending = pub
+	f.demux = format.NewDemuxer()
+}
+
+// promote makes a backup the active publisher
+// a previously active publisher is kept as backup
+// expects the failover mutex to be held
+func (f *failover) promote(pub *publisher) {
+	f.removeBackup(pub)
+	if f.active != nil && f.active != f.fallback {
+		f.backups = append(f.backups, f.active)
+	}
+	f.active = pub
+	f.pending = nil
+	f.demux = nil
+	if pub == f.fallback {
+		log.Println("Switched to fallback on stream", f.name)
+	} else {
+		log.Println("Switched to backup publisher on stream", f.name)
+	}
+}
+
+// removeBackup removes a single backup publisher
+// expects the failover mutex to be held
+func (f *failover) removeBackup(pub *publisher) {
+	for i := range f.backups {
+		if f.backups[i] == pub {
+			f.backups = append(f.backups[:i], f.backups[i+1:]...)
+			return
+		}
+	}
+}
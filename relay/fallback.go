@@ -0,0 +1,69 @@
+package relay
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// time to wait before restarting a failed fallback source
+const fallbackRetry = time.Second
+
+// Source produces a stream, e.g. a looped file or another channel
+type Source interface {
+	// Run publishes buffers until ctx is done or the source fails
+	Run(ctx context.Context, pub chan<- []byte) error
+}
+
+// Fallback is published to a channel while its publishers are absent
+// The fallback runs as long as the channel exists. It goes on air at its
+// next sync point once the last publisher left or the active publisher went
+// silent, and returning publishers take over again at their next sync point.
+type Fallback struct {
+	Source Source
+
+	// unpublish the channel after the fallback was on air for this long,
+	// 0 keeps the channel forever
+	Timeout time.Duration
+}
+
+// FallbackFunc returns the fallback of a stream, nil if it has none
+type FallbackFunc func(name string) *Fallback
+
+// runFallback feeds a fallback source into the failover until ctx is done
+// Failed sources are restarted and resynchronized.
+func (f *failover) runFallback(ctx context.Context, src Source) {
+	var last string // last error, repeated errors are not logged
+	for {
+		pub := make(chan []byte)
+		done := make(chan error, 1)
+		go func() {
+			done <- src.Run(ctx, pub)
+		}()
+
+	read:
+		for {
+			select {
+			case buf := <-pub:
+				last = ""
+				f.push(f.fallback, buf)
+			case err := <-done:
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil && err.Error() != last {
+					log.Printf("%s - fallback stopped: %v", f.name, err)
+					last = err.Error()
+				}
+				break read
+			}
+		}
+
+		f.resync(f.fallback)
+		select {
+		case <-time.After(fallbackRetry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package relay
+
+// gopCache keeps the packets since the most recent GOP start, so new
+// subscribers can start playback instantly instead of waiting for the next GOP
+type gopCache struct {
+	maxBytes uint
+	packets  [][]byte // packets from the last GOP start up to the live edge
+	size     uint     // total size of cached packets in bytes
+	valid    bool     // packets start at a GOP and fit into maxBytes
+}
+
+func newGOPCache(maxBytes uint) *gopCache {
+	return &gopCache{
+		maxBytes: maxBytes,
+	}
+}
+
+// push adds a published packet to the cache
+// init is the result of Channel.findGOP for the packet.
+func (c *gopCache) push(b []byte, init [][]byte) {
+	// New GOP start, the init data already contains the current packet
+	if init != nil {
+		// unsupported transport or corrupt stream
+		if len(init) == 0 {
+			c.invalidate()
+			return
+		}
+
+		c.packets = make([][]byte, 0, len(init))
+		c.size = 0
+		c.valid = true
+		for _, pkt := range init {
+			c.append(pkt)
+		}
+		return
+	}
+
+	if c.valid {
+		c.append(b)
+	}
+}
+
+func (c *gopCache) append(b []byte) {
+	c.packets = append(c.packets, b)
+	c.size += uint(len(b))
+	if c.size > c.maxBytes {
+		c.invalidate()
+	}
+}
+
+// invalidate drops the cache until the next GOP start
+func (c *gopCache) invalidate() {
+	c.packets = nil
+	c.size = 0
+	c.valid = false
+}
+
+// snapshot returns the currently cached packets
+// The cache only appends or replaces its slice, so the result stays unchanged.
+func (c *gopCache) snapshot() [][]byte {
+	if !c.valid {
+		return nil
+	}
+	return c.packets[:len(c.packets):len(c.packets)]
+}
@@ -0,0 +1,43 @@
+package relay
+
+import "fmt"
+
+// PublisherPolicy decides what happens when publishing to an already published stream
+type PublisherPolicy uint8
+
+const (
+	PolicyDefault PublisherPolicy = iota // use the configured policy
+	PolicyReject                         // reject the new publisher
+	PolicyReplace                        // kick the old publisher and hand the channel to the new one
+	PolicyBackup                         // keep the new publisher as backup
+)
+
+func (p PublisherPolicy) String() string {
+	switch p {
+	case PolicyDefault:
+		return "default"
+	case PolicyReject:
+		return "reject"
+	case PolicyReplace:
+		return "replace"
+	case PolicyBackup:
+		return "backup"
+	default:
+		return "unknown"
+	}
+}
+
+// UnmarshalText parses a policy from its name
+func (p *PublisherPolicy) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "reject":
+		*p = PolicyReject
+	case "replace":
+		*p = PolicyReplace
+	case "backup":
+		*p = PolicyBackup
+	default:
+		return fmt.Errorf("unknown publisher policy '%s'", b)
+	}
+	return nil
+}
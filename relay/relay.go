@@ -1,62 +1,210 @@
 package relay
 
 import (
+	"context"
 	"errors"
 	"log"
+	"slices"
 	"sync"
 	"time"
+
+	"github.com/voc/srtrelay/dash"
+	"github.com/voc/srtrelay/hls"
+	"github.com/voc/srtrelay/mpegts"
 )
 
 var (
-	ErrStreamAlreadyExists = errors.New("stream already exists")
-	ErrStreamNotExisting   = errors.New("stream does not exist")
+	ErrStreamAlreadyExists  = errors.New("stream already exists")
+	ErrStreamNotExisting    = errors.New("stream does not exist")
+	ErrAnalyzerDisabled     = errors.New("stream analyzer disabled")
+	ErrSegmentNotFound      = errors.New("segment not found")
+	ErrHLSTimeout           = errors.New("hls request timed out")
+	ErrTimeshiftUnavailable = errors.New("timeshift not available")
 )
 
 type RelayConfig struct {
 	BufferSize uint
 	PacketSize uint
+
+	// time to keep a channel and its subscribers after the publisher left
+	PublisherGracePeriod time.Duration
+
+	// what happens when publishing to an already published stream
+	PublisherPolicy PublisherPolicy
+
+	// time after which a silent publisher is replaced by a backup, 0 uses
+	// the default of 1s
+	FailoverTimeout time.Duration
+
+	// max size of the GOP replayed to new subscribers in bytes, 0 disables
+	GOPCacheSize uint
+
+	// check published streams for TR 101 290 errors
+	AnalyzeStreams bool
+
+	// minimum duration of HLS segments, 0 disables HLS
+	HLSSegmentDuration time.Duration
+
+	// maximum duration of LL-HLS parts, 0 disables parts
+	HLSPartDuration time.Duration
+
+	// number of segments in the HLS playlist
+	HLSWindowSize uint
+
+	// minimum duration of MPEG-DASH segments, 0 disables MPEG-DASH
+	DASHSegmentDuration time.Duration
+
+	// number of segments in the MPEG-DASH manifest
+	DASHWindowSize uint
+
+	// time window subscribers can start playback in the past, 0 disables
+	TimeshiftWindow time.Duration
+
+	// max size of the timeshift buffer per channel in bytes, 0 disables
+	TimeshiftSize uint
 }
 
 type Relay interface {
-	Publish(string) (chan<- []byte, error)
-	Subscribe(string) (<-chan []byte, UnsubscribeFunc, error)
+	Publish(string, PublisherPolicy) (chan<- []byte, <-chan struct{}, error)
+	Subscribe(string) (*Subscriber, UnsubscribeFunc, error)
+	SubscribeAt(name string, offset time.Duration) (*Subscriber, UnsubscribeFunc, error)
 	GetStatistics() []*StreamStatistics
+	GetHealth(name string) (*mpegts.Health, error)
+	GetPlaylist(ctx context.Context, name string, until *hls.Position) (*hls.Playlist, error)
+	GetSegment(ctx context.Context, name string, pos hls.Position) ([]byte, error)
+	GetManifest(name string) (*dash.Manifest, error)
+	GetFragment(name string, file dash.File) ([]byte, error)
 	ChannelExists(name string) bool
+	CanPublish(name string, policy PublisherPolicy) bool
+	OnChannel(fn func(name string))
+	OnClose(fn func(name string))
+	SetFallbacks(fn FallbackFunc)
 }
 
 type StreamStatistics struct {
-	Name    string    `json:"name"`
-	URL     string    `json:"url"`
-	Clients int       `json:"clients"`
-	Created time.Time `json:"created"`
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	Clients int               `json:"clients"`
+	Created time.Time         `json:"created"`
+	Media   *mpegts.MediaInfo `json:"media,omitempty"`
 }
 
 // RelayImpl represents a multi-channel stream relay
 type RelayImpl struct {
-	mutex    sync.Mutex
-	channels map[string]*Channel
-	config   *RelayConfig
+	mutex     sync.Mutex
+	channels  map[string]*Channel
+	failovers map[string]*failover
+	parked    map[string]*time.Timer // channels without publisher waiting for a reconnect
+	observers []func(name string)    // notified about new channels
+	closers   []func(name string)    // notified about closed channels
+	fallbacks FallbackFunc           // nil without fallbacks
+	config    *RelayConfig
 }
 
 // NewRelay creates a relay
 func NewRelay(config *RelayConfig) Relay {
 	return &RelayImpl{
-		channels: make(map[string]*Channel),
-		config:   config,
+		channels:  make(map[string]*Channel),
+		failovers: make(map[string]*failover),
+		parked:    make(map[string]*time.Timer),
+		config:    config,
 	}
 }
 
-// Publish claims a stream name for publishing
-func (s *RelayImpl) Publish(name string) (chan<- []byte, error) {
+// OnChannel registers a function called with the name of every new channel
+// The function is called before the publisher can send its first packet, so
+// it may subscribe without missing data.
+func (s *RelayImpl) OnChannel(fn func(name string)) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	s.observers = append(s.observers, fn)
+}
 
-	if _, exists := s.channels[name]; exists {
-		return nil, ErrStreamAlreadyExists
+// OnClose registers a function called with the name of every closed channel
+// The function is called after the channel was removed, e.g. to release
+// per-stream state.
+func (s *RelayImpl) OnClose(fn func(name string)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closers = append(s.closers, fn)
+}
+
+// SetFallbacks sets the function choosing the fallback of new channels
+func (s *RelayImpl) SetFallbacks(fn FallbackFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fallbacks = fn
+}
+
+// Publish claims a stream name for publishing
+// A parked channel still waiting for its publisher to return is taken over
+// including all subscribers. Publishing to an existing stream is handled
+// according to the publisher policy.
+// The returned kicked channel is closed when the publisher was replaced.
+func (s *RelayImpl) Publish(name string, policy PublisherPolicy) (chan<- []byte, <-chan struct{}, error) {
+	ch, kicked, observers, err := s.publish(name, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, fn := range observers {
+		fn(name)
 	}
+	return ch, kicked, nil
+}
 
-	channel := NewChannel(name, s.config.BufferSize/s.config.PacketSize)
-	s.channels[name] = channel
+// publish sets up the publisher and returns the observers to notify if a
+// new channel was created
+func (s *RelayImpl) publish(name string, policy PublisherPolicy) (chan<- []byte, <-chan struct{}, []func(string), error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var observers []func(string)
+
+	policy = s.policy(policy)
+	channel, exists := s.channels[name]
+	if timer, parked := s.parked[name]; parked {
+		timer.Stop()
+		delete(s.parked, name)
+		log.Println("Resumed stream", name)
+	} else if exists {
+		// publishers may always take over from the fallback
+		if !s.failovers[name].vacant() && policy != PolicyReplace && policy != PolicyBackup {
+			return nil, nil, nil, ErrStreamAlreadyExists
+		}
+	} else {
+		channel = NewChannel(name, s.config.BufferSize/s.config.PacketSize)
+		if s.config.GOPCacheSize > 0 {
+			channel.WithGOPCache(s.config.GOPCacheSize)
+		}
+		if s.config.AnalyzeStreams {
+			channel.WithAnalyzer()
+		}
+		if s.config.HLSSegmentDuration > 0 {
+			channel.WithSegmenter(s.config.HLSSegmentDuration, s.config.HLSPartDuration, s.config.HLSWindowSize)
+		}
+		if s.config.DASHSegmentDuration > 0 {
+			channel.WithPackager(s.config.DASHSegmentDuration, s.config.DASHWindowSize)
+		}
+		if s.config.TimeshiftWindow > 0 && s.config.TimeshiftSize > 0 {
+			channel.WithTimeshift(s.config.TimeshiftWindow, s.config.TimeshiftSize)
+		}
+		s.channels[name] = channel
+		f := newFailover(name, channel, s.config.FailoverTimeout)
+		if s.fallbacks != nil {
+			if fallback := s.fallbacks(name); fallback != nil {
+				f.withFallback(fallback)
+			}
+		}
+		s.failovers[name] = f
+		observers = slices.Clone(s.observers)
+	}
+	f := s.failovers[name]
+	var pub *publisher
+	if policy == PolicyReplace {
+		pub = f.replace()
+	} else {
+		pub = f.join()
+	}
 
 	ch := make(chan []byte)
 
@@ -67,32 +215,118 @@ func (s *RelayImpl) Publish(name string) (chan<- []byte, error) {
 
 			// Channel closed, Teardown pubsub
 			if !ok {
-				// Need a lock on the map first to stop new subscribers
-				s.mutex.Lock()
-				log.Println("Unpublished stream", name)
-				delete(s.channels, name)
-				channel.Close()
-				s.mutex.Unlock()
+				s.unpublish(name, channel, f, pub)
 				return
 			}
 
 			// Publish buf to subscribers
-			channel.Pub(buf)
+			f.push(pub, buf)
 		}
 	}()
-	return ch, nil
+	return ch, pub.kicked, observers, nil
+}
+
+// policy resolves the default publisher policy
+func (s *RelayImpl) policy(policy PublisherPolicy) PublisherPolicy {
+	if policy == PolicyDefault {
+		policy = s.config.PublisherPolicy
+	}
+	if policy == PolicyDefault {
+		policy = PolicyReject
+	}
+	return policy
+}
+
+// unpublish tears down a channel after its last publisher left
+// If a grace period is configured the channel is parked first, so subscribers
+// stay attached until a new publisher takes over or the grace period ends.
+// Channels with fallback are parked for the fallback timeout instead.
+func (s *RelayImpl) unpublish(name string, channel *Channel, f *failover, pub *publisher) {
+	for _, fn := range s.leave(name, channel, f, pub) {
+		fn(name)
+	}
+}
+
+// leave removes a publisher and returns the closers to notify if the channel
+// was closed
+func (s *RelayImpl) leave(name string, channel *Channel, f *failover, pub *publisher) []func(string) {
+	// Need a lock on the map first to stop new subscribers
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// backup publishers remain
+	if !f.leave(pub) {
+		return nil
+	}
+
+	period := s.config.PublisherGracePeriod
+	if f.fallback != nil {
+		if f.lifetime <= 0 {
+			log.Printf("Publisher left stream %s, fallback on air\n", name)
+			return nil
+		}
+		period = f.lifetime
+	}
+
+	if period <= 0 {
+		return s.close(name, channel, f)
+	}
+
+	log.Printf("Publisher left stream %s, waiting %s for reconnect\n", name, period)
+	var timer *time.Timer
+	timer = time.AfterFunc(period, func() {
+		s.mutex.Lock()
+		// channel was taken over in the meantime
+		if s.parked[name] != timer {
+			s.mutex.Unlock()
+			return
+		}
+		delete(s.parked, name)
+		closers := s.close(name, channel, f)
+		s.mutex.Unlock()
+
+		for _, fn := range closers {
+			fn(name)
+		}
+	})
+	s.parked[name] = timer
+	return nil
+}
+
+// close removes a channel and returns the closers to notify
+// expects the relay mutex to be held
+func (s *RelayImpl) close(name string, channel *Channel, f *failover) []func(string) {
+	log.Println("Unpublished stream", name)
+	delete(s.channels, name)
+	delete(s.failovers, name)
+	f.close()
+	channel.Close()
+	return slices.Clone(s.closers)
 }
 
 // Subscribe subscribes to a stream by name
-func (s *RelayImpl) Subscribe(name string) (<-chan []byte, UnsubscribeFunc, error) {
+func (s *RelayImpl) Subscribe(name string) (*Subscriber, UnsubscribeFunc, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	channel, ok := s.channels[name]
 	if !ok {
 		return nil, nil, ErrStreamNotExisting
 	}
-	ch, unsub := channel.Sub()
-	return ch, unsub, nil
+	sub, unsub := channel.Sub()
+	return sub, unsub, nil
+}
+
+// SubscribeAt subscribes to a stream starting offset in the past
+// Returns ErrTimeshiftUnavailable if timeshift is disabled or no keyframe was
+// buffered yet.
+func (s *RelayImpl) SubscribeAt(name string, offset time.Duration) (*Subscriber, UnsubscribeFunc, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	channel, ok := s.channels[name]
+	if !ok {
+		return nil, nil, ErrStreamNotExisting
+	}
+	return channel.SubAt(offset)
 }
 
 func (s *RelayImpl) GetStatistics() []*StreamStatistics {
@@ -107,14 +341,92 @@ func (s *RelayImpl) GetStatistics() []*StreamStatistics {
 			Name:    name,
 			Clients: stats.clients,
 			Created: stats.created,
+			Media:   stats.media,
 		})
 	}
 	return statistics
 }
 
+// GetHealth returns the analyzer results of a stream
+func (s *RelayImpl) GetHealth(name string) (*mpegts.Health, error) {
+	channel, err := s.channel(name)
+	if err != nil {
+		return nil, err
+	}
+
+	health := channel.Health()
+	if health == nil {
+		return nil, ErrAnalyzerDisabled
+	}
+	return health, nil
+}
+
+// GetPlaylist returns the HLS playlist of a stream
+// If until is set, blocks until the playlist contains the position or
+// returns ErrHLSTimeout. Returns ErrSegmentNotFound if HLS is disabled
+// or no segment is available yet.
+func (s *RelayImpl) GetPlaylist(ctx context.Context, name string, until *hls.Position) (*hls.Playlist, error) {
+	channel, err := s.channel(name)
+	if err != nil {
+		return nil, err
+	}
+	return channel.Playlist(ctx, until)
+}
+
+// GetSegment returns a HLS segment or part of a stream
+// Requests for the next part block until it is finished.
+func (s *RelayImpl) GetSegment(ctx context.Context, name string, pos hls.Position) ([]byte, error) {
+	channel, err := s.channel(name)
+	if err != nil {
+		return nil, err
+	}
+	return channel.Segment(ctx, pos)
+}
+
+// GetManifest returns the MPEG-DASH manifest of a stream
+// Returns ErrSegmentNotFound if MPEG-DASH is disabled or no segment is
+// available yet.
+func (s *RelayImpl) GetManifest(name string) (*dash.Manifest, error) {
+	channel, err := s.channel(name)
+	if err != nil {
+		return nil, err
+	}
+	return channel.Manifest()
+}
+
+// GetFragment returns a MPEG-DASH init or media segment of a stream
+func (s *RelayImpl) GetFragment(name string, file dash.File) ([]byte, error) {
+	channel, err := s.channel(name)
+	if err != nil {
+		return nil, err
+	}
+	return channel.Fragment(file)
+}
+
+// channel returns a channel by name
+func (s *RelayImpl) channel(name string) (*Channel, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	channel, ok := s.channels[name]
+	if !ok {
+		return nil, ErrStreamNotExisting
+	}
+	return channel, nil
+}
+
 func (s *RelayImpl) ChannelExists(name string) bool {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	_, exists := s.channels[name]
 	return exists
 }
+
+// CanPublish checks whether a new publisher would be accepted for a stream
+func (s *RelayImpl) CanPublish(name string, policy PublisherPolicy) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, exists := s.channels[name]
+	_, parked := s.parked[name]
+	policy = s.policy(policy)
+	return !exists || parked || s.failovers[name].vacant() || policy == PolicyReplace || policy == PolicyBackup
+}
@@ -1,9 +1,16 @@
 package relay
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/voc/srtrelay/dash"
+	"github.com/voc/srtrelay/hls"
 )
 
 func TestRelayImpl_SubscribeAndUnsubscribe(t *testing.T) {
@@ -11,7 +18,7 @@ func TestRelayImpl_SubscribeAndUnsubscribe(t *testing.T) {
 	relay := NewRelay(&config)
 	data := []byte{1, 2, 3, 4}
 
-	pub, err := relay.Publish("test")
+	pub, _, err := relay.Publish("test", PolicyDefault)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -25,7 +32,7 @@ func TestRelayImpl_SubscribeAndUnsubscribe(t *testing.T) {
 	pub <- data
 
 	// receive
-	got, ok := <-sub
+	got, ok := sub.Read()
 	if !ok {
 		t.Fatal("Subscriber channel should not be closed")
 	}
@@ -38,7 +45,7 @@ func TestRelayImpl_SubscribeAndUnsubscribe(t *testing.T) {
 
 	// 2nd send
 	pub <- data
-	got, ok = <-sub
+	got, ok = sub.Read()
 
 	if got != nil || ok {
 		t.Errorf("Read after unsub ret %x, want nil", got)
@@ -49,37 +56,365 @@ func TestRelayImpl_PublisherClose(t *testing.T) {
 	config := RelayConfig{BufferSize: 1, PacketSize: 1}
 	relay := NewRelay(&config)
 
-	ch, _ := relay.Publish("test")
+	ch, _, _ := relay.Publish("test", PolicyDefault)
 	sub, unsub, _ := relay.Subscribe("test")
 	close(ch)
 
 	// Wait for async teardown in goroutine
 	time.Sleep(100 * time.Millisecond)
 
-	if _, ok := <-sub; ok {
-		t.Error("Subscriber channel should be closed")
+	if _, ok := sub.Read(); ok {
+		t.Error("Subscriber should be closed")
 	}
 
 	// unsub after close shouldn't break
 	unsub()
 
-	_, err := relay.Publish("test")
+	_, _, err := relay.Publish("test", PolicyDefault)
 	if err != nil {
 		t.Error("Publish should be possible again after close")
 	}
 }
 
+func TestRelayImpl_PublisherGracePeriod(t *testing.T) {
+	config := RelayConfig{BufferSize: 50, PacketSize: 1, PublisherGracePeriod: 100 * time.Millisecond}
+	relay := NewRelay(&config)
+	data := []byte{1, 2, 3, 4}
+
+	ch, _, _ := relay.Publish("test", PolicyDefault)
+	sub, _, _ := relay.Subscribe("test")
+	close(ch)
+
+	// Wait for async teardown in goroutine
+	time.Sleep(20 * time.Millisecond)
+
+	if !relay.ChannelExists("test") {
+		t.Fatal("Channel should exist during grace period")
+	}
+	if !relay.CanPublish("test", PolicyDefault) {
+		t.Fatal("Channel should accept a publisher during grace period")
+	}
+
+	// Takeover by new publisher
+	ch, _, err := relay.Publish("test", PolicyDefault)
+	if err != nil {
+		t.Fatal("Publish should be possible during grace period", err)
+	}
+	ch <- data
+	if got, ok := sub.Read(); !ok || !reflect.DeepEqual(got, data) {
+		t.Errorf("Read after takeover ret %x, want %x", got, data)
+	}
+
+	// Teardown after grace period
+	close(ch)
+	time.Sleep(200 * time.Millisecond)
+
+	if relay.ChannelExists("test") {
+		t.Error("Channel should not exist after grace period")
+	}
+	if _, ok := sub.Read(); ok {
+		t.Error("Subscriber should be closed after grace period")
+	}
+}
+
+func TestRelayImpl_BackupPublisher(t *testing.T) {
+	config := RelayConfig{BufferSize: 50, PacketSize: 1, PublisherPolicy: PolicyBackup, FailoverTimeout: 50 * time.Millisecond}
+	relay := NewRelay(&config)
+
+	primary, _, _ := relay.Publish("test", PolicyDefault)
+	backup, _, err := relay.Publish("test", PolicyDefault)
+	if err != nil {
+		t.Fatal("Backup publish should be accepted", err)
+	}
+	sub, _, _ := relay.Subscribe("test")
+
+	// Backup data is discarded while primary is active
+	backup <- []byte{2}
+	primary <- []byte{1}
+	if got, _ := sub.Read(); got[0] != 1 {
+		t.Errorf("Read ret %x, want primary data", got)
+	}
+
+	// Backup takes over when primary leaves
+	close(primary)
+	time.Sleep(20 * time.Millisecond)
+	backup <- []byte{2}
+	if got, _ := sub.Read(); got[0] != 2 {
+		t.Errorf("Read ret %x, want backup data", got)
+	}
+
+	// New backup takes over when active publisher is silent
+	backup2, _, _ := relay.Publish("test", PolicyDefault)
+	backup2 <- []byte{3}
+	time.Sleep(60 * time.Millisecond)
+	backup2 <- []byte{3}
+	if got, _ := sub.Read(); got[0] != 3 {
+		t.Errorf("Read ret %x, want second backup data", got)
+	}
+
+	// Previously active publisher is now a backup
+	backup <- []byte{2}
+	backup2 <- []byte{3}
+	if got, _ := sub.Read(); got[0] != 3 {
+		t.Errorf("Read ret %x, want second backup data", got)
+	}
+
+	close(backup)
+	close(backup2)
+	time.Sleep(20 * time.Millisecond)
+	if relay.ChannelExists("test") {
+		t.Error("Channel should not exist after all publishers left")
+	}
+}
+
+func TestRelayImpl_BackupPublisherDefaultTimeout(t *testing.T) {
+	config := RelayConfig{BufferSize: 50, PacketSize: 1, PublisherPolicy: PolicyBackup}
+	relay := NewRelay(&config)
+
+	primary, _, _ := relay.Publish("test", PolicyDefault)
+	defer close(primary)
+	backup, _, _ := relay.Publish("test", PolicyDefault)
+	defer close(backup)
+	sub, _, _ := relay.Subscribe("test")
+
+	// without a timeout the backup must not take over from an active primary
+	for i := 0; i < 5; i++ {
+		primary <- []byte{1}
+		backup <- []byte{2}
+	}
+	primary <- []byte{1}
+	for i := 0; i < 6; i++ {
+		if got, _ := sub.Read(); got[0] != 1 {
+			t.Fatalf("Read ret %x, want primary data", got)
+		}
+	}
+}
+
+func TestRelayImpl_BackupPublisherSync(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := RelayConfig{BufferSize: 1316 * 100, PacketSize: 1316, PublisherPolicy: PolicyBackup}
+	relay := NewRelay(&config)
+
+	primary, _, _ := relay.Publish("test", PolicyDefault)
+	backup, _, _ := relay.Publish("test", PolicyDefault)
+	sub, _, _ := relay.Subscribe("test")
+	close(primary)
+	time.Sleep(20 * time.Millisecond)
+
+	// Start backup mid-GOP
+	for offset := 10 * 188; offset+1316 <= len(data); offset += 1316 {
+		backup <- data[offset : offset+1316]
+	}
+
+	// Switch should happen at a sync point, starting with the PAT
+	got, ok := sub.Read()
+	if !ok {
+		t.Fatal("Subscriber should receive backup data")
+	}
+	if pid := uint16(got[1]&0x1f)<<8 | uint16(got[2]); pid != 0 {
+		t.Errorf("First packet after switch has PID %d, want PAT", pid)
+	}
+	close(backup)
+}
+
+// testSource publishes a single byte periodically
+type testSource byte
+
+func (b testSource) Run(ctx context.Context, pub chan<- []byte) error {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pub <- []byte{byte(b)}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// readUntil reads from a subscriber until it receives b
+func readUntil(t *testing.T, sub *Subscriber, b byte) {
+	t.Helper()
+	for range 100 {
+		got, ok := sub.Read()
+		if !ok {
+			t.Fatalf("Subscriber ended waiting for %x", b)
+		}
+		if got[0] == b {
+			return
+		}
+	}
+	t.Fatalf("Did not receive %x", b)
+}
+
+func TestRelayImpl_Fallback(t *testing.T) {
+	config := RelayConfig{BufferSize: 50, PacketSize: 1, FailoverTimeout: 50 * time.Millisecond}
+	relay := NewRelay(&config)
+	relay.SetFallbacks(func(name string) *Fallback {
+		if name != "test" {
+			return nil
+		}
+		return &Fallback{Source: testSource(9)}
+	})
+
+	primary, _, _ := relay.Publish("test", PolicyDefault)
+	sub, _, _ := relay.Subscribe("test")
+
+	// Fallback data is discarded while the publisher is active
+	primary <- []byte{1}
+	primary <- []byte{1}
+	if got, _ := sub.Read(); got[0] != 1 {
+		t.Errorf("Read ret %x, want primary data", got)
+	}
+
+	// Fallback takes over when the publisher leaves, the stream stays
+	close(primary)
+	readUntil(t, sub, 9)
+	if !relay.CanPublish("test", PolicyDefault) {
+		t.Error("Publisher should be allowed to take over from the fallback")
+	}
+
+	// Returning publisher takes over again
+	primary, _, err := relay.Publish("test", PolicyDefault)
+	if err != nil {
+		t.Fatal("Publisher should take over from the fallback", err)
+	}
+	primary <- []byte{2}
+	readUntil(t, sub, 2)
+	primary <- []byte{3}
+	if got, _ := sub.Read(); got[0] != 3 {
+		t.Errorf("Read ret %x, want primary data", got)
+	}
+
+	// Fallback takes over when the publisher is silent
+	readUntil(t, sub, 9)
+	primary <- []byte{4}
+	readUntil(t, sub, 4)
+
+	close(primary)
+	readUntil(t, sub, 9)
+	if !relay.ChannelExists("test") {
+		t.Error("Channel with fallback should not be unpublished")
+	}
+}
+
+func TestRelayImpl_FallbackTimeout(t *testing.T) {
+	config := RelayConfig{BufferSize: 50, PacketSize: 1}
+	relay := NewRelay(&config)
+	relay.SetFallbacks(func(name string) *Fallback {
+		return &Fallback{Source: testSource(9), Timeout: 50 * time.Millisecond}
+	})
+
+	primary, _, _ := relay.Publish("test", PolicyDefault)
+	sub, _, _ := relay.Subscribe("test")
+	close(primary)
+	readUntil(t, sub, 9)
+
+	time.Sleep(100 * time.Millisecond)
+	if relay.ChannelExists("test") {
+		t.Error("Channel should be unpublished after the fallback timeout")
+	}
+}
+
 func TestRelayImpl_DoublePublish(t *testing.T) {
 	config := RelayConfig{BufferSize: 1, PacketSize: 1}
 	relay := NewRelay(&config)
-	relay.Publish("foo")
-	_, err := relay.Publish("foo")
+	relay.Publish("foo", PolicyDefault)
+	_, _, err := relay.Publish("foo", PolicyDefault)
 
 	if err != ErrStreamAlreadyExists {
 		t.Errorf("Publish to existing stream should return '%s', got '%s'", ErrStreamAlreadyExists, err)
 	}
 }
 
+func TestRelayImpl_ReplacePublisher(t *testing.T) {
+	config := RelayConfig{BufferSize: 50, PacketSize: 1}
+	relay := NewRelay(&config)
+
+	old, kicked, _ := relay.Publish("test", PolicyDefault)
+	sub, _, _ := relay.Subscribe("test")
+	if !relay.CanPublish("test", PolicyReplace) {
+		t.Fatal("Replace should be possible")
+	}
+	pub, _, err := relay.Publish("test", PolicyReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-kicked:
+	default:
+		t.Error("Old publisher should be kicked")
+	}
+
+	// Old publisher data is discarded and leaving doesn't close the channel
+	old <- []byte{1}
+	close(old)
+	pub <- []byte{2}
+	if got, ok := sub.Read(); !ok || got[0] != 2 {
+		t.Errorf("Read ret %x, want new publisher data", got)
+	}
+	if !relay.ChannelExists("test") {
+		t.Error("Channel should exist after old publisher left")
+	}
+}
+
+func TestRelayImpl_OnChannel(t *testing.T) {
+	config := RelayConfig{BufferSize: 50, PacketSize: 1, PublisherPolicy: PolicyBackup}
+	relay := NewRelay(&config)
+
+	var created []string
+	var sub *Subscriber
+	relay.OnChannel(func(name string) {
+		created = append(created, name)
+		sub, _, _ = relay.Subscribe(name)
+	})
+
+	pub, _, _ := relay.Publish("test", PolicyDefault)
+	relay.Publish("test", PolicyDefault)
+	if len(created) != 1 || created[0] != "test" {
+		t.Fatalf("Observer should be called once for a new channel, got %v", created)
+	}
+
+	// the observer subscribed before the first packet
+	pub <- []byte{1}
+	if got, ok := sub.Read(); !ok || got[0] != 1 {
+		t.Errorf("Read ret %x, want first packet", got)
+	}
+}
+
+func TestRelayImpl_OnClose(t *testing.T) {
+	config := RelayConfig{BufferSize: 50, PacketSize: 1, PublisherGracePeriod: 10 * time.Millisecond}
+	relay := NewRelay(&config)
+
+	closed := make(chan string, 2)
+	relay.OnClose(func(name string) {
+		// called without the relay lock held
+		if relay.ChannelExists(name) {
+			t.Errorf("Channel %s should not exist when closed", name)
+		}
+		closed <- name
+	})
+
+	pub, _, _ := relay.Publish("test", PolicyDefault)
+	close(pub)
+	select {
+	case name := <-closed:
+		if name != "test" {
+			t.Errorf("Got closed channel %s, expected test", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Closer was not called after the grace period")
+	}
+	if len(closed) != 0 {
+		t.Error("Closer should be called once")
+	}
+}
+
 func TestRelayImpl_SubscribeNonExisting(t *testing.T) {
 	config := RelayConfig{BufferSize: 1, PacketSize: 1}
 	relay := NewRelay(&config)
@@ -99,7 +434,7 @@ func TestRelayImpl_ChannelExists(t *testing.T) {
 		t.Fatal("Channel should not exist before publishing")
 	}
 
-	_, err := relay.Publish("test")
+	_, _, err := relay.Publish("test", PolicyDefault)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -108,3 +443,260 @@ func TestRelayImpl_ChannelExists(t *testing.T) {
 		t.Fatal("Channel should exist after publishing")
 	}
 }
+
+// BenchmarkRelayImpl_FanOut measures the cost of distributing packets
+// from a single publisher to many subscribers
+func BenchmarkRelayImpl_FanOut(b *testing.B) {
+	for _, numSubs := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("%dSubscribers", numSubs), func(b *testing.B) {
+			// buffer all packets, so slow subscribers are never dropped
+			config := RelayConfig{BufferSize: uint(b.N) * 1316, PacketSize: 1316}
+			relay := NewRelay(&config)
+			pub, _, err := relay.Publish("bench", PolicyDefault)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < numSubs; i++ {
+				sub, _, err := relay.Subscribe("bench")
+				if err != nil {
+					b.Fatal(err)
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						if _, ok := sub.Read(); !ok {
+							return
+						}
+					}
+				}()
+			}
+
+			data := make([]byte, 1316)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pub <- data
+			}
+			close(pub)
+			wg.Wait()
+		})
+	}
+}
+
+func TestRelayImpl_GetHealth(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		analyze  bool
+		publish  bool
+		expected error
+	}{
+		{"NotExisting", true, false, ErrStreamNotExisting},
+		{"Disabled", false, true, ErrAnalyzerDisabled},
+		{"Enabled", true, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := RelayConfig{BufferSize: 1316 * 100, PacketSize: 1316, AnalyzeStreams: tt.analyze}
+			relay := NewRelay(&config)
+			if tt.publish {
+				pub, _, _ := relay.Publish("test", PolicyDefault)
+				defer close(pub)
+				sub, _, _ := relay.Subscribe("test")
+				for offset := 0; offset+1316 <= len(data); offset += 1316 {
+					pub <- data[offset : offset+1316]
+					sub.Read()
+				}
+			}
+
+			health, err := relay.GetHealth("test")
+			if err != tt.expected {
+				t.Fatalf("Got error %v, expected %v", err, tt.expected)
+			}
+			if err == nil && (!health.Synced || len(health.PIDs) == 0) {
+				t.Errorf("Expected synced stream with PIDs, got %+v", health)
+			}
+		})
+	}
+}
+
+func TestRelayImpl_GetPlaylist(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		duration time.Duration
+		publish  bool
+		expected error
+	}{
+		{"NotExisting", time.Second, false, ErrStreamNotExisting},
+		{"Disabled", 0, true, ErrSegmentNotFound},
+		{"Enabled", time.Second, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := RelayConfig{BufferSize: 1316 * 100, PacketSize: 1316, HLSSegmentDuration: tt.duration, HLSWindowSize: 3}
+			relay := NewRelay(&config)
+			if tt.publish {
+				pub, _, _ := relay.Publish("test", PolicyDefault)
+				defer close(pub)
+				sub, _, _ := relay.Subscribe("test")
+				for offset := 0; offset+1316 <= len(data); offset += 1316 {
+					pub <- data[offset : offset+1316]
+					sub.Read()
+				}
+			}
+
+			playlist, err := relay.GetPlaylist(context.Background(), "test", nil)
+			if err != tt.expected {
+				t.Fatalf("Got error %v, expected %v", err, tt.expected)
+			}
+			if err != nil {
+				return
+			}
+			if len(playlist.Segments) != 2 {
+				t.Fatalf("Expected 2 segments, got %+v", playlist.Segments)
+			}
+			data, err := relay.GetSegment(context.Background(), "test", hls.Position{Sequence: 1, Part: -1})
+			if err != nil || len(data) == 0 {
+				t.Errorf("Expected segment data, got %v", err)
+			}
+			if _, err := relay.GetSegment(context.Background(), "test", hls.Position{Sequence: 2, Part: -1}); err != ErrSegmentNotFound {
+				t.Errorf("Got error %v, expected %v", err, ErrSegmentNotFound)
+			}
+		})
+	}
+}
+
+func TestRelayImpl_GetManifest(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		duration time.Duration
+		publish  bool
+		expected error
+	}{
+		{"NotExisting", time.Second, false, ErrStreamNotExisting},
+		{"Disabled", 0, true, ErrSegmentNotFound},
+		{"Enabled", time.Second, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := RelayConfig{BufferSize: 1316 * 100, PacketSize: 1316, DASHSegmentDuration: tt.duration, DASHWindowSize: 3}
+			relay := NewRelay(&config)
+			if tt.publish {
+				pub, _, _ := relay.Publish("test", PolicyDefault)
+				defer close(pub)
+				sub, _, _ := relay.Subscribe("test")
+				for offset := 0; offset+1316 <= len(data); offset += 1316 {
+					pub <- data[offset : offset+1316]
+					sub.Read()
+				}
+			}
+
+			manifest, err := relay.GetManifest("test")
+			if err != tt.expected {
+				t.Fatalf("Got error %v, expected %v", err, tt.expected)
+			}
+			if err != nil {
+				return
+			}
+			if len(manifest.Representations) != 1 || len(manifest.Representations[0].Segments) != 2 {
+				t.Fatalf("Expected 2 video segments, got %+v", manifest.Representations)
+			}
+			for _, file := range []dash.File{{Representation: dash.RepresentationVideo, Init: true}, {Representation: dash.RepresentationVideo, Number: 1}} {
+				data, err := relay.GetFragment("test", file)
+				if err != nil || len(data) == 0 {
+					t.Errorf("Expected data for %+v, got %v", file, err)
+				}
+			}
+			if _, err := relay.GetFragment("test", dash.File{Representation: dash.RepresentationAudio, Init: true}); err != ErrSegmentNotFound {
+				t.Errorf("Got error %v, expected %v", err, ErrSegmentNotFound)
+			}
+		})
+	}
+}
+
+func TestRelayImpl_GetPlaylistBlocking(t *testing.T) {
+	data, err := os.ReadFile("../mpegts/h264_long.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := RelayConfig{
+		BufferSize:         1316 * 100,
+		PacketSize:         1316,
+		HLSSegmentDuration: time.Second,
+		HLSPartDuration:    200 * time.Millisecond,
+		HLSWindowSize:      3,
+	}
+	relay := NewRelay(&config)
+	pub, _, _ := relay.Publish("test", PolicyDefault)
+	defer close(pub)
+
+	request := func(pos hls.Position) <-chan *hls.Playlist {
+		result := make(chan *hls.Playlist, 1)
+		go func() {
+			playlist, err := relay.GetPlaylist(context.Background(), "test", &pos)
+			if err != nil {
+				t.Error(err)
+			}
+			result <- playlist
+		}()
+		return result
+	}
+	// feed publishes packets until the playlist has the number of segments
+	offset := 0
+	feed := func(segments int) {
+		for ; offset+1316 <= len(data); offset += 1316 {
+			pub <- data[offset : offset+1316]
+			playlist, err := relay.GetPlaylist(context.Background(), "test", nil)
+			if err == nil && len(playlist.Segments) >= segments {
+				offset += 1316
+				return
+			}
+		}
+	}
+
+	// the first segment is finished after the second keyframe
+	first := request(hls.Position{Sequence: 0, Part: -1})
+	feed(1)
+	if playlist := <-first; playlist == nil || len(playlist.Segments) != 1 {
+		t.Fatalf("Expected one segment, got %+v", playlist)
+	}
+
+	// the request blocks until the next segment is finished
+	second := request(hls.Position{Sequence: 1, Part: -1})
+	select {
+	case playlist := <-second:
+		t.Fatalf("Expected request to block, got %+v", playlist)
+	case <-time.After(20 * time.Millisecond):
+	}
+	for ; offset+1316 <= len(data); offset += 1316 {
+		pub <- data[offset : offset+1316]
+	}
+	if playlist := <-second; playlist == nil || len(playlist.Segments) < 2 {
+		t.Fatalf("Expected two segments, got %+v", playlist)
+	}
+
+	// positions too far ahead are rejected
+	if _, err := relay.GetPlaylist(context.Background(), "test", &hls.Position{Sequence: 5, Part: -1}); err != hls.ErrInvalidPosition {
+		t.Errorf("Got error %v, expected %v", err, hls.ErrInvalidPosition)
+	}
+
+	// the stream stalls, blocking requests time out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := relay.GetPlaylist(ctx, "test", &hls.Position{Sequence: 3, Part: 0}); err != ErrHLSTimeout {
+		t.Errorf("Got error %v, expected %v", err, ErrHLSTimeout)
+	}
+}
@@ -0,0 +1,119 @@
+package relay
+
+import (
+	"time"
+)
+
+// timeshiftBuffer keeps the packets published within a time window, so
+// subscribers can start playback in the past
+// Playback starts at keyframes, the buffer always begins at the newest
+// keyframe older than the window. If the packets exceed maxBytes, the window
+// is shortened by dropping the oldest keyframes.
+type timeshiftBuffer struct {
+	window    time.Duration
+	maxBytes  uint64
+	size      uint64 // bytes of the buffered packets
+	packets   []timedPacket
+	start     uint64 // absolute position of the first buffered packet
+	keyframes []keyframe
+}
+
+type timedPacket struct {
+	data []byte
+	time time.Time // time the packet was published
+}
+
+// keyframe is a position playback can start at
+type keyframe struct {
+	init [][]byte  // packets up to and including the keyframe packet
+	pos  uint64    // absolute position of the packet following the init data
+	time time.Time // time the keyframe was published
+}
+
+func newTimeshiftBuffer(window time.Duration, maxBytes uint) *timeshiftBuffer {
+	return &timeshiftBuffer{
+		window:   window,
+		maxBytes: uint64(maxBytes),
+	}
+}
+
+// end returns the absolute position of the next published packet
+func (t *timeshiftBuffer) end() uint64 {
+	return t.start + uint64(len(t.packets))
+}
+
+// push adds a published packet to the buffer
+// init is the result of Channel.findGOP for the packet.
+func (t *timeshiftBuffer) push(b []byte, init [][]byte, now time.Time) {
+	t.packets = append(t.packets, timedPacket{data: b, time: now})
+	t.size += uint64(len(b))
+
+	// the init data already contains the current packet
+	if len(init) > 0 {
+		t.keyframes = append(t.keyframes, keyframe{init: init, pos: t.end(), time: now})
+	}
+	t.trim(now)
+}
+
+// trim drops keyframes and packets which are no longer needed to start
+// playback within the window or exceed maxBytes
+func (t *timeshiftBuffer) trim(now time.Time) {
+	cutoff := now.Add(-t.window)
+	for len(t.keyframes) > 1 && !t.keyframes[1].time.After(cutoff) {
+		t.keyframes = t.keyframes[1:]
+	}
+	t.drop()
+
+	// a single GOP above maxBytes leaves nothing to play from until the
+	// next keyframe
+	for t.size > t.maxBytes && len(t.keyframes) > 0 {
+		t.keyframes = t.keyframes[1:]
+		t.drop()
+	}
+}
+
+// drop removes the packets before the oldest keyframe
+func (t *timeshiftBuffer) drop() {
+	// nothing to play from without keyframes, e.g. for unsupported transports
+	keep := t.end()
+	if len(t.keyframes) > 0 {
+		keep = t.keyframes[0].pos
+	}
+	if keep <= t.start {
+		return
+	}
+	for _, pkt := range t.packets[:keep-t.start] {
+		t.size -= uint64(len(pkt.data))
+	}
+	t.packets = t.packets[keep-t.start:]
+	t.start = keep
+}
+
+// seek finds the keyframe published closest to offset before now
+// Keyframes older than the window are skipped, the buffer may drop their
+// packets before a delayed subscriber reaches them.
+func (t *timeshiftBuffer) seek(offset time.Duration, now time.Time) (keyframe, bool) {
+	cutoff := now.Add(-t.window)
+	target := now.Add(-offset)
+	var best *keyframe
+	for i := range t.keyframes {
+		kf := &t.keyframes[i]
+		if kf.time.Before(cutoff) {
+			continue
+		}
+		if best == nil || absDuration(kf.time.Sub(target)) < absDuration(best.time.Sub(target)) {
+			best = kf
+		}
+	}
+	if best == nil {
+		return keyframe{}, false
+	}
+	return *best, true
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
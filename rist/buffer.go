@@ -0,0 +1,139 @@
+package rist
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	// sequence number jumps treated as a restarted sender instead of loss,
+	// measured from the received packets
+	maxJump = 1000
+
+	// maximum number of packets held back behind a gap
+	maxPending = 8192
+)
+
+// loss is a packet detected as missing
+type loss struct {
+	detected time.Time
+	nacked   time.Time // zero if not requested yet
+}
+
+// buffer puts received packets back in order and tracks missing packets
+// Packets are released as soon as all previous packets were received. A gap
+// blocks the packets behind it until the missing packet is retransmitted or
+// the latency has passed, then it is skipped.
+type buffer struct {
+	latency time.Duration
+	started bool
+	next    uint16 // next sequence number to release
+	highest uint16 // highest received sequence number
+	packets map[uint16][]byte
+	missing map[uint16]*loss
+}
+
+func newBuffer(latency time.Duration) *buffer {
+	return &buffer{
+		latency: latency,
+		packets: make(map[uint16][]byte),
+		missing: make(map[uint16]*loss),
+	}
+}
+
+// push adds a received packet and returns the payloads ready to be published
+// Long gaps are left to maxPending and the latency, only packets far outside
+// the received range restart the buffer.
+func (b *buffer) push(seq uint16, payload []byte, now time.Time) [][]byte {
+	ahead := int16(seq - b.highest)
+	behind := int16(seq - b.next)
+	if !b.started || ahead > maxJump || behind < -maxJump {
+		b.reset(seq)
+	} else if behind < 0 {
+		// late or duplicate
+		return nil
+	}
+	if _, ok := b.packets[seq]; ok {
+		return nil
+	}
+
+	if int16(seq-b.highest) > 0 {
+		for missing := b.highest + 1; missing != seq; missing++ {
+			b.missing[missing] = &loss{detected: now}
+		}
+		b.highest = seq
+	}
+	delete(b.missing, seq)
+	b.packets[seq] = payload
+
+	res := b.release(nil)
+	for len(b.packets) > maxPending {
+		b.skip()
+		res = b.release(res)
+	}
+	return res
+}
+
+// reset starts over at seq, e.g. after the sender restarted
+func (b *buffer) reset(seq uint16) {
+	b.started = true
+	b.next = seq
+	b.highest = seq - 1
+	clear(b.packets)
+	clear(b.missing)
+}
+
+// release appends the payloads which are in order
+func (b *buffer) release(res [][]byte) [][]byte {
+	for {
+		payload, ok := b.packets[b.next]
+		if !ok {
+			return res
+		}
+		delete(b.packets, b.next)
+		b.next++
+		if len(payload) > 0 {
+			res = append(res, payload)
+		}
+	}
+}
+
+// skip gives up on the missing packet blocking the buffer
+func (b *buffer) skip() {
+	delete(b.missing, b.next)
+	b.next++
+}
+
+// expire skips missing packets which were not retransmitted within the
+// latency and returns the payloads released afterwards
+func (b *buffer) expire(now time.Time) [][]byte {
+	var res [][]byte
+	for {
+		l, ok := b.missing[b.next]
+		if !ok || now.Sub(l.detected) < b.latency {
+			return res
+		}
+		b.skip()
+		res = b.release(res)
+	}
+}
+
+// nacks returns up to limit missing packets to request, each missing
+// packet is requested again after interval
+func (b *buffer) nacks(now time.Time, interval time.Duration, limit int) []uint16 {
+	var seqs []uint16
+	for seq, l := range b.missing {
+		if l.nacked.IsZero() || now.Sub(l.nacked) >= interval {
+			seqs = append(seqs, seq)
+		}
+	}
+	// order by distance from the next released packet
+	slices.SortFunc(seqs, func(a, c uint16) int {
+		return int(a-b.next) - int(c-b.next)
+	})
+	seqs = seqs[:min(len(seqs), limit)]
+	for _, seq := range seqs {
+		b.missing[seq].nacked = now
+	}
+	return seqs
+}
@@ -0,0 +1,235 @@
+// Package rist receives contributions using the RIST Simple Profile
+// (VSF TR-06-1), MPEG-TS over RTP with retransmissions requested by RTCP NACKs.
+package rist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/voc/srtrelay/format"
+)
+
+const (
+	defaultLatency = time.Second
+
+	// interval of checking for losses and expired packets
+	tickInterval = 20 * time.Millisecond
+
+	// interval of receiver reports if there is nothing to request
+	rtcpInterval = 100 * time.Millisecond
+
+	// maximum number of packets requested at once
+	maxNACKs = 256
+
+	cname = "srtrelay"
+)
+
+var ErrInvalidURL = errors.New("invalid rist url")
+
+// Receiver listens for a RIST sender
+// RTP is received on an even port, RTCP on the port above. Lost packets are
+// requested again until they arrive or the latency has passed.
+type Receiver struct {
+	addr    *net.UDPAddr // RTP, RTCP uses the next port
+	latency time.Duration
+}
+
+// NewReceiver creates a receiver listening on a rist:// URL, e.g.
+// rist://@:5000?buffer=1000
+// The buffer query parameter sets the latency in ms.
+func NewReceiver(rawURL string) (*Receiver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if u.Scheme != "rist" || u.Port() == "" {
+		return nil, fmt.Errorf("%w: expected rist://host:port", ErrInvalidURL)
+	}
+	addr, err := net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if addr.Port%2 != 0 {
+		return nil, fmt.Errorf("%w: port has to be even", ErrInvalidURL)
+	}
+
+	r := &Receiver{addr: addr, latency: defaultLatency}
+	if query := u.Query(); query.Has("buffer") {
+		ms, err := strconv.Atoi(query.Get("buffer"))
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("%w: invalid buffer %s", ErrInvalidURL, query.Get("buffer"))
+		}
+		r.latency = time.Duration(ms) * time.Millisecond
+	}
+	return r, nil
+}
+
+// Port returns the RTP port the receiver listens on
+func (r *Receiver) Port() int {
+	return r.addr.Port
+}
+
+// StreamName returns the default name of the published stream, rist-<port>
+func (r *Receiver) StreamName() string {
+	return fmt.Sprintf("rist-%d", r.addr.Port)
+}
+
+// datagram is a packet received on the RTP or RTCP port
+type datagram struct {
+	data []byte
+	addr *net.UDPAddr
+	rtcp bool
+}
+
+// Run publishes the received stream until ctx is done or receiving fails
+func (r *Receiver) Run(ctx context.Context, pub chan<- []byte) error {
+	rtpConn, err := net.ListenUDP("udp", r.addr)
+	if err != nil {
+		return err
+	}
+	defer rtpConn.Close()
+	rtcpAddr := *r.addr
+	rtcpAddr.Port++
+	rtcpConn, err := net.ListenUDP("udp", &rtcpAddr)
+	if err != nil {
+		return err
+	}
+	defer rtcpConn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		rtpConn.Close()
+		rtcpConn.Close()
+	})
+	defer stop()
+
+	done := make(chan struct{})
+	defer close(done)
+	recv := make(chan datagram, 64)
+	errs := make(chan error, 2)
+	go read(rtpConn, false, recv, errs, done)
+	go read(rtcpConn, true, recv, errs, done)
+
+	s := newSession(r.latency)
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		var payloads [][]byte
+		select {
+		case d := <-recv:
+			if d.rtcp {
+				s.receiveRTCP(d.data, d.addr)
+				continue
+			}
+			payloads = s.receive(d.data, d.addr, time.Now())
+		case now := <-ticker.C:
+			payloads = s.buffer.expire(now)
+			if pkt, dest := s.feedback(now); pkt != nil {
+				// feedback is best effort, the sender may not be reachable yet
+				_, _ = rtcpConn.WriteToUDP(pkt, dest)
+			}
+		case err := <-errs:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		for _, payload := range payloads {
+			select {
+			case pub <- payload:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// read forwards datagrams received on conn until reading fails
+func read(conn *net.UDPConn, rtcp bool, recv chan<- datagram, errs chan<- error, done <-chan struct{}) {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			errs <- err
+			return
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		select {
+		case recv <- datagram{data: data, addr: addr, rtcp: rtcp}:
+		case <-done:
+			return
+		}
+	}
+}
+
+// session is the state of a receiver while it is running
+type session struct {
+	buffer       *buffer
+	nackInterval time.Duration
+	ssrc         uint32       // of the receiver
+	media        uint32       // of the sender, without the retransmission bit
+	source       *net.UDPAddr // RTP sender
+	sender       *net.UDPAddr // RTCP sender, nil until the first report
+	lastRTCP     time.Time
+}
+
+func newSession(latency time.Duration) *session {
+	return &session{
+		buffer:       newBuffer(latency),
+		nackInterval: max(latency/5, tickInterval),
+		ssrc:         rand.Uint32() &^ 1,
+	}
+}
+
+// receive handles a RTP packet and returns the payloads ready to be published
+// Retransmitted packets are sent with the lowest bit of the SSRC set.
+func (s *session) receive(data []byte, addr *net.UDPAddr, now time.Time) [][]byte {
+	hdr, payload, err := format.ParseRTP(data)
+	if err != nil {
+		return nil
+	}
+	s.media = hdr.SSRC &^ 1
+	s.source = addr
+	return s.buffer.push(hdr.Sequence, payload, now)
+}
+
+// receiveRTCP remembers the address the sender expects feedback on
+func (s *session) receiveRTCP(data []byte, addr *net.UDPAddr) {
+	if splitRTCP(data) != nil {
+		s.sender = addr
+	}
+}
+
+// feedback returns the RTCP packet to send and its destination
+// A receiver report is sent regularly to keep the connection alive, NACKs
+// are added for missing packets.
+func (s *session) feedback(now time.Time) ([]byte, *net.UDPAddr) {
+	dest := s.sender
+	if dest == nil && s.source != nil {
+		// the sender sends RTCP from the port above RTP
+		dest = &net.UDPAddr{IP: s.source.IP, Port: s.source.Port + 1, Zone: s.source.Zone}
+	}
+	if dest == nil {
+		return nil, nil
+	}
+	seqs := s.buffer.nacks(now, s.nackInterval, maxNACKs)
+	if len(seqs) == 0 && now.Sub(s.lastRTCP) < rtcpInterval {
+		return nil, nil
+	}
+	s.lastRTCP = now
+
+	pkt := appendRR(nil, s.ssrc)
+	pkt = appendSDES(pkt, s.ssrc, cname)
+	if len(seqs) > 0 {
+		pkt = appendNACK(pkt, s.ssrc, s.media, seqs)
+	}
+	return pkt, dest
+}
@@ -0,0 +1,293 @@
+package rist
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/voc/srtrelay/mpegts"
+	"github.com/voc/srtrelay/stream"
+)
+
+// parseNACK returns the sequence numbers requested by a generic NACK
+func parseNACK(pkt []byte) []uint16 {
+	if len(pkt) < 12 || pkt[1] != rtcpFB || pkt[0]&0x1f != fmtGenericNACK {
+		return nil
+	}
+	var seqs []uint16
+	for fci := pkt[12:]; len(fci) >= 4; fci = fci[4:] {
+		pid := binary.BigEndian.Uint16(fci)
+		blp := binary.BigEndian.Uint16(fci[2:])
+		seqs = append(seqs, pid)
+		for i := range uint16(16) {
+			if blp&(1<<i) != 0 {
+				seqs = append(seqs, pid+i+1)
+			}
+		}
+	}
+	return seqs
+}
+
+// rtpPacket creates a RTP packet with a MPEG-TS payload marked with seq
+func rtpPacket(seq uint16, ssrc uint32) []byte {
+	pkt := make([]byte, 12+mpegts.PacketLen)
+	pkt[0] = 2 << 6
+	pkt[1] = 33
+	binary.BigEndian.PutUint16(pkt[2:], seq)
+	binary.BigEndian.PutUint32(pkt[8:], ssrc)
+	pkt[12] = mpegts.SyncByte
+	pkt[16] = byte(seq)
+	return pkt
+}
+
+func TestNewReceiver(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		latency time.Duration
+		err     error
+	}{
+		{"Listen", "rist://@:5000", defaultLatency, nil},
+		{"Buffer", "rist://127.0.0.1:5000?buffer=500", 500 * time.Millisecond, nil},
+		{"OddPort", "rist://:5001", 0, ErrInvalidURL},
+		{"InvalidBuffer", "rist://:5000?buffer=0", 0, ErrInvalidURL},
+		{"Scheme", "udp://:5000", 0, ErrInvalidURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReceiver(tt.url)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Got error %v, expected %v", err, tt.err)
+			}
+			if err == nil && (r.latency != tt.latency || r.Port() != 5000) {
+				t.Errorf("Got latency %s port %d", r.latency, r.Port())
+			}
+		})
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	start := time.Now()
+	b := newBuffer(100 * time.Millisecond)
+	push := func(seq uint16, at time.Duration) int {
+		return len(b.push(seq, []byte{byte(seq)}, start.Add(at)))
+	}
+
+	// packet 2 is missing, 3 is held back
+	if n := push(1, 0) + push(3, 0); n != 1 {
+		t.Errorf("Released %d packets, expected 1", n)
+	}
+	if seqs := b.nacks(start, 50*time.Millisecond, maxNACKs); !slices.Equal(seqs, []uint16{2}) {
+		t.Errorf("Requested %v, expected [2]", seqs)
+	}
+	// requested again after the interval
+	if seqs := b.nacks(start.Add(10*time.Millisecond), 50*time.Millisecond, maxNACKs); len(seqs) != 0 {
+		t.Errorf("Requested %v before the interval", seqs)
+	}
+	if seqs := b.nacks(start.Add(50*time.Millisecond), 50*time.Millisecond, maxNACKs); len(seqs) != 1 {
+		t.Errorf("Requested %v, expected [2]", seqs)
+	}
+
+	// retransmission releases the held back packet
+	if n := push(2, 60*time.Millisecond); n != 2 {
+		t.Errorf("Released %d packets, expected 2", n)
+	}
+	if n := push(2, 60*time.Millisecond); n != 0 {
+		t.Errorf("Released %d packets for a duplicate", n)
+	}
+
+	// unrecovered losses are skipped after the latency
+	if n := push(6, 100*time.Millisecond); n != 0 {
+		t.Errorf("Released %d packets, expected 0", n)
+	}
+	if n := len(b.expire(start.Add(150 * time.Millisecond))); n != 0 {
+		t.Errorf("Released %d packets before the latency", n)
+	}
+	if n := len(b.expire(start.Add(200 * time.Millisecond))); n != 1 {
+		t.Errorf("Released %d packets after the latency, expected 1", n)
+	}
+	if len(b.missing) != 0 {
+		t.Errorf("Still missing %d packets", len(b.missing))
+	}
+}
+
+func TestBuffer_LongGap(t *testing.T) {
+	now := time.Now()
+	b := newBuffer(time.Second)
+	b.push(0, []byte{0}, now)
+
+	// packet 1 is missing, the packets behind it are held back
+	for seq := uint16(2); seq <= 2000; seq++ {
+		if n := len(b.push(seq, []byte{byte(seq)}, now)); n != 0 {
+			t.Fatalf("Released %d packets at %d, expected 0", n, seq)
+		}
+	}
+	if n := len(b.push(1, []byte{1}, now)); n != 2000 {
+		t.Errorf("Released %d packets, expected 2000", n)
+	}
+
+	// a restarted sender starts over
+	if n := len(b.push(40000, []byte{1}, now)); n != 1 || b.next != 40001 {
+		t.Errorf("Released %d packets, next %d, expected restart at 40000", n, b.next)
+	}
+
+	// too many held back packets skip the gap
+	b.push(40002, []byte{2}, now)
+	for seq := uint16(40003); b.next == 40001; seq++ {
+		if len(b.packets) > maxPending {
+			t.Fatalf("Holding %d packets", len(b.packets))
+		}
+		b.push(seq, []byte{3}, now)
+	}
+}
+
+func TestReceiver_StreamName(t *testing.T) {
+	r, err := NewReceiver("rist://@:5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := r.StreamName(); name != "rist-5000" {
+		t.Errorf("Got name %s, expected rist-5000", name)
+	}
+	if _, err := stream.NewStreamID(r.StreamName(), "", stream.ModePublish); err != nil {
+		t.Errorf("Invalid stream name: %v", err)
+	}
+}
+
+func TestAppendNACK(t *testing.T) {
+	seqs := []uint16{65534, 65535, 3, 20, 100}
+	pkt := appendRR(nil, 2)
+	pkt = appendSDES(pkt, 2, cname)
+	pkt = appendNACK(pkt, 2, 4, seqs)
+
+	pkts := splitRTCP(pkt)
+	if len(pkts) != 3 {
+		t.Fatalf("Got %d packets in compound, expected 3", len(pkts))
+	}
+	if got := parseNACK(pkts[2]); !slices.Equal(got, seqs) {
+		t.Errorf("Got %v, expected %v", got, seqs)
+	}
+	if media := binary.BigEndian.Uint32(pkts[2][8:]); media != 4 {
+		t.Errorf("Got media ssrc %d, expected 4", media)
+	}
+}
+
+// listenPair listens on a free even port and the port above
+func listenPair(t *testing.T) (*net.UDPConn, *net.UDPConn) {
+	t.Helper()
+	for range 100 {
+		rtp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := rtp.LocalAddr().(*net.UDPAddr).Port
+		if port%2 == 0 {
+			rtcp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port + 1})
+			if err == nil {
+				return rtp, rtcp
+			}
+		}
+		rtp.Close()
+	}
+	t.Fatal("No free port pair")
+	return nil, nil
+}
+
+func TestReceiver_Run(t *testing.T) {
+	// find a free port pair for the receiver
+	rtp, rtcp := listenPair(t)
+	port := rtp.LocalAddr().(*net.UDPAddr).Port
+	rtp.Close()
+	rtcp.Close()
+	r, err := NewReceiver(fmt.Sprintf("rist://127.0.0.1:%d?buffer=500", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pub := make(chan []byte, 20)
+	res := make(chan error, 1)
+	go func() {
+		res <- r.Run(ctx, pub)
+	}()
+
+	sendRTP, sendRTCP := listenPair(t)
+	defer sendRTP.Close()
+	defer sendRTCP.Close()
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	feedback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port + 1}
+
+	// announce the sender until the receiver is listening
+	ssrc := uint32(0x1000)
+	buf := make([]byte, 1500)
+	for range 50 {
+		if _, err := sendRTCP.WriteToUDP(appendRR(nil, ssrc), feedback); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sendRTP.WriteToUDP(rtpPacket(0, ssrc), dest); err != nil {
+			t.Fatal(err)
+		}
+		if err := sendRTCP.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := sendRTCP.ReadFromUDP(buf); err == nil {
+			break
+		}
+	}
+
+	// packet 5 gets lost
+	for seq := uint16(1); seq < 10; seq++ {
+		if seq == 5 {
+			continue
+		}
+		if _, err := sendRTP.WriteToUDP(rtpPacket(seq, ssrc), dest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// retransmit on request
+	if err := sendRTCP.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for retransmitted := false; !retransmitted; {
+		n, _, err := sendRTCP.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatal("No NACK received", err)
+		}
+		for _, pkt := range splitRTCP(buf[:n]) {
+			if slices.Contains(parseNACK(pkt), 5) {
+				if _, err := sendRTP.WriteToUDP(rtpPacket(5, ssrc|1), dest); err != nil {
+					t.Fatal(err)
+				}
+				retransmitted = true
+			}
+		}
+	}
+
+	// all packets are published in order
+	var got []byte
+	for len(got) < 10 {
+		select {
+		case payload := <-pub:
+			got = append(got, payload[4])
+		case <-time.After(time.Second):
+			t.Fatalf("Got packets %v, expected 10", got)
+		}
+	}
+	for i, seq := range got {
+		if int(seq) != i {
+			t.Fatalf("Got packets %v, expected in order", got)
+		}
+	}
+
+	cancel()
+	if err := <-res; err != context.Canceled {
+		t.Errorf("Got %v, expected %v", err, context.Canceled)
+	}
+}
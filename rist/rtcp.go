@@ -0,0 +1,89 @@
+package rist
+
+import (
+	"encoding/binary"
+)
+
+// RTCP packet types
+const (
+	rtcpRR   = 201
+	rtcpSDES = 202
+	rtcpFB   = 205 // transport layer feedback (RFC 4585)
+
+	rtcpVersion    = 2
+	sdesCNAME      = 1
+	fmtGenericNACK = 1
+)
+
+// appendHeader appends a RTCP header for a packet of length bytes
+func appendHeader(buf []byte, count, typ uint8, length int) []byte {
+	buf = append(buf, rtcpVersion<<6|count, typ)
+	return binary.BigEndian.AppendUint16(buf, uint16(length/4-1))
+}
+
+// appendRR appends an empty receiver report
+func appendRR(buf []byte, ssrc uint32) []byte {
+	buf = appendHeader(buf, 0, rtcpRR, 8)
+	return binary.BigEndian.AppendUint32(buf, ssrc)
+}
+
+// appendSDES appends a source description with the CNAME of ssrc
+func appendSDES(buf []byte, ssrc uint32, cname string) []byte {
+	cname = cname[:min(len(cname), 255)]
+	// ssrc, CNAME item, end of list and padding to a 32-bit boundary
+	chunk := 4 + 2 + len(cname) + 1
+	chunk += (4 - chunk%4) % 4
+	buf = appendHeader(buf, 1, rtcpSDES, 4+chunk)
+	buf = binary.BigEndian.AppendUint32(buf, ssrc)
+	buf = append(buf, sdesCNAME, byte(len(cname)))
+	buf = append(buf, cname...)
+	for range chunk - 4 - 2 - len(cname) {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// appendNACK appends a generic NACK (RFC 4585) requesting the sequence
+// numbers, which have to be ordered
+func appendNACK(buf []byte, ssrc, media uint32, seqs []uint16) []byte {
+	// each entry covers a packet id and a bitmask of the following 16 packets
+	var entries []uint32
+	for i := 0; i < len(seqs); {
+		pid := seqs[i]
+		var blp uint16
+		for i++; i < len(seqs); i++ {
+			offset := seqs[i] - pid
+			if offset == 0 || offset > 16 {
+				break
+			}
+			blp |= 1 << (offset - 1)
+		}
+		entries = append(entries, uint32(pid)<<16|uint32(blp))
+	}
+
+	buf = appendHeader(buf, fmtGenericNACK, rtcpFB, 12+4*len(entries))
+	buf = binary.BigEndian.AppendUint32(buf, ssrc)
+	buf = binary.BigEndian.AppendUint32(buf, media)
+	for _, entry := range entries {
+		buf = binary.BigEndian.AppendUint32(buf, entry)
+	}
+	return buf
+}
+
+// splitRTCP splits a compound RTCP packet
+// Returns nil if the packet is invalid.
+func splitRTCP(data []byte) [][]byte {
+	var pkts [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 || data[0]>>6 != rtcpVersion {
+			return nil
+		}
+		length := 4 * (int(binary.BigEndian.Uint16(data[2:4])) + 1)
+		if length > len(data) {
+			return nil
+		}
+		pkts = append(pkts, data[:length])
+		data = data[length:]
+	}
+	return pkts
+}
@@ -0,0 +1,197 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/voc/srtrelay/mpegts"
+)
+
+const (
+	// size of published buffers, same as the default SRT payload
+	chunkSize = 7 * mpegts.PacketLen
+
+	pcrClock = 27000000        // PCR ticks per second
+	pcrWrap  = (1 << 33) * 300 // PCR wrap around in 27 MHz units
+
+	// PCR jumps beyond this are discontinuities, e.g. when the file loops
+	maxPCRJump = pcrClock
+
+	// the pacing is reset if it fell behind further, e.g. after a stall
+	maxLag = time.Second
+)
+
+var ErrNoPCR = errors.New("file contains no PCR")
+
+// File publishes a MPEG-TS file in a loop, paced in real time by its PCR
+// Timestamps restart on every loop, the PCR is paced across the loop point
+// without a gap and signals a discontinuity.
+type File struct {
+	path string
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Run(ctx context.Context, pub chan<- []byte) error {
+	// a file without PCR would be published without pacing
+	if err := f.checkPCR(); err != nil {
+		return err
+	}
+	var p pacer
+	for {
+		hasPCR, err := f.play(ctx, pub, &p)
+		if err != nil {
+			return err
+		}
+		if !hasPCR {
+			return ErrNoPCR
+		}
+	}
+}
+
+// checkPCR returns ErrNoPCR if the file contains no PCR
+func (f *File) checkPCR() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReaderSize(file, 64*1024)
+
+	var pkt mpegts.Packet
+	data := make([]byte, mpegts.PacketLen)
+	for {
+		_, err := io.ReadFull(r, data)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrNoPCR
+		}
+		if err != nil {
+			return err
+		}
+		if err := pkt.FromBytes(data); err != nil {
+			return err
+		}
+		if _, ok := pkt.PCR(); ok {
+			return nil
+		}
+	}
+}
+
+// play publishes the file once, reports whether a PCR was found
+func (f *File) play(ctx context.Context, pub chan<- []byte, p *pacer) (bool, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	r := bufio.NewReaderSize(file, 64*1024)
+
+	var hasPCR bool
+	var pkt mpegts.Packet
+	buf := make([]byte, 0, chunkSize)
+	for {
+		if len(buf) == chunkSize {
+			if err := send(ctx, pub, buf); err != nil {
+				return hasPCR, err
+			}
+			buf = make([]byte, 0, chunkSize)
+		}
+
+		data := buf[len(buf) : len(buf)+mpegts.PacketLen]
+		_, err := io.ReadFull(r, data)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a truncated last packet is dropped
+			if len(buf) > 0 {
+				return hasPCR, send(ctx, pub, buf)
+			}
+			return hasPCR, nil
+		}
+		if err != nil {
+			return hasPCR, err
+		}
+		if err := pkt.FromBytes(data); err != nil {
+			return hasPCR, err
+		}
+
+		// publish everything before the PCR once it is due
+		if pcr, ok := pkt.PCR(); ok && p.track(pkt.PID()) {
+			// timestamps restart after the loop point, the adaptation field
+			// flags follow its length
+			if !hasPCR && p.started {
+				data[5] |= mpegts.DiscontinuityAFMask
+			}
+			hasPCR = true
+			if len(buf) > 0 {
+				if err := send(ctx, pub, buf); err != nil {
+					return hasPCR, err
+				}
+				buf = make([]byte, 0, chunkSize)
+				copy(buf[:mpegts.PacketLen], data)
+			}
+			if err := p.wait(ctx, pcr); err != nil {
+				return hasPCR, err
+			}
+		}
+		buf = buf[:len(buf)+mpegts.PacketLen]
+	}
+}
+
+func send(ctx context.Context, pub chan<- []byte, buf []byte) error {
+	select {
+	case pub <- buf:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pacer schedules packets by the PCR of a single PID
+type pacer struct {
+	pid      uint16
+	started  bool
+	last     uint64    // last PCR
+	deadline time.Time // wall clock time of the last PCR
+}
+
+// track reports whether pid carries the PCR used for pacing, the first PID
+// with a PCR is used
+func (p *pacer) track(pid uint16) bool {
+	if !p.started {
+		p.pid = pid
+	}
+	return p.pid == pid
+}
+
+// wait blocks until the packet carrying pcr is due
+func (p *pacer) wait(ctx context.Context, pcr uint64) error {
+	now := time.Now()
+	if !p.started {
+		p.started = true
+		p.deadline = now
+	} else if delta := (pcr + pcrWrap - p.last) % pcrWrap; delta <= maxPCRJump {
+		p.deadline = p.deadline.Add(time.Duration(delta) * time.Second / pcrClock)
+	}
+	p.last = pcr
+	if now.Sub(p.deadline) > maxLag {
+		p.deadline = now
+	}
+
+	wait := time.Until(p.deadline)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,179 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/voc/srtrelay/relay"
+)
+
+var (
+	ErrSourceExists   = errors.New("source already exists")
+	ErrSourceNotFound = errors.New("source not found")
+	ErrRunning        = errors.New("source already running")
+	ErrNotRunning     = errors.New("source not running")
+)
+
+// Source produces a stream which is published to the relay
+// It is the same interface as relay.Source, so sources can serve as fallbacks.
+type Source = relay.Source
+
+// Status describes a configured source
+type Status struct {
+	Name    string `json:"name"` // name of the published stream
+	Type    string `json:"type"`
+	Running bool   `json:"running"`
+	Error   string `json:"error,omitempty"` // reason the last run failed
+}
+
+// Manager publishes sources to the relay
+// Sources publish like SRT publishers, so they are subject to the configured
+// publisher policy.
+type Manager struct {
+	relay   relay.Relay
+	mutex   sync.Mutex
+	sources map[string]*entry // by stream name
+}
+
+type entry struct {
+	typ    string
+	source Source
+	run    *run // nil if not running
+	err    error
+}
+
+// run is a single publishing session of a source
+type run struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewManager(r relay.Relay) *Manager {
+	return &Manager{
+		relay:   r,
+		sources: make(map[string]*entry),
+	}
+}
+
+// Add registers a source publishing the stream name
+func (m *Manager) Add(name, typ string, src Source) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.sources[name]; ok {
+		return ErrSourceExists
+	}
+	m.sources[name] = &entry{typ: typ, source: src}
+	return nil
+}
+
+// Start publishes a source until it is stopped, fails or is replaced by
+// another publisher
+func (m *Manager) Start(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.sources[name]
+	if !ok {
+		return ErrSourceNotFound
+	}
+	if e.run != nil {
+		return ErrRunning
+	}
+	pub, kicked, err := m.relay.Publish(name, relay.PolicyDefault)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &run{cancel: cancel, done: make(chan struct{})}
+	e.run = r
+	e.err = nil
+	log.Printf("Started %s source %s", e.typ, name)
+
+	go func() {
+		defer close(r.done)
+		// stop when replaced by another publisher
+		go func() {
+			select {
+			case <-kicked:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		err := e.source.Run(ctx, pub)
+		close(pub)
+		if ctx.Err() != nil {
+			err = nil
+		}
+		cancel()
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if e.run == r {
+			e.run = nil
+			e.err = err
+		}
+		if err != nil {
+			log.Printf("%s source %s failed: %v", e.typ, name, err)
+			return
+		}
+		log.Printf("Stopped %s source %s", e.typ, name)
+	}()
+	return nil
+}
+
+// Stop ends publishing a source and waits until it stopped
+func (m *Manager) Stop(name string) error {
+	m.mutex.Lock()
+	e, ok := m.sources[name]
+	if !ok {
+		m.mutex.Unlock()
+		return ErrSourceNotFound
+	}
+	r := e.run
+	m.mutex.Unlock()
+	if r == nil {
+		return ErrNotRunning
+	}
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+// Sources returns the status of all sources ordered by name
+func (m *Manager) Sources() []Status {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	statuses := make([]Status, 0, len(m.sources))
+	for name, e := range m.sources {
+		status := Status{
+			Name:    name,
+			Type:    e.typ,
+			Running: e.run != nil,
+		}
+		if e.err != nil {
+			status.Error = e.err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	slices.SortFunc(statuses, func(a, b Status) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return statuses
+}
+
+// Close stops all sources
+func (m *Manager) Close() {
+	m.mutex.Lock()
+	names := make([]string, 0, len(m.sources))
+	for name := range m.sources {
+		names = append(names, name)
+	}
+	m.mutex.Unlock()
+	for _, name := range names {
+		_ = m.Stop(name)
+	}
+}
@@ -0,0 +1,184 @@
+package source
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/voc/srtrelay/mpegts"
+	"github.com/voc/srtrelay/relay"
+)
+
+// writeFile creates a MPEG-TS file with a PCR packet every interval
+func writeFile(t *testing.T, packets int, interval time.Duration) string {
+	t.Helper()
+	data := make([]byte, packets*mpegts.PacketLen)
+	for i := range packets {
+		base := uint64(i) * uint64(interval) * 90000 / uint64(time.Second)
+		af := []byte{mpegts.PCRAFMask, byte(base >> 25), byte(base >> 17), byte(base >> 9), byte(base >> 1), byte(base<<7) | 0x7e, 0}
+		pkt := mpegts.CreatePacket(256).WithAdaptationField(af)
+		if err := pkt.ToBytes(data[i*mpegts.PacketLen:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "test.ts")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFile_Run(t *testing.T) {
+	// 10 packets spanning 450ms, so about 4 loops per second
+	path := writeFile(t, 10, 50*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	pub := make(chan []byte)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- NewFile(path).Run(ctx, pub)
+	}()
+	packets := 0
+	discontinuities := 0
+	for {
+		select {
+		case buf := <-pub:
+			for offset := 0; offset < len(buf); offset += mpegts.PacketLen {
+				var pkt mpegts.Packet
+				if err := pkt.FromBytes(buf[offset:]); err != nil {
+					t.Fatal(err)
+				}
+				if pkt.Discontinuity() {
+					discontinuities++
+					if packets%10 != 0 {
+						t.Errorf("Discontinuity at packet %d, expected the loop point", packets)
+					}
+				}
+				packets++
+			}
+			continue
+		case err := <-errs:
+			if err != context.DeadlineExceeded {
+				t.Errorf("Got %v, expected %v", err, context.DeadlineExceeded)
+			}
+		}
+		break
+	}
+	// one packet per 50ms
+	if packets < 15 || packets > 25 {
+		t.Errorf("Got %d packets in a second, expected about 20", packets)
+	}
+	// every loop but the first
+	if expected := (packets - 1) / 10; discontinuities != expected {
+		t.Errorf("Got %d discontinuities, expected %d", discontinuities, expected)
+	}
+}
+
+func TestFile_NoPCR(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.ts")
+	data := make([]byte, mpegts.PacketLen)
+	if err := mpegts.CreatePacket(256).ToBytes(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	pub := make(chan []byte, 1)
+	if err := NewFile(path).Run(context.Background(), pub); err != ErrNoPCR {
+		t.Errorf("Got %v, expected %v", err, ErrNoPCR)
+	}
+	if len(pub) != 0 {
+		t.Error("Expected nothing to be published without PCR")
+	}
+}
+
+func TestStream_Run(t *testing.T) {
+	r := relay.NewRelay(&relay.RelayConfig{BufferSize: 10, PacketSize: 1})
+	if err := NewStream(r, "slate").Run(context.Background(), nil); err != relay.ErrStreamNotExisting {
+		t.Errorf("Got %v, expected %v", err, relay.ErrStreamNotExisting)
+	}
+
+	slate, _, err := r.Publish("slate", relay.PolicyDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := make(chan []byte, 1)
+	res := make(chan error, 1)
+	go func() {
+		res <- NewStream(r, "slate").Run(context.Background(), pub)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	slate <- []byte{1}
+	if got := <-pub; got[0] != 1 {
+		t.Errorf("Got %x, expected 1", got)
+	}
+
+	close(slate)
+	if err := <-res; err != ErrStreamEnded {
+		t.Errorf("Got %v, expected %v", err, ErrStreamEnded)
+	}
+}
+
+func TestManager(t *testing.T) {
+	r := relay.NewRelay(&relay.RelayConfig{BufferSize: 1316 * 10, PacketSize: 1316})
+	m := NewManager(r)
+	if err := m.Add("test", "file", NewFile(writeFile(t, 10, 10*time.Millisecond))); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add("test", "file", NewFile("")); err != ErrSourceExists {
+		t.Errorf("Got %v, expected %v", err, ErrSourceExists)
+	}
+	if err := m.Add("missing", "file", NewFile(filepath.Join(t.TempDir(), "missing.ts"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Start("other"); err != ErrSourceNotFound {
+		t.Errorf("Got %v, expected %v", err, ErrSourceNotFound)
+	}
+	if err := m.Stop("test"); err != ErrNotRunning {
+		t.Errorf("Got %v, expected %v", err, ErrNotRunning)
+	}
+	if err := m.Start("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start("test"); err != ErrRunning {
+		t.Errorf("Got %v, expected %v", err, ErrRunning)
+	}
+
+	// published like a SRT publisher
+	sub, unsub, err := r.Subscribe("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsub()
+	if _, ok := sub.Read(); !ok {
+		t.Fatal("Expected data from the source")
+	}
+
+	// failing sources report their error
+	if err := m.Start("missing"); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if status := m.Sources()[0]; !status.Running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	statuses := m.Sources()
+	if len(statuses) != 2 || statuses[0].Name != "missing" || statuses[0].Running || statuses[0].Error == "" {
+		t.Errorf("Got status %+v", statuses)
+	}
+	if !statuses[1].Running || statuses[1].Type != "file" {
+		t.Errorf("Got status %+v", statuses[1])
+	}
+
+	m.Close()
+	if status := m.Sources()[1]; status.Running || status.Error != "" {
+		t.Errorf("Got status %+v after close", status)
+	}
+}
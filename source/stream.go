@@ -0,0 +1,43 @@
+package source
+
+import (
+	"context"
+	"errors"
+
+	"github.com/voc/srtrelay/relay"
+)
+
+var ErrStreamEnded = errors.New("stream ended")
+
+// Stream republishes another stream of the relay, e.g. as fallback
+type Stream struct {
+	relay relay.Relay
+	name  string
+}
+
+func NewStream(r relay.Relay, name string) *Stream {
+	return &Stream{relay: r, name: name}
+}
+
+func (s *Stream) Run(ctx context.Context, pub chan<- []byte) error {
+	sub, unsubscribe, err := s.relay.Subscribe(s.name)
+	if err != nil {
+		return err
+	}
+	defer unsubscribe()
+	stop := context.AfterFunc(ctx, unsubscribe)
+	defer stop()
+
+	for {
+		buf, ok := sub.Read()
+		if !ok {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return ErrStreamEnded
+		}
+		if err := send(ctx, pub, buf); err != nil {
+			return err
+		}
+	}
+}
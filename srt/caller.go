@@ -0,0 +1,217 @@
+package srt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/haivision/srtgo"
+	"github.com/voc/srtrelay/format"
+	"github.com/voc/srtrelay/stream"
+)
+
+// reconnect backoff of connections to remote listeners, doubled after each
+// failed attempt
+const (
+	callerMinBackoff = time.Second
+	callerMaxBackoff = 30 * time.Second
+)
+
+var (
+	ErrInvalidURL       = errors.New("invalid srt url")
+	errSocketCreate     = errors.New("failed to create socket")
+	errConnectionClosed = errors.New("connection closed")
+)
+
+// remote is a SRT listener the relay connects to in caller mode
+type remote struct {
+	address string // host:port
+	host    string
+	port    uint16
+	options map[string]string
+}
+
+// newRemote parses a srt:// URL
+// SRT socket options can be set as URL query parameters,
+// e.g. srt://host:port?streamid=foo&passphrase=secret&latency=500
+func (s *ServerImpl) newRemote(rawURL string) (*remote, error) {
+	host, port, options, err := parseCallerURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := options["latency"]; !ok {
+		options["latency"] = strconv.Itoa(int(s.config.Latency))
+	}
+	if _, ok := options["lossmaxttl"]; !ok {
+		options["lossmaxttl"] = strconv.Itoa(int(s.config.LossMaxTTL))
+	}
+	return &remote{
+		address: net.JoinHostPort(host, strconv.Itoa(int(port))),
+		host:    host,
+		port:    port,
+		options: options,
+	}, nil
+}
+
+// parseCallerURL splits a srt:// URL into host, port and socket options
+func parseCallerURL(rawURL string) (string, uint16, map[string]string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if u.Scheme != "srt" || u.Hostname() == "" || u.Port() == "" {
+		return "", 0, nil, fmt.Errorf("%w: expected srt://host:port", ErrInvalidURL)
+	}
+	port, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+
+	options := make(map[string]string)
+	for key, values := range u.Query() {
+		options[key] = values[0]
+	}
+	options["blocking"] = "1"
+	options["transtype"] = "live"
+	options["mode"] = "caller"
+	return u.Hostname(), uint16(port), options, nil
+}
+
+// dial connects to the remote listener
+// The socket is closed when ctx is done, also while connecting.
+// The returned function closes the socket.
+func (r *remote) dial(ctx context.Context) (*srtgo.SrtSocket, func(), error) {
+	sock := srtgo.NewSrtSocket(r.host, r.port, r.options)
+	if sock == nil {
+		return nil, nil, errSocketCreate
+	}
+	stop := context.AfterFunc(ctx, sock.Close)
+	closeSocket := func() {
+		stop()
+		sock.Close()
+	}
+	if err := sock.Connect(); err != nil {
+		closeSocket()
+		return nil, nil, err
+	}
+	return sock, closeSocket, nil
+}
+
+// backoff is the delay before reconnecting to a remote listener
+type backoff time.Duration
+
+// wait sleeps for the current delay and doubles it
+// Returns false if ctx is done before.
+func (b *backoff) wait(ctx context.Context) bool {
+	if *b == 0 {
+		*b = backoff(callerMinBackoff)
+	}
+	select {
+	case <-time.After(time.Duration(*b)):
+	case <-ctx.Done():
+		return false
+	}
+	*b = backoff(min(time.Duration(*b)*2, callerMaxBackoff))
+	return true
+}
+
+// reset starts over with the minimum delay
+func (b *backoff) reset() {
+	*b = backoff(callerMinBackoff)
+}
+
+// Caller pulls a stream from a remote SRT listener, e.g. an encoder
+// It reconnects with an exponential backoff until it is stopped and shows up
+// in the socket statistics like a SRT publisher.
+type Caller struct {
+	server   *ServerImpl
+	streamid *stream.StreamID // local stream
+	remote   *remote
+}
+
+// NewCaller creates a caller publishing the stream of a srt:// URL as name
+func (s *ServerImpl) NewCaller(name, rawURL string) (*Caller, error) {
+	streamid, err := stream.NewStreamID(name, "", stream.ModePublish)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.newRemote(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Caller{
+		server:   s,
+		streamid: streamid,
+		remote:   r,
+	}, nil
+}
+
+// Run connects to the remote listener and publishes the received data until
+// ctx is done
+func (c *Caller) Run(ctx context.Context, pub chan<- []byte) error {
+	var delay backoff
+	for {
+		connected, err := c.connect(ctx, pub)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if connected {
+			delay.reset()
+		}
+		log.Printf("%s - %s - %v, reconnecting", c.remote.address, c.streamid.Name(), err)
+		if !delay.wait(ctx) {
+			return ctx.Err()
+		}
+	}
+}
+
+// connect pulls the stream once, connected reports whether data was received
+func (c *Caller) connect(ctx context.Context, pub chan<- []byte) (connected bool, err error) {
+	sock, closeSocket, err := c.remote.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer closeSocket()
+	log.Printf("%s - pull %s\n", c.remote.address, c.streamid.Name())
+
+	conn := &srtConn{
+		socket:   sock,
+		address:  c.remote.address,
+		streamid: c.streamid,
+	}
+	subctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c.server.registerForStats(subctx, conn)
+
+	unwrap := format.NewUnwrapper()
+	buf := make([]byte, 2048)
+	for {
+		n, err := sock.Read(buf)
+
+		// Push read buffers to all clients via the publish channel
+		if n > 0 {
+			connected = true
+			tmp := make([]byte, n)
+			copy(tmp, buf[:n])
+			for _, payload := range unwrap.Unwrap(tmp) {
+				select {
+				case pub <- payload:
+				case <-ctx.Done():
+					return connected, ctx.Err()
+				}
+			}
+		}
+
+		if err != nil {
+			return connected, err
+		}
+		if n == 0 {
+			return connected, errConnectionClosed
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package srt
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseCallerURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		host     string
+		port     uint16
+		streamid string
+		err      error
+	}{
+		{"Simple", "srt://encoder:9000", "encoder", 9000, "", nil},
+		{"Options", "srt://10.0.0.1:9000?streamid=foo&latency=500", "10.0.0.1", 9000, "foo", nil},
+		{"IPv6", "srt://[::1]:9000", "::1", 9000, "", nil},
+		{"Scheme", "udp://encoder:9000", "", 0, "", ErrInvalidURL},
+		{"NoPort", "srt://encoder", "", 0, "", ErrInvalidURL},
+		{"InvalidPort", "srt://encoder:100000", "", 0, "", ErrInvalidURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, options, err := parseCallerURL(tt.url)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Got error %v, expected %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if host != tt.host || port != tt.port {
+				t.Errorf("Got %s:%d, expected %s:%d", host, port, tt.host, tt.port)
+			}
+			if options["streamid"] != tt.streamid {
+				t.Errorf("Got streamid %q, expected %q", options["streamid"], tt.streamid)
+			}
+			if options["mode"] != "caller" {
+				t.Errorf("Got mode %q, expected caller", options["mode"])
+			}
+		})
+	}
+}
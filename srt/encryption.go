@@ -0,0 +1,107 @@
+package srt
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/IGLOU-EU/go-wildcard/v2"
+	"github.com/haivision/srtgo"
+	"github.com/voc/srtrelay/auth"
+	"github.com/voc/srtrelay/stream"
+)
+
+var (
+	ErrInvalidEncryption = errors.New("invalid encryption")
+
+	// ErrEncrypted denies outputs which would send an encrypted stream in plaintext
+	ErrEncrypted = fmt.Errorf("%w: stream requires SRT encryption", ErrAccessDenied)
+)
+
+// Encryption is the SRT encryption required from publishers and players
+type Encryption struct {
+	// streams the encryption applies to, supports the same wildcards as the
+	// static authenticator, empty for all streams
+	Streams []string
+
+	// passphrase of 10 to 79 characters, empty disables encryption
+	Passphrase string
+
+	// key length in bytes, 16, 24 or 32, 0 uses the default
+	PBKeyLen int
+}
+
+// Validate checks whether libsrt accepts the passphrase and key length
+func (e Encryption) Validate() error {
+	if e.Passphrase != "" && (len(e.Passphrase) < 10 || len(e.Passphrase) > 79) {
+		return fmt.Errorf("%w: passphrase needs 10 to 79 characters", ErrInvalidEncryption)
+	}
+	switch e.PBKeyLen {
+	case 0, 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("%w: pbkeylen has to be 16, 24 or 32", ErrInvalidEncryption)
+	}
+}
+
+// matches returns whether the encryption applies to a stream name
+func (e Encryption) matches(name string) bool {
+	return len(e.Streams) == 0 || slices.ContainsFunc(e.Streams, func(pattern string) bool {
+		return wildcard.Match(pattern, name)
+	})
+}
+
+// encryption chooses the encryption of a connection
+// A passphrase from the authenticator takes precedence over the first
+// matching per-stream encryption, which takes precedence over the global one.
+func (s *ServerImpl) encryption(name string, options auth.Options) Encryption {
+	if options.Passphrase != "" {
+		return Encryption{Passphrase: options.Passphrase, PBKeyLen: options.PBKeyLen}
+	}
+	for _, e := range s.config.Encryption {
+		if e.matches(name) {
+			return e
+		}
+	}
+	return Encryption{Passphrase: s.config.Passphrase, PBKeyLen: s.config.PBKeyLen}
+}
+
+// setEncrypted remembers whether the publisher of a stream uses encryption
+func (s *ServerImpl) setEncrypted(name string, encrypted bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if encrypted {
+		s.encrypted[name] = true
+	} else {
+		delete(s.encrypted, name)
+	}
+}
+
+// isEncrypted returns whether a stream may only be sent encrypted
+// Besides the configured encryption, this includes passphrases the
+// authenticator gave to the publisher.
+func (s *ServerImpl) isEncrypted(name string) bool {
+	s.mutex.Lock()
+	encrypted := s.encrypted[name]
+	s.mutex.Unlock()
+	return encrypted || s.encryption(name, auth.Options{}).Passphrase != ""
+}
+
+// setEncryption requires the connection to be encrypted with the passphrase
+// of its stream. As encryption is enforced, libsrt rejects peers using
+// another passphrase or no encryption.
+func (s *ServerImpl) setEncryption(socket *srtgo.SrtSocket, streamid stream.StreamID, options auth.Options) error {
+	e := s.encryption(streamid.Name(), options)
+	if e.Passphrase == "" {
+		return nil
+	}
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	if e.PBKeyLen != 0 {
+		if err := socket.SetSockOptInt(srtgo.SRTO_PBKEYLEN, e.PBKeyLen); err != nil {
+			return err
+		}
+	}
+	return socket.SetSockOptString(srtgo.SRTO_PASSPHRASE, e.Passphrase)
+}
@@ -0,0 +1,87 @@
+package srt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/voc/srtrelay/auth"
+	"github.com/voc/srtrelay/stream"
+)
+
+func TestEncryption_Validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		encryption Encryption
+		err        error
+	}{
+		{"Disabled", Encryption{}, nil},
+		{"Passphrase", Encryption{Passphrase: "0123456789"}, nil},
+		{"KeyLen", Encryption{Passphrase: "0123456789", PBKeyLen: 24}, nil},
+		{"Short", Encryption{Passphrase: "secret"}, ErrInvalidEncryption},
+		{"Long", Encryption{Passphrase: string(make([]byte, 80))}, ErrInvalidEncryption},
+		{"InvalidKeyLen", Encryption{Passphrase: "0123456789", PBKeyLen: 8}, ErrInvalidEncryption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.encryption.Validate(); !errors.Is(err, tt.err) {
+				t.Errorf("Got error %v, expected %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestServerImpl_encryption(t *testing.T) {
+	s := &ServerImpl{config: &ServerConfig{
+		Passphrase: "globalsecret",
+		Encryption: []Encryption{
+			{Streams: []string{"live-*"}, Passphrase: "livesecret", PBKeyLen: 32},
+			{Streams: []string{"public"}},
+		},
+	}}
+	tests := []struct {
+		name       string
+		id         string
+		options    auth.Options
+		passphrase string
+		keylen     int
+	}{
+		{"Global", "play/other", auth.Options{}, "globalsecret", 0},
+		{"Stream", "publish/live-foo", auth.Options{}, "livesecret", 32},
+		{"Unencrypted", "play/public", auth.Options{}, "", 0},
+		{"Auth", "play/live-foo/password", auth.Options{Passphrase: "authsecret", PBKeyLen: 16}, "authsecret", 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var streamid stream.StreamID
+			if err := streamid.FromString(tt.id); err != nil {
+				t.Fatal(err)
+			}
+			e := s.encryption(streamid.Name(), tt.options)
+			if e.Passphrase != tt.passphrase || e.PBKeyLen != tt.keylen {
+				t.Errorf("Got %q/%d, expected %q/%d", e.Passphrase, e.PBKeyLen, tt.passphrase, tt.keylen)
+			}
+		})
+	}
+}
+
+func TestServerImpl_isEncrypted(t *testing.T) {
+	s := &ServerImpl{
+		config: &ServerConfig{
+			Encryption: []Encryption{{Streams: []string{"live-*"}, Passphrase: "livesecret"}},
+		},
+		encrypted: make(map[string]bool),
+	}
+	if !s.isEncrypted("live-foo") || s.isEncrypted("public") {
+		t.Error("Expected only configured streams to be encrypted")
+	}
+
+	// published with a passphrase from the authenticator
+	s.setEncrypted("public", true)
+	if !s.isEncrypted("public") {
+		t.Error("Expected stream published with encryption to be encrypted")
+	}
+	s.setEncrypted("public", false)
+	if s.isEncrypted("public") {
+		t.Error("Expected stream to be unencrypted after close")
+	}
+}
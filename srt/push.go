@@ -0,0 +1,345 @@
+package srt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/IGLOU-EU/go-wildcard/v2"
+	"github.com/voc/srtrelay/relay"
+	"github.com/voc/srtrelay/stream"
+)
+
+var (
+	ErrTargetExists   = errors.New("push target already exists")
+	ErrTargetNotFound = errors.New("push target not found")
+	ErrInvalidTarget  = errors.New("invalid push target")
+)
+
+// Target describes a remote SRT listener streams are pushed to
+type Target struct {
+	Name string `json:"name"`
+
+	// streams pushed to the target, supports the same wildcards as the
+	// static authenticator
+	Streams []string `json:"streams"`
+
+	// srt:// URL of the remote listener, SRT socket options can be set as
+	// query parameters
+	URL string `json:"url"`
+}
+
+// TargetStatus describes a push target and the streams currently pushed to it
+type TargetStatus struct {
+	Target
+	Pushes []PushStatus `json:"pushes"`
+}
+
+// PushStatus describes a stream pushed to a target
+type PushStatus struct {
+	Stream      string `json:"stream"`
+	Connected   bool   `json:"connected"`
+	Connections uint64 `json:"connections"` // successful connection attempts
+	BytesSent   uint64 `json:"bytes_sent"`
+	Error       string `json:"error,omitempty"` // reason the last connection failed
+}
+
+// Pusher forwards streams to remote SRT listeners in caller mode
+// Streams matching a target are pushed from the moment they are published
+// until they end. Failed connections are retried with an exponential backoff.
+type Pusher struct {
+	server  *ServerImpl
+	mutex   sync.Mutex
+	targets map[string]*target // by name
+}
+
+type target struct {
+	Target
+	remote *remote
+	pushes map[string]*push // by stream name
+}
+
+// push is a single stream pushed to a target
+type push struct {
+	target *target
+	name   string
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mutex       sync.Mutex
+	connected   bool
+	connections uint64
+	bytes       uint64
+	err         error
+}
+
+// NewPusher creates a pusher for the streams of the server
+func (s *ServerImpl) NewPusher() *Pusher {
+	p := &Pusher{
+		server:  s,
+		targets: make(map[string]*target),
+	}
+	s.relay.OnChannel(p.onChannel)
+	return p
+}
+
+// onChannel starts pushing new channels to the matching targets
+func (p *Pusher) onChannel(name string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, t := range p.targets {
+		p.start(t, name)
+	}
+}
+
+// Add registers a target and starts pushing the matching published streams
+func (p *Pusher) Add(config Target) error {
+	if config.Name == "" || len(config.Streams) == 0 {
+		return fmt.Errorf("%w: name and streams are required", ErrInvalidTarget)
+	}
+	r, err := p.server.newRemote(config.URL)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.targets[config.Name]; ok {
+		return ErrTargetExists
+	}
+	t := &target{
+		Target: config,
+		remote: r,
+		pushes: make(map[string]*push),
+	}
+	p.targets[config.Name] = t
+	log.Printf("Added push target %s", config.Name)
+
+	for _, st := range p.server.relay.GetStatistics() {
+		p.start(t, st.Name)
+	}
+	return nil
+}
+
+// Remove stops all pushes to a target and removes it
+func (p *Pusher) Remove(name string) error {
+	p.mutex.Lock()
+	t, ok := p.targets[name]
+	if !ok {
+		p.mutex.Unlock()
+		return ErrTargetNotFound
+	}
+	delete(p.targets, name)
+	pushes := make([]*push, 0, len(t.pushes))
+	for _, ps := range t.pushes {
+		ps.cancel()
+		pushes = append(pushes, ps)
+	}
+	p.mutex.Unlock()
+
+	for _, ps := range pushes {
+		<-ps.done
+	}
+	log.Printf("Removed push target %s", name)
+	return nil
+}
+
+// Targets returns the status of all targets ordered by name
+func (p *Pusher) Targets() []TargetStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	statuses := make([]TargetStatus, 0, len(p.targets))
+	for _, t := range p.targets {
+		status := TargetStatus{
+			Target: t.Target,
+			Pushes: make([]PushStatus, 0, len(t.pushes)),
+		}
+		status.URL = redactURL(t.URL)
+		for _, ps := range t.pushes {
+			status.Pushes = append(status.Pushes, ps.status())
+		}
+		slices.SortFunc(status.Pushes, func(a, b PushStatus) int {
+			return strings.Compare(a.Stream, b.Stream)
+		})
+		statuses = append(statuses, status)
+	}
+	slices.SortFunc(statuses, func(a, b TargetStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return statuses
+}
+
+// Close stops all pushes
+func (p *Pusher) Close() {
+	p.mutex.Lock()
+	names := make([]string, 0, len(p.targets))
+	for name := range p.targets {
+		names = append(names, name)
+	}
+	p.mutex.Unlock()
+	for _, name := range names {
+		_ = p.Remove(name)
+	}
+}
+
+// start pushes a stream to a target if it matches and is not pushed yet
+// Must be called with the pusher mutex held.
+func (p *Pusher) start(t *target, name string) {
+	if _, ok := t.pushes[name]; ok {
+		return
+	}
+	if !slices.ContainsFunc(t.Streams, func(pattern string) bool {
+		return wildcard.Match(pattern, name)
+	}) {
+		return
+	}
+	if p.server.isEncrypted(name) && t.remote.options["passphrase"] == "" {
+		log.Printf("Not pushing %s to %s, the stream requires encryption but the target has no passphrase", name, t.Name)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ps := &push{
+		target: t,
+		name:   name,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	t.pushes[name] = ps
+	go p.run(ps)
+}
+
+// run pushes a stream until it ends or the target is removed
+func (p *Pusher) run(ps *push) {
+	defer close(ps.done)
+	log.Printf("Started pushing %s to %s", ps.name, ps.target.Name)
+	var delay backoff
+	for p.keep(ps) {
+		connected, err := p.connect(ps)
+		if connected {
+			delay.reset()
+		}
+		if err == nil || ps.ctx.Err() != nil {
+			continue
+		}
+		ps.setError(err)
+		log.Printf("%s - push %s - %v, reconnecting", ps.target.remote.address, ps.name, err)
+		delay.wait(ps.ctx)
+	}
+	ps.cancel()
+	log.Printf("Stopped pushing %s to %s", ps.name, ps.target.Name)
+}
+
+// keep reports whether a push continues, otherwise it is removed
+// Pushes end when they are stopped or their stream no longer exists.
+func (p *Pusher) keep(ps *push) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if ps.ctx.Err() == nil && p.server.relay.ChannelExists(ps.name) {
+		return true
+	}
+	if ps.target.pushes[ps.name] == ps {
+		delete(ps.target.pushes, ps.name)
+	}
+	return false
+}
+
+// connect pushes the stream over a single connection until the stream ends
+// or writing fails, connected reports whether the connection was established
+func (p *Pusher) connect(ps *push) (connected bool, err error) {
+	streamid, err := stream.NewStreamID(ps.name, "", stream.ModePlay)
+	if err != nil {
+		return false, err
+	}
+	address := ps.target.remote.address
+	sock, closeSocket, err := ps.target.remote.dial(ps.ctx)
+	if err != nil {
+		return false, err
+	}
+	defer closeSocket()
+	log.Printf("%s - push %s\n", address, ps.name)
+
+	conn := &srtConn{
+		socket:   sock,
+		address:  address,
+		streamid: streamid,
+	}
+	subctx, cancel := context.WithCancel(ps.ctx)
+	defer cancel()
+	p.server.registerForStats(subctx, conn)
+
+	ps.setConnected(true)
+	defer ps.setConnected(false)
+	err = p.server.stream(ps.ctx, address, streamid, &pushWriter{push: ps, w: sock})
+	if errors.Is(err, relay.ErrStreamNotExisting) {
+		err = nil
+	}
+	return true, err
+}
+
+func (ps *push) setConnected(connected bool) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.connected = connected
+	if connected {
+		ps.connections++
+		ps.err = nil
+	}
+}
+
+func (ps *push) setError(err error) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.err = err
+}
+
+func (ps *push) status() PushStatus {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	status := PushStatus{
+		Stream:      ps.name,
+		Connected:   ps.connected,
+		Connections: ps.connections,
+		BytesSent:   ps.bytes,
+	}
+	if ps.err != nil {
+		status.Error = ps.err.Error()
+	}
+	return status
+}
+
+// pushWriter counts the bytes written to a target
+type pushWriter struct {
+	push *push
+	w    io.Writer
+}
+
+func (w *pushWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.push.mutex.Lock()
+	w.push.bytes += uint64(n)
+	w.push.mutex.Unlock()
+	return n, err
+}
+
+// redactURL hides the passphrase of a srt:// URL
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	if !query.Has("passphrase") {
+		return rawURL
+	}
+	query.Set("passphrase", "xxxxx")
+	u.RawQuery = query.Encode()
+	return u.String()
+}
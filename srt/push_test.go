@@ -0,0 +1,49 @@
+package srt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/voc/srtrelay/relay"
+)
+
+func TestPusher_Add(t *testing.T) {
+	s := NewServer(&Config{
+		Relay: relay.RelayConfig{BufferSize: 50, PacketSize: 1},
+	})
+	p := s.NewPusher()
+	defer p.Close()
+
+	tests := []struct {
+		name   string
+		target Target
+		err    error
+	}{
+		{"Valid", Target{Name: "cdn", Streams: []string{"live/*"}, URL: "srt://cdn:9000?passphrase=secretsecret"}, nil},
+		{"Exists", Target{Name: "cdn", Streams: []string{"*"}, URL: "srt://other:9000"}, ErrTargetExists},
+		{"NoStreams", Target{Name: "partner", URL: "srt://partner:9000"}, ErrInvalidTarget},
+		{"InvalidURL", Target{Name: "partner", Streams: []string{"*"}, URL: "udp://partner:9000"}, ErrInvalidURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.Add(tt.target); !errors.Is(err, tt.err) {
+				t.Errorf("Got error %v, expected %v", err, tt.err)
+			}
+		})
+	}
+
+	targets := p.Targets()
+	if len(targets) != 1 || targets[0].Name != "cdn" || len(targets[0].Pushes) != 0 {
+		t.Fatalf("Got targets %+v", targets)
+	}
+	if url := targets[0].URL; url != "srt://cdn:9000?passphrase=xxxxx" {
+		t.Errorf("Got URL %s, expected redacted passphrase", url)
+	}
+
+	if err := p.Remove("cdn"); err != nil {
+		t.Error(err)
+	}
+	if err := p.Remove("cdn"); err != ErrTargetNotFound {
+		t.Errorf("Got error %v, expected %v", err, ErrTargetNotFound)
+	}
+}
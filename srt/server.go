@@ -13,12 +13,30 @@ import (
 	"net"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/haivision/srtgo"
 	"github.com/voc/srtrelay/auth"
+	"github.com/voc/srtrelay/dash"
 	"github.com/voc/srtrelay/format"
+	"github.com/voc/srtrelay/hls"
+	"github.com/voc/srtrelay/internal/metrics"
+	"github.com/voc/srtrelay/mpegts"
 	"github.com/voc/srtrelay/relay"
 	"github.com/voc/srtrelay/stream"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const srtSubsystem = "srt"
+
+var syncTimeouts = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(metrics.Namespace, srtSubsystem, "sync_timeouts_total"),
+		Help: "The number of clients falling back to passthrough after the sync timeout",
+	},
+	[]string{"channel_name"},
 )
 
 type Config struct {
@@ -33,7 +51,15 @@ type ServerConfig struct {
 	LossMaxTTL    uint
 	Auth          auth.Authenticator
 	SyncClients   bool
+	SyncTimeout   time.Duration
 	ListenBacklog int
+
+	// passphrase and key length required for all streams, empty disables encryption
+	Passphrase string
+	PBKeyLen   int
+
+	// per-stream encryption, the first match overrides the global passphrase
+	Encryption []Encryption
 }
 
 // Server is an interface for a srt relay server
@@ -41,28 +67,58 @@ type Server interface {
 	Listen(context.Context) error
 	Wait()
 	Handle(context.Context, *srtgo.SrtSocket, *net.UDPAddr)
+	Play(ctx context.Context, address string, streamid *stream.StreamID, w io.Writer) error
 	GetStatistics() []*relay.StreamStatistics
+	GetHealth(name string) (*mpegts.Health, error)
+	GetPlaylist(ctx context.Context, streamid *stream.StreamID, until *hls.Position) (*hls.Playlist, error)
+	GetSegment(ctx context.Context, streamid *stream.StreamID, pos hls.Position) ([]byte, error)
+	GetManifest(streamid *stream.StreamID) (*dash.Manifest, error)
+	GetFragment(streamid *stream.StreamID, file dash.File) ([]byte, error)
 	GetSocketStatistics() []*SocketStatistics
 }
 
+var ErrAccessDenied = errors.New("access denied")
+
 // ServerImpl implements the Server interface
 type ServerImpl struct {
 	config *ServerConfig
 	relay  relay.Relay
 
-	mutex sync.Mutex
-	conns map[*srtConn]bool
-	done  sync.WaitGroup
+	mutex     sync.Mutex
+	conns     map[*srtConn]bool
+	pending   map[string]pendingAuth // auth options of connections not yet accepted
+	encrypted map[string]bool        // streams published with encryption
+	done      sync.WaitGroup
+}
+
+// pendingAuth keeps the auth options from the listen callback until accept
+type pendingAuth struct {
+	options auth.Options
+	created time.Time
 }
 
+// time after which auth options of never accepted connections are dropped
+const pendingAuthTimeout = 10 * time.Second
+
 // NewServer creates a server
 func NewServer(config *Config) *ServerImpl {
-	r := relay.NewRelay(&config.Relay)
-	return &ServerImpl{
-		relay:  r,
-		config: &config.Server,
-		conns:  make(map[*srtConn]bool),
+	s := &ServerImpl{
+		relay:     relay.NewRelay(&config.Relay),
+		config:    &config.Server,
+		conns:     make(map[*srtConn]bool),
+		pending:   make(map[string]pendingAuth),
+		encrypted: make(map[string]bool),
 	}
+	s.relay.OnClose(func(name string) {
+		syncTimeouts.DeleteLabelValues(name)
+		s.setEncrypted(name, false)
+	})
+	return s
+}
+
+// Relay returns the relay the streams are published to
+func (s *ServerImpl) Relay() relay.Relay {
+	return s.relay
 }
 
 // Listen sets up a SRT socket in listen mode
@@ -115,7 +171,8 @@ func (s *ServerImpl) listenCallback(socket *srtgo.SrtSocket, version int, addr *
 	}
 
 	// Check authentication
-	if !s.config.Auth.Authenticate(streamid) {
+	options, ok := s.config.Auth.Authenticate(streamid)
+	if !ok {
 		log.Printf("%s - Stream '%s' access denied\n", addr, streamid)
 		if err := socket.SetRejectReason(srtgo.RejectionReasonUnauthorized); err != nil {
 			log.Printf("Error rejecting stream: %s", err)
@@ -134,7 +191,7 @@ func (s *ServerImpl) listenCallback(socket *srtgo.SrtSocket, version int, addr *
 			return false
 		}
 	case stream.ModePublish:
-		if s.relay.ChannelExists(streamid.Name()) {
+		if !s.relay.CanPublish(streamid.Name(), publisherPolicy(options)) {
 			log.Printf("%s - Stream '%s' already exists", addr, streamid)
 			if err := socket.SetRejectReason(srtgo.RejectionReasonForbidden); err != nil {
 				log.Printf("Error rejecting stream: %s", err)
@@ -143,14 +200,66 @@ func (s *ServerImpl) listenCallback(socket *srtgo.SrtSocket, version int, addr *
 		}
 	}
 
+	// Require encryption before accept
+	if err := s.setEncryption(socket, streamid, options); err != nil {
+		log.Printf("%s - Stream '%s' encryption failed: %v", addr, streamid, err)
+		if err := socket.SetRejectReason(srtgo.RejectionReasonUnacceptable); err != nil {
+			log.Printf("Error rejecting stream: %s", err)
+		}
+		return false
+	}
+
+	s.storeAuth(addr, idstring, options)
 	return true
 }
 
+// storeAuth remembers auth options of a connection for Handle
+func (s *ServerImpl) storeAuth(addr *net.UDPAddr, idstring string, options auth.Options) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// drop options of connections which were never accepted
+	now := time.Now()
+	for key, p := range s.pending {
+		if now.Sub(p.created) > pendingAuthTimeout {
+			delete(s.pending, key)
+		}
+	}
+	s.pending[addr.String()+idstring] = pendingAuth{options: options, created: now}
+}
+
+// loadAuth returns the auth options stored for a connection
+func (s *ServerImpl) loadAuth(addr *net.UDPAddr, idstring string) auth.Options {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := addr.String() + idstring
+	p := s.pending[key]
+	delete(s.pending, key)
+	return p.options
+}
+
+// publisherPolicy returns the publisher policy requested by the authenticator
+func publisherPolicy(options auth.Options) relay.PublisherPolicy {
+	policy := relay.PolicyDefault
+	if options.PublisherPolicy == "" {
+		return policy
+	}
+	if err := policy.UnmarshalText([]byte(options.PublisherPolicy)); err != nil {
+		log.Println("auth:", err)
+		return relay.PolicyDefault
+	}
+	return policy
+}
+
 func (s *ServerImpl) listenAt(ctx context.Context, host string, port uint16) error {
 	options := make(map[string]string)
 	options["blocking"] = "1"
 	options["transtype"] = "live"
 	options["latency"] = strconv.Itoa(int(s.config.Latency))
+	// reject peers without the passphrase of their stream instead of
+	// accepting them unencrypted
+	options["enforcedencryption"] = "1"
 
 	sck := srtgo.NewSrtSocket(host, port, options)
 	if err := sck.SetSockOptInt(srtgo.SRTO_LOSSMAXTTL, int(s.config.LossMaxTTL)); err != nil {
@@ -199,6 +308,7 @@ type srtConn struct {
 	socket   relaySocket
 	address  string
 	streamid *stream.StreamID
+	options  auth.Options
 }
 
 type relaySocket interface {
@@ -229,6 +339,7 @@ func (s *ServerImpl) Handle(ctx context.Context, sock *srtgo.SrtSocket, addr *ne
 		socket:   sock,
 		address:  addr.String(),
 		streamid: &streamid,
+		options:  s.loadAuth(addr, idstring),
 	}
 
 	subctx, cancel := context.WithCancel(ctx)
@@ -248,31 +359,85 @@ func (s *ServerImpl) Handle(ctx context.Context, sock *srtgo.SrtSocket, addr *ne
 
 // play a stream from the server
 func (s *ServerImpl) play(conn *srtConn) error {
-	sub, unsubscribe, err := s.relay.Subscribe(conn.streamid.Name())
+	return s.stream(context.Background(), conn.address, conn.streamid, conn.socket)
+}
+
+// Play authenticates a non-SRT client and streams a channel to it
+// Clients are synchronized like SRT clients. Blocks until the channel is closed,
+// ctx is done or writing fails.
+func (s *ServerImpl) Play(ctx context.Context, address string, streamid *stream.StreamID, w io.Writer) error {
+	if err := s.authorize(streamid); err != nil {
+		return err
+	}
+	return s.stream(ctx, address, streamid, w)
+}
+
+// authorize checks whether a non-SRT client may play a stream
+// Streams requiring encryption are only served to SRT clients.
+func (s *ServerImpl) authorize(streamid *stream.StreamID) error {
+	if streamid.Mode() != stream.ModePlay {
+		return stream.ErrInvalidMode
+	}
+	options, ok := s.config.Auth.Authenticate(*streamid)
+	if !ok {
+		return ErrAccessDenied
+	}
+	if s.encryption(streamid.Name(), options).Passphrase != "" || s.isEncrypted(streamid.Name()) {
+		return ErrEncrypted
+	}
+	return nil
+}
+
+// stream subscribes to a channel and writes it to a client
+// Clients requesting a timeshift start at a keyframe in the past.
+func (s *ServerImpl) stream(ctx context.Context, address string, streamid *stream.StreamID, w io.Writer) error {
+	name := streamid.Name()
+	offset := streamid.Timeshift()
+	var sub *relay.Subscriber
+	var unsubscribe relay.UnsubscribeFunc
+	var err error
+	if offset > 0 {
+		sub, unsubscribe, err = s.relay.SubscribeAt(name, offset)
+	} else {
+		sub, unsubscribe, err = s.relay.Subscribe(name)
+	}
 	if err != nil {
 		return err
 	}
 	defer unsubscribe()
-	log.Printf("%s - play %s\n", conn.address, conn.streamid.Name())
+	stop := context.AfterFunc(ctx, unsubscribe)
+	defer stop()
+	if offset > 0 {
+		log.Printf("%s - play %s %s in the past\n", address, name, offset)
+	} else {
+		log.Printf("%s - play %s\n", address, name)
+	}
 
 	demux := format.NewDemuxer()
 	playing := !s.config.SyncClients
+	start := time.Now()
 	for {
-		buf, ok := <-sub
-
-		buffered := len(sub)
-		if buffered > cap(sub)/2 {
-			log.Printf("%s - %s - %d packets late in buffer\n", conn.address, conn.streamid.Name(), len(sub))
-		}
+		buf, ok := sub.Read()
 
 		// Upstream closed, drop connection
 		if !ok {
-			log.Printf("%s - %s dropped", conn.address, conn.streamid.Name())
+			log.Printf("%s - %s dropped", address, name)
 			return nil
 		}
 
+		buffered := sub.Len()
+		if buffered > sub.Cap()/2 {
+			log.Printf("%s - %s - %d packets late in buffer\n", address, name, buffered)
+		}
+
+		// Fall back to passthrough if no synchronization point was found in time
+		if !playing && s.config.SyncTimeout > 0 && time.Since(start) > s.config.SyncTimeout {
+			log.Printf("%s - %s - sync timeout, falling back to passthrough\n", address, name)
+			syncTimeouts.WithLabelValues(name).Inc()
+			playing = true
+		}
+
 		// Find initial synchronization point
-		// TODO: implement timeout for sync
 		if !playing {
 			init, err := demux.FindInit(buf)
 			if err != nil {
@@ -280,7 +445,7 @@ func (s *ServerImpl) play(conn *srtConn) error {
 			} else if init != nil {
 				for i := range init {
 					buf := init[i]
-					_, err := conn.socket.Write(buf)
+					_, err := w.Write(buf)
 					if err != nil {
 						return err
 					}
@@ -290,8 +455,17 @@ func (s *ServerImpl) play(conn *srtConn) error {
 			continue
 		}
 
-		// Write to socket
-		_, err := conn.socket.Write(buf)
+		// Drop partial PES of streams which did not start yet
+		buf, err = demux.Filter(buf)
+		if err != nil {
+			return err
+		}
+		if len(buf) == 0 {
+			continue
+		}
+
+		// Write to client
+		_, err = w.Write(buf)
 		if err != nil {
 			return err
 		}
@@ -300,13 +474,38 @@ func (s *ServerImpl) play(conn *srtConn) error {
 
 // publish a stream to the server
 func (s *ServerImpl) publish(conn *srtConn) error {
-	pub, err := s.relay.Publish(conn.streamid.Name())
+	// the pusher starts with the new channel, so remember the encryption before
+	name := conn.streamid.Name()
+	encrypted := s.encryption(name, conn.options).Passphrase != ""
+	if encrypted {
+		s.setEncrypted(name, true)
+	}
+	pub, kicked, err := s.relay.Publish(name, publisherPolicy(conn.options))
 	if err != nil {
+		if encrypted && !s.relay.ChannelExists(name) {
+			s.setEncrypted(name, false)
+		}
 		return err
 	}
+	if !encrypted {
+		s.setEncrypted(name, false)
+	}
 	defer close(pub)
-	log.Printf("%s - publish %s\n", conn.address, conn.streamid.Name())
+	log.Printf("%s - publish %s\n", conn.address, name)
+
+	// Disconnect when replaced by another publisher
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-kicked:
+			log.Printf("%s - %s replaced by new publisher", conn.address, name)
+			conn.socket.Close()
+		case <-done:
+		}
+	}()
 
+	unwrap := format.NewUnwrapper()
 	buf := make([]byte, 2048)
 	for {
 		n, err := conn.socket.Read(buf)
@@ -315,7 +514,9 @@ func (s *ServerImpl) publish(conn *srtConn) error {
 		if n > 0 {
 			tmp := make([]byte, n)
 			copy(tmp, buf[:n])
-			pub <- tmp
+			for _, payload := range unwrap.Unwrap(tmp) {
+				pub <- payload
+			}
 		}
 
 		if err != nil {
@@ -348,6 +549,45 @@ func (s *ServerImpl) GetStatistics() []*relay.StreamStatistics {
 	return streams
 }
 
+// GetHealth returns the analyzer results of a stream
+func (s *ServerImpl) GetHealth(name string) (*mpegts.Health, error) {
+	return s.relay.GetHealth(name)
+}
+
+// GetPlaylist authenticates a HLS client and returns the playlist of a stream
+// If until is set, blocks until the playlist contains the position.
+func (s *ServerImpl) GetPlaylist(ctx context.Context, streamid *stream.StreamID, until *hls.Position) (*hls.Playlist, error) {
+	if err := s.authorize(streamid); err != nil {
+		return nil, err
+	}
+	return s.relay.GetPlaylist(ctx, streamid.Name(), until)
+}
+
+// GetSegment authenticates a HLS client and returns a segment or part of a stream
+func (s *ServerImpl) GetSegment(ctx context.Context, streamid *stream.StreamID, pos hls.Position) ([]byte, error) {
+	if err := s.authorize(streamid); err != nil {
+		return nil, err
+	}
+	return s.relay.GetSegment(ctx, streamid.Name(), pos)
+}
+
+// GetManifest authenticates a MPEG-DASH client and returns the manifest of a stream
+func (s *ServerImpl) GetManifest(streamid *stream.StreamID) (*dash.Manifest, error) {
+	if err := s.authorize(streamid); err != nil {
+		return nil, err
+	}
+	return s.relay.GetManifest(streamid.Name())
+}
+
+// GetFragment authenticates a MPEG-DASH client and returns an init or media
+// segment of a stream
+func (s *ServerImpl) GetFragment(streamid *stream.StreamID, file dash.File) ([]byte, error) {
+	if err := s.authorize(streamid); err != nil {
+		return nil, err
+	}
+	return s.relay.GetFragment(streamid.Name(), file)
+}
+
 type SocketStatistics struct {
 	Address  string          `json:"address"`
 	StreamID string          `json:"stream_id"`
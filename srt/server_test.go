@@ -1,6 +1,7 @@
 package srt
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"reflect"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/haivision/srtgo"
+	"github.com/voc/srtrelay/auth"
 	"github.com/voc/srtrelay/relay"
 	"github.com/voc/srtrelay/stream"
 )
@@ -34,7 +36,7 @@ func TestServerImpl_GetStatistics(t *testing.T) {
 		relay:  r,
 		config: &ServerConfig{Addresses: []string{"127.0.0.1:1337", "[::1]:1337"}, PublicAddress: "testserver.de:1337"},
 	}
-	if _, err := r.Publish("s1"); err != nil {
+	if _, _, err := r.Publish("s1", relay.PolicyDefault); err != nil {
 		t.Fatal(err)
 	}
 	if _, _, err := r.Subscribe("s1"); err != nil {
@@ -134,3 +136,83 @@ func TestPublish(t *testing.T) {
 		t.Errorf("Wrong number of packets written: got %d, expected %d", wr.numWritten, 100)
 	}
 }
+
+func TestPlaySyncTimeout(t *testing.T) {
+	s := NewServer(&Config{
+		Server: ServerConfig{SyncClients: true, SyncTimeout: 20 * time.Millisecond},
+		Relay:  relay.RelayConfig{BufferSize: 50 * 188, PacketSize: 188},
+	})
+
+	// MPEG-TS null packets never contain a synchronization point
+	nullPacket := make([]byte, 188)
+	nullPacket[0], nullPacket[1], nullPacket[2], nullPacket[3] = 0x47, 0x1f, 0xff, 0x10
+
+	pub, _, err := s.relay.Publish("test", relay.PolicyDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := stream.NewStreamID("test", "", stream.ModePlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wr := testSocket{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := s.play(&srtConn{
+			socket:   &wr,
+			streamid: id,
+			address:  "player:1234",
+		})
+		if err != nil {
+			t.Error("player error", err)
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 50; i++ {
+		pub <- nullPacket
+		time.Sleep(1 * time.Millisecond)
+	}
+	close(pub)
+	<-done
+
+	if wr.numWritten == 0 {
+		t.Error("Client should receive packets after sync timeout")
+	}
+}
+
+func TestServerImpl_Play(t *testing.T) {
+	s := NewServer(&Config{
+		Server: ServerConfig{Auth: auth.NewStaticAuth(auth.StaticAuthConfig{Allow: []string{"play/*/secret"}})},
+		Relay:  relay.RelayConfig{BufferSize: 50, PacketSize: 1316},
+	})
+	if _, _, err := s.relay.Publish("test", relay.PolicyDefault); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		stream   string
+		password string
+		expected error
+	}{
+		{"AccessDenied", "test", "wrong", ErrAccessDenied},
+		{"NotExisting", "other", "secret", relay.ErrStreamNotExisting},
+		{"Canceled", "test", "secret", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := stream.NewStreamID(tt.stream, tt.password, stream.ModePlay)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if err := s.Play(ctx, "player:1234", id, &testSocket{}); err != tt.expected {
+				t.Errorf("Got error %v, expected %v", err, tt.expected)
+			}
+		})
+	}
+}
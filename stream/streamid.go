@@ -3,7 +3,9 @@ package stream
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/IGLOU-EU/go-wildcard/v2"
 )
@@ -14,7 +16,7 @@ var (
 	ErrInvalidSlashes      = errors.New("invalid number of slashes, must be 1 or 2")
 	ErrInvalidMode         = errors.New("invalid mode")
 	ErrMissingName         = errors.New("missing name after slash")
-	ErrInvalidNamePassword = errors.New("name/password is not allowed to contain slashes")
+	ErrInvalidNamePassword = errors.New("name/password is not allowed to contain both slashes and commas")
 	ErrInvalidValue        = fmt.Errorf("invalid value")
 )
 
@@ -45,9 +47,13 @@ type StreamID struct {
 	name     string
 	password string
 	username string
+
+	// how far in the past playback starts, 0 plays from the live edge
+	timeshift time.Duration
 }
 
 // NewStreamID creates new StreamID
+// Names or passwords containing slashes use the #!:: format.
 // returns error if mode is invalid.
 // id is nil on error
 func NewStreamID(name string, password string, mode Mode) (*StreamID, error) {
@@ -67,6 +73,7 @@ func NewStreamID(name string, password string, mode Mode) (*StreamID, error) {
 // FromString reads a streamid from a string.
 // The accepted old stream id format is <mode>/<password>/<password>. The second slash and password is
 // optional and defaults to empty. The new format is `#!::m=(request|publish),r=(stream-key),u=(username),s=(password)`
+// with an optional t=-(seconds) to start playback in the past.
 // If error is not nil then StreamID will remain unchanged.
 func (s *StreamID) FromString(src string) error {
 
@@ -92,6 +99,14 @@ func (s *StreamID) FromString(src string) error {
 				s.password = value
 
 			case "t":
+				// negative seconds request timeshift playback, other values
+				// are the SRT connection type and ignored
+				if seconds, err := strconv.Atoi(value); err == nil {
+					if seconds > 0 {
+						return ErrInvalidValue
+					}
+					s.timeshift = time.Duration(-seconds) * time.Second
+				}
 
 			case "m":
 				switch value {
@@ -155,11 +170,8 @@ func (s *StreamID) toString() (string, error) {
 	default:
 		return "", ErrInvalidMode
 	}
-	if strings.Contains(s.name, "/") {
-		return "", ErrInvalidNamePassword
-	}
-	if strings.Contains(s.password, "/") {
-		return "", ErrInvalidNamePassword
+	if strings.Contains(s.name, "/") || strings.Contains(s.password, "/") {
+		return s.toIDString()
 	}
 	if len(s.password) == 0 {
 		return fmt.Sprintf("%s/%s", mode, s.name), nil
@@ -167,6 +179,22 @@ func (s *StreamID) toString() (string, error) {
 	return fmt.Sprintf("%s/%s/%s", mode, s.name, s.password), nil
 }
 
+// toIDString formats the streamid in the #!:: format
+func (s *StreamID) toIDString() (string, error) {
+	if strings.Contains(s.name, ",") || strings.Contains(s.password, ",") {
+		return "", ErrInvalidNamePassword
+	}
+	mode := "request"
+	if s.mode == ModePublish {
+		mode = "publish"
+	}
+	id := fmt.Sprintf("%sm=%s,r=%s", IDPrefix, mode, s.name)
+	if len(s.password) > 0 {
+		id += ",s=" + s.password
+	}
+	return id, nil
+}
+
 // Match checks a streamid against a string with wildcards.
 // The string may contain * to match any number of characters.
 func (s StreamID) Match(pattern string) bool {
@@ -192,3 +220,14 @@ func (s StreamID) Password() string {
 func (s StreamID) Username() string {
 	return s.username
 }
+
+// Timeshift returns how far in the past playback should start
+func (s StreamID) Timeshift() time.Duration {
+	return s.timeshift
+}
+
+// WithTimeshift requests playback to start offset in the past
+func (s *StreamID) WithTimeshift(offset time.Duration) *StreamID {
+	s.timeshift = offset
+	return s
+}
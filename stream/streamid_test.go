@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestParseStreamID(t *testing.T) {
@@ -101,8 +102,10 @@ func TestNewStreamID(t *testing.T) {
 		wantErr      error
 	}{
 		{"InvalidMode", "s1", 0, "", "", ErrInvalidMode},
-		{"InvalidName", "s1/", ModePlay, "", "", ErrInvalidNamePassword},
-		{"InvalidPass", "s1", ModePlay, "foo/bar", "", ErrInvalidNamePassword},
+		{"InvalidName", "s1/,", ModePlay, "", "", ErrInvalidNamePassword},
+		{"InvalidPass", "s1", ModePlay, "foo/bar,", "", ErrInvalidNamePassword},
+		{"SlashName", "live/s1", ModePlay, "", "#!::m=request,r=live/s1", nil},
+		{"SlashPass", "s1", ModePublish, "foo/bar", "#!::m=publish,r=s1,s=foo/bar", nil},
 		{"ValidPlay", "s1", ModePlay, "", "play/s1", nil},
 		{"ValidPublish", "s1", ModePublish, "", "publish/s1", nil},
 		{"ValidPlayPass", "s1", ModePlay, "foo", "play/s1/foo", nil},
@@ -126,6 +129,10 @@ func TestNewStreamID(t *testing.T) {
 			if str := id.String(); str != tt.wantStreamID {
 				t.Errorf("NewStreamID() got String = %v, want %v", str, tt.wantStreamID)
 			}
+			var parsed StreamID
+			if err := parsed.FromString(id.String()); err != nil || parsed.Name() != tt.argName || parsed.Password() != tt.argPassword {
+				t.Errorf("FromString() got %q/%q, %v", parsed.Name(), parsed.Password(), err)
+			}
 		})
 	}
 }
@@ -154,3 +161,30 @@ func TestStreamID_Match(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamID_Timeshift(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		expected time.Duration
+		wantErr  error
+	}{
+		{"Offset", "#!::m=request,r=foo,t=-300", 300 * time.Second, nil},
+		{"Live", "#!::m=request,r=foo,t=0", 0, nil},
+		{"ConnectionType", "#!::m=request,r=foo,t=stream", 0, nil},
+		{"Future", "#!::m=request,r=foo,t=10", 0, ErrInvalidValue},
+		{"OldFormat", "play/foo", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s StreamID
+			err := s.FromString(tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Got error %v, expected %v", err, tt.wantErr)
+			}
+			if err == nil && s.Timeshift() != tt.expected {
+				t.Errorf("Got timeshift %s, expected %s", s.Timeshift(), tt.expected)
+			}
+		})
+	}
+}
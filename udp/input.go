@@ -0,0 +1,69 @@
+package udp
+
+import (
+	"context"
+	"net"
+
+	"github.com/voc/srtrelay/format"
+)
+
+// Input receives a stream sent to a unicast address or multicast group
+// RTP-wrapped MPEG-TS is detected automatically, its packets are reordered
+// and the RTP headers stripped. Other datagrams are published as is.
+type Input struct {
+	endpoint *endpoint
+}
+
+// NewInput creates an input listening on a udp:// or rtp:// URL
+// For multicast groups the interface query parameter selects the interface
+// the group is joined on.
+func NewInput(rawURL string) (*Input, error) {
+	ep, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Input{endpoint: ep}, nil
+}
+
+// Run publishes received datagrams until ctx is done or receiving fails
+func (in *Input) Run(ctx context.Context, pub chan<- []byte) error {
+	var conn *net.UDPConn
+	var err error
+	if in.endpoint.addr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp", in.endpoint.iface, in.endpoint.addr)
+	} else {
+		conn, err = net.ListenUDP("udp", in.endpoint.addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	unwrap := format.NewUnwrapper()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		tmp := make([]byte, n)
+		copy(tmp, buf[:n])
+		for _, payload := range unwrap.Unwrap(tmp) {
+			select {
+			case pub <- payload:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
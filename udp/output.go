@@ -0,0 +1,165 @@
+package udp
+
+import (
+	"log"
+	"net"
+	"sync"
+
+	"github.com/voc/srtrelay/format"
+	"github.com/voc/srtrelay/relay"
+)
+
+// Output sends a stream of the relay to a unicast address or multicast group
+// The stream is sent from the moment it is published until it ends and
+// resumes when it is published again. Data is passed through as is, split
+// into datagrams of at most 7 MPEG-TS packets, which are optionally wrapped
+// in RTP.
+type Output struct {
+	relay relay.Relay
+	name  string
+	dest  *net.UDPAddr
+	conn  *net.UDPConn
+	rtp   *format.RTPWrapper // nil without RTP
+
+	mutex  sync.Mutex
+	closed bool
+	unsub  relay.UnsubscribeFunc
+	done   chan struct{} // nil if not running
+}
+
+// NewOutput creates an output sending the stream name to a udp:// or rtp:// URL
+// For multicast groups the interface and ttl query parameters select the
+// outgoing interface and the TTL of the datagrams.
+func NewOutput(r relay.Relay, name, rawURL string) (*Output, error) {
+	ep, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	local, err := ep.localAddr()
+	if err != nil {
+		return nil, err
+	}
+	network := "udp6"
+	ipv4 := ep.addr.IP.To4() != nil
+	if ipv4 {
+		network = "udp4"
+	}
+	conn, err := net.ListenUDP(network, local)
+	if err != nil {
+		return nil, err
+	}
+	if ep.ttl > 0 && ep.addr.IP.IsMulticast() {
+		if err := setMulticastTTL(conn, ipv4, ep.ttl); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	o := &Output{
+		relay: r,
+		name:  name,
+		dest:  ep.addr,
+		conn:  conn,
+	}
+	if ep.rtp {
+		o.rtp = format.NewRTPWrapper()
+	}
+	r.OnChannel(o.onChannel)
+	o.start()
+	return o, nil
+}
+
+// onChannel starts sending when the stream is published
+func (o *Output) onChannel(name string) {
+	if name == o.name {
+		o.start()
+	}
+}
+
+// start sends the stream if it exists and is not sent yet
+func (o *Output) start() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.closed || o.done != nil {
+		return
+	}
+	sub, unsub, err := o.relay.Subscribe(o.name)
+	if err != nil {
+		return
+	}
+	o.unsub = unsub
+	o.done = make(chan struct{})
+	log.Printf("Started UDP output of %s to %s", o.name, o.dest)
+	go o.run(sub, o.done)
+}
+
+// run sends the stream until it ends or the output is closed
+func (o *Output) run(sub *relay.Subscriber, done chan struct{}) {
+	defer close(done)
+	var failed error
+	for {
+		buf, ok := sub.Read()
+		if !ok {
+			if sub, ok = o.resubscribe(); ok {
+				continue
+			}
+			break
+		}
+
+		// log failures once until sending succeeds again
+		err := o.send(buf)
+		if err != nil && failed == nil {
+			log.Printf("UDP output of %s to %s failed: %v", o.name, o.dest, err)
+		}
+		failed = err
+	}
+	log.Printf("Stopped UDP output of %s to %s", o.name, o.dest)
+}
+
+// resubscribe continues sending after the subscriber ended, e.g. because it
+// fell behind or the stream was published again in the meantime
+func (o *Output) resubscribe() (*relay.Subscriber, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if !o.closed {
+		sub, unsub, err := o.relay.Subscribe(o.name)
+		if err == nil {
+			o.unsub = unsub
+			return sub, true
+		}
+	}
+	o.unsub = nil
+	o.done = nil
+	return nil, false
+}
+
+// send writes a buffer as one or more datagrams
+func (o *Output) send(buf []byte) error {
+	for len(buf) > 0 {
+		n := min(len(buf), datagramSize)
+		datagram := buf[:n]
+		if o.rtp != nil {
+			datagram = o.rtp.Wrap(datagram)
+		}
+		if _, err := o.conn.WriteToUDP(datagram, o.dest); err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// Close stops sending and waits until the output stopped
+func (o *Output) Close() {
+	o.mutex.Lock()
+	o.closed = true
+	unsub, done := o.unsub, o.done
+	o.mutex.Unlock()
+	if unsub != nil {
+		unsub()
+	}
+	if done != nil {
+		<-done
+	}
+	o.conn.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package udp
+
+import (
+	"errors"
+	"net"
+)
+
+// setMulticastTTL is not supported on this platform
+func setMulticastTTL(conn *net.UDPConn, ipv4 bool, ttl int) error {
+	return errors.ErrUnsupported
+}
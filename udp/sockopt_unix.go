@@ -0,0 +1,28 @@
+//go:build unix
+
+package udp
+
+import (
+	"net"
+	"syscall"
+)
+
+// setMulticastTTL sets the TTL of multicast datagrams sent by conn
+func setMulticastTTL(conn *net.UDPConn, ipv4 bool, ttl int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv4 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, ttl)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
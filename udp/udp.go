@@ -0,0 +1,84 @@
+// Package udp bridges MPEG-TS over UDP, unicast or multicast and optionally
+// wrapped in RTP, to the relay
+package udp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/voc/srtrelay/mpegts"
+)
+
+// maximum payload of outgoing datagrams, 7 MPEG-TS packets as usual for UDP
+const datagramSize = 7 * mpegts.PacketLen
+
+var ErrInvalidURL = errors.New("invalid udp url")
+
+// endpoint is a parsed udp:// or rtp:// URL
+type endpoint struct {
+	addr  *net.UDPAddr
+	iface *net.Interface // nil for the default interface
+	ttl   int            // multicast TTL, 0 keeps the system default
+	rtp   bool           // MPEG-TS is wrapped in RTP
+}
+
+// parseURL parses a URL of the form udp://host:port?interface=eth0&ttl=4
+// The rtp scheme selects RTP-wrapped MPEG-TS. The host may be omitted for
+// inputs to listen on all addresses.
+func parseURL(rawURL string) (*endpoint, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if (u.Scheme != "udp" && u.Scheme != "rtp") || u.Port() == "" {
+		return nil, fmt.Errorf("%w: expected udp://host:port or rtp://host:port", ErrInvalidURL)
+	}
+	addr, err := net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+
+	ep := &endpoint{addr: addr, rtp: u.Scheme == "rtp"}
+	query := u.Query()
+	if name := query.Get("interface"); name != "" {
+		ep.iface, err = net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+		}
+	}
+	if query.Has("ttl") {
+		ep.ttl, err = strconv.Atoi(query.Get("ttl"))
+		if err != nil || ep.ttl < 1 || ep.ttl > 255 {
+			return nil, fmt.Errorf("%w: invalid ttl %s", ErrInvalidURL, query.Get("ttl"))
+		}
+	}
+	return ep, nil
+}
+
+// localAddr returns the local address for sending to the endpoint
+// Binding to an address of the interface selects it for IPv4 multicast,
+// IPv6 multicast uses the zone of the destination instead.
+func (ep *endpoint) localAddr() (*net.UDPAddr, error) {
+	local := &net.UDPAddr{}
+	if ep.iface == nil || !ep.addr.IP.IsMulticast() {
+		return local, nil
+	}
+	if ep.addr.IP.To4() == nil {
+		ep.addr.Zone = ep.iface.Name
+		return local, nil
+	}
+	addrs, err := ep.iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			local.IP = ipnet.IP
+			return local, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no IPv4 address", ep.iface.Name)
+}
@@ -0,0 +1,159 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/voc/srtrelay/relay"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		addr string
+		ttl  int
+		err  error
+	}{
+		{"Unicast", "udp://127.0.0.1:1234", "127.0.0.1:1234", 0, nil},
+		{"AllAddresses", "udp://:1234", ":1234", 0, nil},
+		{"Multicast", "udp://239.0.0.1:1234?ttl=4", "239.0.0.1:1234", 4, nil},
+		{"RTP", "rtp://239.0.0.1:1234", "239.0.0.1:1234", 0, nil},
+		{"Scheme", "srt://127.0.0.1:1234", "", 0, ErrInvalidURL},
+		{"NoPort", "udp://127.0.0.1", "", 0, ErrInvalidURL},
+		{"InvalidTTL", "udp://239.0.0.1:1234?ttl=0", "", 0, ErrInvalidURL},
+		{"UnknownInterface", "udp://239.0.0.1:1234?interface=doesnotexist0", "", 0, ErrInvalidURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ep, err := parseURL(tt.url)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Got error %v, expected %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if ep.addr.String() != tt.addr || ep.ttl != tt.ttl {
+				t.Errorf("Got %s ttl %d, expected %s ttl %d", ep.addr, ep.ttl, tt.addr, tt.ttl)
+			}
+		})
+	}
+}
+
+// freePort returns a currently unused local UDP port
+func freePort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestInput_Run(t *testing.T) {
+	url := fmt.Sprintf("udp://127.0.0.1:%d", freePort(t))
+	in, err := NewInput(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pub := make(chan []byte, 10)
+	res := make(chan error, 1)
+	go func() {
+		res <- in.Run(ctx, pub)
+	}()
+
+	ep, _ := parseURL(url)
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the listener may not be ready yet
+	var got []byte
+	for range 50 {
+		if _, err := conn.WriteToUDP([]byte{1, 2, 3}, ep.addr); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got = <-pub:
+		case <-time.After(10 * time.Millisecond):
+			continue
+		}
+		break
+	}
+	if len(got) != 3 || got[0] != 1 {
+		t.Errorf("Got %x, expected datagram", got)
+	}
+
+	cancel()
+	if err := <-res; err != context.Canceled {
+		t.Errorf("Got %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	tests := []struct {
+		scheme string
+		header int
+	}{
+		{"udp", 0},
+		{"rtp", 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			testOutput(t, tt.scheme, tt.header)
+		})
+	}
+}
+
+func testOutput(t *testing.T, scheme string, header int) {
+	recv, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close()
+
+	r := relay.NewRelay(&relay.RelayConfig{BufferSize: 2000 * 10, PacketSize: 2000})
+	o, err := NewOutput(r, "test", scheme+"://"+recv.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	// starts when the stream is published and after publishing again
+	for range 2 {
+		pub, _, err := r.Publish("test", relay.PolicyDefault)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub <- make([]byte, 2000)
+
+		// buffers are split into datagrams of 7 packets
+		buf := make([]byte, 4096)
+		for _, expected := range []int{datagramSize, 2000 - datagramSize} {
+			if err := recv.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			n, err := recv.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != header+expected {
+				t.Errorf("Got datagram of %d bytes, expected %d", n, header+expected)
+			}
+			if header > 0 && buf[0]>>6 != 2 {
+				t.Errorf("Got datagram without RTP header %x", buf[:header])
+			}
+		}
+		close(pub)
+		time.Sleep(20 * time.Millisecond)
+	}
+}